@@ -0,0 +1,297 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package progpAPI
+
+import (
+	"context"
+	"sync"
+)
+
+// The async functions registered with RegisteredFunction.WithAbortSignal receive
+// an extra javascript argument, which is an AbortSignal or an object having a signal
+// property. When the script aborts, the context.Context of the function is cancelled
+// and the callback receives an AbortError. The calls done by the function after that
+// are ignored, which allows the function to finish his work without checking for it.
+
+// AbortError is the error given to the callback of a call aborted by javascript.
+// His message starts with "AbortError", like the name of the javascript error.
+type AbortError struct {
+}
+
+func (m *AbortError) Error() string {
+	return "AbortError: This operation was aborted"
+}
+
+// AbortableCall links a call to the AbortSignal given by javascript.
+// A nil *AbortableCall is valid and means that no signal has been given.
+type AbortableCall struct {
+	abortId     int64
+	mutex       sync.Mutex
+	isDone      bool
+	isKeptAlive bool
+	cancel      context.CancelCauseFunc
+	callback    JsFunction
+}
+
+var gAbortableCalls = make(map[int64]*AbortableCall)
+var gAbortableCallsMutex sync.Mutex
+
+// NewAbortableCall is called by the generated code with the id which the engine
+// has associated to the signal. Returns nil if abortId is 0, which means no signal.
+// The call is only known by TriggerAbortSignal once Start is called.
+func NewAbortableCall(abortId int64) *AbortableCall {
+	if abortId == 0 {
+		return nil
+	}
+
+	return &AbortableCall{abortId: abortId}
+}
+
+// Start is called by the generated code once the arguments are decoded,
+// since the engine only watches the signal if the binding succeeds.
+func (m *AbortableCall) Start() {
+	if m == nil {
+		return
+	}
+
+	gAbortableCallsMutex.Lock()
+	gAbortableCalls[m.abortId] = m
+	gAbortableCallsMutex.Unlock()
+}
+
+// Release is called by the generated code when the call fails after Start,
+// in which case the callback won't be called and the signal isn't watched.
+func (m *AbortableCall) Release() {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	m.isDone = true
+	m.mutex.Unlock()
+
+	m.unregister()
+
+	if m.cancel != nil {
+		m.cancel(context.Canceled)
+	}
+}
+
+// IsAbortableCallActive returns true if the call associated to abortId isn't done.
+// It's used by the engine, which removes the listener of the signal once the call is done.
+func IsAbortableCallActive(abortId int64) bool {
+	gAbortableCallsMutex.Lock()
+	defer gAbortableCallsMutex.Unlock()
+
+	return gAbortableCalls[abortId] != nil
+}
+
+// TriggerAbortSignal is called by the engine when the signal associated to abortId is aborted.
+// Does nothing if the call is already finished.
+func TriggerAbortSignal(abortId int64) {
+	gAbortableCallsMutex.Lock()
+	m := gAbortableCalls[abortId]
+	gAbortableCallsMutex.Unlock()
+
+	if m != nil {
+		m.abort()
+	}
+}
+
+// WrapContext returns a context which is cancelled when the call is aborted.
+func (m *AbortableCall) WrapContext(ctx context.Context) context.Context {
+	if m == nil {
+		return ctx
+	}
+
+	ctx, m.cancel = context.WithCancelCause(ctx)
+	return ctx
+}
+
+// WrapCallback returns a callback which receives an AbortError when the call is aborted.
+// The first call of the returned function ends the call, unless KeepAlive is used.
+func (m *AbortableCall) WrapCallback(callback JsFunction) JsFunction {
+	if m == nil {
+		return callback
+	}
+
+	m.callback = callback
+	return &abortableJsFunction{call: m, inner: callback}
+}
+
+func (m *AbortableCall) abort() {
+	m.mutex.Lock()
+
+	if m.isDone {
+		m.mutex.Unlock()
+		return
+	}
+
+	m.isDone = true
+	m.mutex.Unlock()
+
+	m.unregister()
+	err := &AbortError{}
+
+	if m.cancel != nil {
+		m.cancel(err)
+	}
+
+	if m.callback != nil {
+		m.callback.CallWithError(err)
+	}
+}
+
+// settle is called before calling the callback, and returns false if it must not be called.
+func (m *AbortableCall) settle() bool {
+	m.mutex.Lock()
+
+	if m.isDone {
+		m.mutex.Unlock()
+		return false
+	}
+
+	if m.isKeptAlive {
+		m.mutex.Unlock()
+		return true
+	}
+
+	m.isDone = true
+	m.mutex.Unlock()
+
+	m.unregister()
+
+	// The work is done, it releases the resources of the context.
+	if m.cancel != nil {
+		m.cancel(context.Canceled)
+	}
+
+	return true
+}
+
+func (m *AbortableCall) keepAlive() {
+	m.mutex.Lock()
+	m.isKeptAlive = true
+	m.mutex.Unlock()
+}
+
+func (m *AbortableCall) unregister() {
+	gAbortableCallsMutex.Lock()
+	delete(gAbortableCalls, m.abortId)
+	gAbortableCallsMutex.Unlock()
+}
+
+// UnwrapJsFunction returns the function of the engine, if the function is a wrapper
+// like the callbacks of the calls using an AbortSignal. It's used by the function callers.
+func UnwrapJsFunction(jsFunction JsFunction) JsFunction {
+	for {
+		wrapper, ok := jsFunction.(interface{ unwrapJsFunction() JsFunction })
+		if !ok {
+			return jsFunction
+		}
+
+		jsFunction = wrapper.unwrapJsFunction()
+	}
+}
+
+//region abortableJsFunction
+
+type abortableJsFunction struct {
+	call  *AbortableCall
+	inner JsFunction
+}
+
+func (m *abortableJsFunction) unwrapJsFunction() JsFunction {
+	return m.inner
+}
+
+func (m *abortableJsFunction) CallWithUndefined() {
+	if m.call.settle() {
+		m.inner.CallWithUndefined()
+	}
+}
+
+func (m *abortableJsFunction) CallWithError(err error) {
+	if m.call.settle() {
+		m.inner.CallWithError(err)
+	}
+}
+
+func (m *abortableJsFunction) KeepAlive() {
+	m.call.keepAlive()
+	m.inner.KeepAlive()
+}
+
+func (m *abortableJsFunction) DynamicFunctionCaller(values ...any) {
+	if m.call.settle() {
+		m.inner.DynamicFunctionCaller(values...)
+	}
+}
+
+func (m *abortableJsFunction) EnabledResourcesAutoDisposing(currentResourceContainer *SharedResourceContainer) {
+	m.inner.EnabledResourcesAutoDisposing(currentResourceContainer)
+}
+
+func (m *abortableJsFunction) CallWithArrayBuffer2(buffer []byte) {
+	if m.call.settle() {
+		m.inner.CallWithArrayBuffer2(buffer)
+	}
+}
+
+func (m *abortableJsFunction) CallWithString2(value string) {
+	if m.call.settle() {
+		m.inner.CallWithString2(value)
+	}
+}
+
+func (m *abortableJsFunction) CallWithStringBuffer2(value []byte) {
+	if m.call.settle() {
+		m.inner.CallWithStringBuffer2(value)
+	}
+}
+
+func (m *abortableJsFunction) CallWithDouble1(value float64) {
+	if m.call.settle() {
+		m.inner.CallWithDouble1(value)
+	}
+}
+
+func (m *abortableJsFunction) CallWithDouble2(value float64) {
+	if m.call.settle() {
+		m.inner.CallWithDouble2(value)
+	}
+}
+
+func (m *abortableJsFunction) CallWithBool2(value bool) {
+	if m.call.settle() {
+		m.inner.CallWithBool2(value)
+	}
+}
+
+func (m *abortableJsFunction) CallWithResource1(value *SharedResource) {
+	if m.call.settle() {
+		m.inner.CallWithResource1(value)
+	}
+}
+
+func (m *abortableJsFunction) CallWithResource2(value *SharedResource) {
+	if m.call.settle() {
+		m.inner.CallWithResource2(value)
+	}
+}
+
+//endregion
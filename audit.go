@@ -0,0 +1,411 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package progpAPI
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// The audit log records the calls of the Go functions by javascript: which security group
+// called which function, with which arguments, and how the call ended. It's fed by an
+// interceptor, see FunctionRegistry.Use, and writes the entries into one or more sinks.
+//
+//	auditLog := progpAPI.NewAuditLog(progpAPI.NewJsonLinesAuditSink(file))
+//	auditLog.SetRedaction("crypto.*", progpAPI.RedactAllArgs)
+//	progpAPI.GetFunctionRegistry().Use(auditLog.Interceptor())
+//
+// The interceptor must be installed before the others, otherwise the calls they
+// reject aren't seen.
+
+//region AuditEntry
+
+type AuditOutcome string
+
+const (
+	AuditOutcomeSuccess AuditOutcome = "success"
+	AuditOutcomeError   AuditOutcome = "error"
+	AuditOutcomePanic   AuditOutcome = "panic"
+
+	// AuditOutcomeRejected is for a call short-circuited by an interceptor.
+	AuditOutcomeRejected AuditOutcome = "rejected"
+)
+
+type AuditEntry struct {
+	Time          time.Time `json:"time"`
+	SecurityGroup string    `json:"securityGroup"`
+	FunctionGroup string    `json:"functionGroup"`
+	Function      string    `json:"function"`
+	GoFunction    string    `json:"goFunction"`
+
+	// Duration is in nanoseconds, like time.Duration.
+	Duration time.Duration `json:"duration"`
+	Outcome  AuditOutcome  `json:"outcome"`
+	Error    string        `json:"error,omitempty"`
+
+	// Args are the arguments once redacted. The values which can't be encoded
+	// in JSON, like the functions and the channels, are replaced by their type.
+	Args []any `json:"args,omitempty"`
+}
+
+//endregion
+
+//region Sinks
+
+// AuditSink receives the entries of an audit log.
+// The calls can come from several goroutines at once.
+type AuditSink interface {
+	WriteAuditEntry(entry *AuditEntry) error
+}
+
+// AuditSinkFunc allows using a function as an AuditSink.
+type AuditSinkFunc func(entry *AuditEntry) error
+
+func (m AuditSinkFunc) WriteAuditEntry(entry *AuditEntry) error {
+	return m(entry)
+}
+
+// JsonLinesAuditSink writes each entry as a line of JSON.
+type JsonLinesAuditSink struct {
+	writer io.Writer
+	mutex  sync.Mutex
+}
+
+func NewJsonLinesAuditSink(writer io.Writer) *JsonLinesAuditSink {
+	return &JsonLinesAuditSink{writer: writer}
+}
+
+// OpenJsonLinesAuditFile returns a sink appending the entries to this file, which is created if needed.
+// The file must be closed by the caller once the sink isn't used anymore.
+func OpenJsonLinesAuditFile(filePath string) (*JsonLinesAuditSink, *os.File, error) {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return NewJsonLinesAuditSink(file), file, nil
+}
+
+func (m *JsonLinesAuditSink) WriteAuditEntry(entry *AuditEntry) error {
+	asBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	asBytes = append(asBytes, '\n')
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	_, err = m.writer.Write(asBytes)
+	return err
+}
+
+// RingBufferAuditSink keeps the last entries in memory.
+type RingBufferAuditSink struct {
+	entries []*AuditEntry
+	next    int
+	count   int
+	mutex   sync.Mutex
+}
+
+func NewRingBufferAuditSink(size int) *RingBufferAuditSink {
+	if size <= 0 {
+		panic("the size of the ring buffer must be positive")
+	}
+
+	return &RingBufferAuditSink{entries: make([]*AuditEntry, size)}
+}
+
+func (m *RingBufferAuditSink) WriteAuditEntry(entry *AuditEntry) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.entries[m.next] = entry
+	m.next = (m.next + 1) % len(m.entries)
+	m.count = min(m.count+1, len(m.entries))
+
+	return nil
+}
+
+// GetEntries returns the entries kept, from the oldest to the newest.
+func (m *RingBufferAuditSink) GetEntries() []*AuditEntry {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	res := make([]*AuditEntry, 0, m.count)
+	first := (m.next - m.count + len(m.entries)) % len(m.entries)
+
+	for i := 0; i < m.count; i++ {
+		res = append(res, m.entries[(first+i)%len(m.entries)])
+	}
+
+	return res
+}
+
+//endregion
+
+//region Redaction
+
+// AuditRedactor returns the arguments to record for a call.
+// It must not modify the slice given, which is the one of the call.
+type AuditRedactor func(args []any) []any
+
+const auditRedactedValue = "[REDACTED]"
+
+// RedactAllArgs replaces all the arguments.
+func RedactAllArgs(args []any) []any {
+	return RedactArgs()(args)
+}
+
+// RedactArgs returns a redactor replacing the arguments at these positions,
+// starting from 0. Without position, all the arguments are replaced.
+func RedactArgs(positions ...int) AuditRedactor {
+	return func(args []any) []any {
+		res := make([]any, len(args))
+
+		for i, arg := range args {
+			res[i] = arg
+
+			if len(positions) == 0 {
+				res[i] = auditRedactedValue
+			} else {
+				for _, position := range positions {
+					if position == i {
+						res[i] = auditRedactedValue
+					}
+				}
+			}
+		}
+
+		return res
+	}
+}
+
+// DropArgs is a redactor which doesn't record the arguments.
+func DropArgs(args []any) []any {
+	return nil
+}
+
+//endregion
+
+//region AuditLog
+
+type auditSamplingRule struct {
+	pattern string
+	rate    float64
+}
+
+type auditRedactionRule struct {
+	pattern  string
+	redactor AuditRedactor
+}
+
+type AuditLog struct {
+	sinks          []AuditSink
+	filter         func(fct *RegisteredFunction) bool
+	samplingRules  []auditSamplingRule
+	redactionRules []auditRedactionRule
+	onSinkError    func(sink AuditSink, err error)
+	mutex          sync.RWMutex
+}
+
+func NewAuditLog(sinks ...AuditSink) *AuditLog {
+	return &AuditLog{
+		sinks: sinks,
+
+		onSinkError: func(sink AuditSink, err error) {
+			log.Printf("audit log error: %s", err)
+		},
+	}
+}
+
+// AddSink adds a sink receiving the entries.
+func (m *AuditLog) AddSink(sink AuditSink) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.sinks = append(m.sinks, sink)
+}
+
+// SetFilter allows auditing only some functions, for example the ones
+// having a capability. All the functions are audited if not set.
+func (m *AuditLog) SetFilter(filter func(fct *RegisteredFunction) bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.filter = filter
+}
+
+// SetSampling records only a part of the successful calls of the functions matching
+// the pattern, which is a path.Match pattern on "functionGroup.functionName".
+// The rate is between 0 and 1. The calls ending with an error are always recorded.
+// The first rule added matching a function is used.
+func (m *AuditLog) SetSampling(pattern string, rate float64) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic("SetSampling: invalid pattern \"" + pattern + "\"")
+	}
+
+	if (rate < 0) || (rate > 1) {
+		panic("SetSampling: the rate must be between 0 and 1")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.samplingRules = append(m.samplingRules, auditSamplingRule{pattern: pattern, rate: rate})
+}
+
+// SetRedaction sets how the arguments of the functions matching the pattern are recorded.
+// The pattern is like for SetSampling, and the first rule added matching a function is used.
+// Without rule, the arguments are recorded as is.
+func (m *AuditLog) SetRedaction(pattern string, redactor AuditRedactor) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic("SetRedaction: invalid pattern \"" + pattern + "\"")
+	}
+
+	if redactor == nil {
+		panic("SetRedaction: the redactor can't be nil")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.redactionRules = append(m.redactionRules, auditRedactionRule{pattern: pattern, redactor: redactor})
+}
+
+// SetSinkErrorHandler sets the function called when a sink fails.
+// By default the error is written with the standard logger, which writes to stderr.
+func (m *AuditLog) SetSinkErrorHandler(handler func(sink AuditSink, err error)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.onSinkError = handler
+}
+
+// Interceptor returns the interceptor feeding the audit log, which must be given to FunctionRegistry.Use.
+func (m *AuditLog) Interceptor() FunctionInterceptor {
+	return func(call *FunctionCall) error {
+		if call.Function == nil {
+			return nil
+		}
+
+		m.mutex.RLock()
+		filter := m.filter
+		m.mutex.RUnlock()
+
+		if (filter == nil) || filter(call.Function) {
+			call.OnDone(m.record)
+		}
+
+		return nil
+	}
+}
+
+func (m *AuditLog) record(call *FunctionCall) {
+	key := call.Function.Group + "." + call.Function.JsFunctionName
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if call.Err == nil {
+		for _, rule := range m.samplingRules {
+			if matched, _ := path.Match(rule.pattern, key); matched {
+				if rand.Float64() >= rule.rate {
+					return
+				}
+
+				break
+			}
+		}
+	}
+
+	entry := &AuditEntry{
+		Time:          call.StartTime,
+		SecurityGroup: call.SecurityGroup,
+		FunctionGroup: call.Function.Group,
+		Function:      call.Function.JsFunctionName,
+		GoFunction:    call.Function.GoFunctionName,
+		Duration:      call.Duration,
+		Outcome:       AuditOutcomeSuccess,
+	}
+
+	if call.IsRejected {
+		entry.Outcome = AuditOutcomeRejected
+	} else if call.Panic != nil {
+		entry.Outcome = AuditOutcomePanic
+	} else if call.Err != nil {
+		entry.Outcome = AuditOutcomeError
+	}
+
+	if call.Err != nil {
+		entry.Error = call.Err.Error()
+	}
+
+	args := call.Args
+
+	for _, rule := range m.redactionRules {
+		if matched, _ := path.Match(rule.pattern, key); matched {
+			args = rule.redactor(args)
+			break
+		}
+	}
+
+	for _, arg := range args {
+		entry.Args = append(entry.Args, auditArgValue(arg))
+	}
+
+	for _, sink := range m.sinks {
+		if err := sink.WriteAuditEntry(entry); (err != nil) && (m.onSinkError != nil) {
+			m.onSinkError(sink, err)
+		}
+	}
+}
+
+// auditArgValue returns the value recorded for an argument, which must be encodable in JSON.
+// The implicit arguments and the values which can't be encoded are replaced by their type.
+func auditArgValue(arg any) any {
+	if arg == nil {
+		return nil
+	}
+
+	argType := reflect.TypeOf(arg)
+
+	if isImplicitParamType(argType) || argType.Implements(gGoContextType) {
+		return "<" + argType.String() + ">"
+	}
+
+	switch argType.Kind() {
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return "<" + argType.String() + ">"
+	}
+
+	if _, err := json.Marshal(arg); err != nil {
+		return "<" + argType.String() + ">"
+	}
+
+	return arg
+}
+
+//endregion
@@ -0,0 +1,394 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package progpAPI
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+//region BindingManifest
+
+// BindingManifest describes the bindings generated by the code generator.
+// The manifest is linked into the engine with the generated code, which
+// allows detecting when precompiled bindings doesn't match the functions
+// registered anymore, for example after an update of a module.
+type BindingManifest struct {
+	Hash            string                    `json:"hash"`
+	Functions       []BindingManifestFunction `json:"functions"`
+	FunctionCallers []string                  `json:"functionCallers"`
+
+	// DefaultCustomTypeEncoding is empty when it's JSON.
+	DefaultCustomTypeEncoding CustomTypeEncoding            `json:"defaultCustomTypeEncoding,omitempty"`
+	CustomTypeEncodings       map[string]CustomTypeEncoding `json:"customTypeEncodings,omitempty"`
+}
+
+type BindingManifestFunction struct {
+	Group             string   `json:"group"`
+	JsFunctionName    string   `json:"jsFunctionName"`
+	GoFunctionName    string   `json:"goFunctionName"`
+	GoSignature       string   `json:"goSignature"`
+	GeneratorUniqName string   `json:"generatorUniqName"`
+	IsAsync           bool     `json:"isAsync,omitempty"`
+	IsPromise         bool     `json:"isPromise,omitempty"`
+	UseBigInt         bool     `json:"useBigInt,omitempty"`
+	ResultNames       []string `json:"resultNames,omitempty"`
+
+	// CallTimeout is in nanoseconds, like time.Duration.
+	CallTimeout    time.Duration `json:"callTimeout,omitempty"`
+	UseAbortSignal bool          `json:"useAbortSignal,omitempty"`
+
+	ClassName          string `json:"className,omitempty"`
+	IsClassConstructor bool   `json:"isClassConstructor,omitempty"`
+
+	// Capabilities are only informative, since the security checks use the
+	// registered functions. They aren't part of the hash, and changing them
+	// doesn't make the bindings drift.
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// NewBindingManifest creates the manifest for this functions and function callers.
+// The content is sorted, which allows comparing the hash of two manifests.
+func NewBindingManifest(functions []*RegisteredFunction, functionCallers []string) *BindingManifest {
+	res := &BindingManifest{
+		Functions:       make([]BindingManifestFunction, 0, len(functions)),
+		FunctionCallers: slices.Clone(functionCallers),
+	}
+
+	for _, fct := range functions {
+		res.Functions = append(res.Functions, BindingManifestFunction{
+			Group:              fct.Group,
+			JsFunctionName:     fct.JsFunctionName,
+			GoFunctionName:     fct.GoFunctionName,
+			GoSignature:        reflect.TypeOf(fct.GoFunctionRef).String(),
+			GeneratorUniqName:  fct.GoFunctionInfos.GeneratorUniqName,
+			IsAsync:            fct.IsAsync,
+			IsPromise:          fct.IsPromise,
+			UseBigInt:          fct.UseBigInt,
+			ResultNames:        fct.ResultNames,
+			CallTimeout:        fct.CallTimeout,
+			UseAbortSignal:     fct.UseAbortSignal,
+			ClassName:          fct.ClassName,
+			IsClassConstructor: fct.IsClassConstructor,
+			Capabilities:       slices.Clone(fct.Capabilities),
+		})
+	}
+
+	sort.Slice(res.Functions, func(i, j int) bool {
+		return res.Functions[i].key() < res.Functions[j].key()
+	})
+
+	if res.FunctionCallers == nil {
+		res.FunctionCallers = []string{}
+	}
+
+	sort.Strings(res.FunctionCallers)
+
+	res.Hash = res.computeHash()
+
+	return res
+}
+
+// ParseBindingManifest decodes a manifest encoded with BindingManifest.ToJson.
+func ParseBindingManifest(manifestJson string) (*BindingManifest, error) {
+	res := &BindingManifest{}
+
+	if err := json.Unmarshal([]byte(manifestJson), res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (m *BindingManifest) ToJson(indent bool) string {
+	var asBytes []byte
+
+	if indent {
+		asBytes, _ = json.MarshalIndent(m, "", "  ")
+	} else {
+		asBytes, _ = json.Marshal(m)
+	}
+
+	return string(asBytes)
+}
+
+func (m *BindingManifest) computeHash() string {
+	toHash := *m
+	toHash.Hash = ""
+	toHash.Functions = slices.Clone(m.Functions)
+
+	for i := range toHash.Functions {
+		toHash.Functions[i].Capabilities = nil
+	}
+
+	asBytes, _ := json.Marshal(&toHash)
+	sum := sha256.Sum256(asBytes)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *BindingManifestFunction) key() string {
+	return m.Group + "." + m.JsFunctionName
+}
+
+//endregion
+
+//region Comparing manifests
+
+type BindingDriftKind string
+
+const (
+	// BindingDriftMissing is for a function registered but not bound by the engine.
+	BindingDriftMissing BindingDriftKind = "missing"
+
+	// BindingDriftRemoved is for a function bound by the engine but not registered anymore.
+	BindingDriftRemoved BindingDriftKind = "removed"
+
+	// BindingDriftChanged is for a function which signature or generated name has changed.
+	BindingDriftChanged BindingDriftKind = "changed"
+
+	// BindingDriftMissingFunctionCaller is for a function caller required but not linked.
+	BindingDriftMissingFunctionCaller BindingDriftKind = "missingFunctionCaller"
+
+	// BindingDriftEncodingChanged is for a custom type which encoding has changed.
+	BindingDriftEncodingChanged BindingDriftKind = "encodingChanged"
+)
+
+type BindingDrift struct {
+	Kind BindingDriftKind
+
+	// Name is "group.jsFunctionName" for the functions
+	// and the signature for the function callers.
+	Name string
+
+	Linked  string
+	Current string
+}
+
+func (m BindingDrift) String() string {
+	switch m.Kind {
+	case BindingDriftMissing:
+		return "+ " + m.Name + ": " + m.Current + " (not bound by the engine)"
+	case BindingDriftRemoved:
+		return "- " + m.Name + ": " + m.Linked + " (not registered anymore)"
+	case BindingDriftMissingFunctionCaller:
+		return "+ function caller " + m.Name + " (not linked into the engine)"
+	case BindingDriftEncodingChanged:
+		return "~ encoding of " + m.Name + ": linked " + m.Linked + ", current " + m.Current
+	default:
+		return "~ " + m.Name + ": linked " + m.Linked + ", current " + m.Current
+	}
+}
+
+// CompareBindingManifests returns the differences between the manifest
+// linked into the engine and the manifest of the functions currently registered.
+// Function callers linked but not used anymore aren't reported, since they are harmless.
+func CompareBindingManifests(linked *BindingManifest, current *BindingManifest) []BindingDrift {
+	var res []BindingDrift
+
+	if linked.Hash == current.Hash {
+		return nil
+	}
+
+	linkedMap := make(map[string]BindingManifestFunction)
+	for _, fct := range linked.Functions {
+		linkedMap[fct.key()] = fct
+	}
+
+	currentMap := make(map[string]bool)
+
+	for _, fct := range current.Functions {
+		key := fct.key()
+		currentMap[key] = true
+
+		linkedFct, exists := linkedMap[key]
+
+		if !exists {
+			res = append(res, BindingDrift{Kind: BindingDriftMissing, Name: key, Current: fct.describe()})
+		} else if linkedFct.describe() != fct.describe() {
+			res = append(res, BindingDrift{Kind: BindingDriftChanged, Name: key, Linked: linkedFct.describe(), Current: fct.describe()})
+		}
+	}
+
+	for _, fct := range linked.Functions {
+		if !currentMap[fct.key()] {
+			res = append(res, BindingDrift{Kind: BindingDriftRemoved, Name: fct.key(), Linked: fct.describe()})
+		}
+	}
+
+	for _, signature := range current.FunctionCallers {
+		if !slices.Contains(linked.FunctionCallers, signature) {
+			res = append(res, BindingDrift{Kind: BindingDriftMissingFunctionCaller, Name: signature})
+		}
+	}
+
+	res = append(res, compareCustomTypeEncodings(linked, current)...)
+
+	return res
+}
+
+func compareCustomTypeEncodings(linked *BindingManifest, current *BindingManifest) []BindingDrift {
+	var res []BindingDrift
+
+	getEncoding := func(manifest *BindingManifest, typeName string) CustomTypeEncoding {
+		if typeName == "" {
+			if manifest.DefaultCustomTypeEncoding == "" {
+				return CustomTypeEncodingJson
+			}
+
+			return manifest.DefaultCustomTypeEncoding
+		}
+
+		if encoding, ok := manifest.CustomTypeEncodings[typeName]; ok {
+			return encoding
+		}
+
+		return "(default)"
+	}
+
+	// The empty name is for the default encoding.
+	typeNames := []string{""}
+
+	for typeName := range linked.CustomTypeEncodings {
+		typeNames = append(typeNames, typeName)
+	}
+
+	for typeName := range current.CustomTypeEncodings {
+		if _, exists := linked.CustomTypeEncodings[typeName]; !exists {
+			typeNames = append(typeNames, typeName)
+		}
+	}
+
+	sort.Strings(typeNames)
+
+	for _, typeName := range typeNames {
+		linkedEncoding := getEncoding(linked, typeName)
+		currentEncoding := getEncoding(current, typeName)
+
+		if linkedEncoding != currentEncoding {
+			name := typeName
+			if name == "" {
+				name = "(default)"
+			}
+
+			res = append(res, BindingDrift{Kind: BindingDriftEncodingChanged, Name: name, Linked: string(linkedEncoding), Current: string(currentEncoding)})
+		}
+	}
+
+	return res
+}
+
+func (m *BindingManifestFunction) describe() string {
+	res := m.GoFunctionName + " " + m.GoSignature + " [" + m.GeneratorUniqName + "]"
+
+	if m.IsAsync {
+		res += " async"
+	}
+
+	if m.IsPromise {
+		res += " promise"
+	}
+
+	if m.UseBigInt {
+		res += " bigint"
+	}
+
+	if len(m.ResultNames) != 0 {
+		res += " results(" + strings.Join(m.ResultNames, ", ") + ")"
+	}
+
+	if m.CallTimeout != 0 {
+		res += " timeout(" + m.CallTimeout.String() + ")"
+	}
+
+	if m.UseAbortSignal {
+		res += " abortSignal"
+	}
+
+	if m.IsClassConstructor {
+		res += " constructor(" + m.ClassName + ")"
+	} else if m.ClassName != "" {
+		res += " method(" + m.ClassName + ")"
+	}
+
+	return res
+}
+
+// BindingManifestError is returned when the bindings linked
+// into the engine don't match the functions registered.
+type BindingManifestError struct {
+	Drifts []BindingDrift
+}
+
+func (m *BindingManifestError) Error() string {
+	lines := []string{"the bindings linked into the engine are stale and must be regenerated:"}
+
+	for _, drift := range m.Drifts {
+		lines = append(lines, "    "+drift.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+var ErrNoBindingManifest = errors.New("no binding manifest is linked into the engine")
+
+//endregion
+
+//region Linked manifest
+
+var gLinkedBindingManifest string
+
+// SetLinkedBindingManifest is called by the generated code
+// in order to declare the manifest of the bindings compiled.
+func SetLinkedBindingManifest(manifestJson string) {
+	gLinkedBindingManifest = manifestJson
+}
+
+func GetLinkedBindingManifest() (*BindingManifest, error) {
+	if gLinkedBindingManifest == "" {
+		return nil, ErrNoBindingManifest
+	}
+
+	return ParseBindingManifest(gLinkedBindingManifest)
+}
+
+// CheckLinkedBindingManifest compares the manifest linked into the engine with the
+// functions currently registered. It allows a stale precompiled plugin to fail fast.
+// Returns a *BindingManifestError listing the bindings which drifted.
+func CheckLinkedBindingManifest() error {
+	linked, err := GetLinkedBindingManifest()
+	if err != nil {
+		return err
+	}
+
+	drifts := CompareBindingManifests(linked, GetFunctionRegistry().BuildBindingManifest())
+
+	if len(drifts) != 0 {
+		return &BindingManifestError{Drifts: drifts}
+	}
+
+	return nil
+}
+
+//endregion
@@ -0,0 +1,347 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package progpAPI
+
+import (
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The call limits protect the Go functions against the scripts calling them too often.
+// A rate limit is a token bucket, while a quota is a maximum number of calls.
+// They are keyed by security group and function: each function matching a rule
+// has its own counters for each security group.
+//
+// They are enforced by an interceptor, see FunctionRegistry.Use, which is installed
+// the first time a limit is set.
+
+//region Errors
+
+const (
+	ErrorCodeRateLimited   = "ERR_RATE_LIMITED"
+	ErrorCodeQuotaExceeded = "ERR_QUOTA_EXCEEDED"
+)
+
+// CallLimitError is the error of a call rejected by a rate limit or a quota.
+// His message starts with the error code, which allows javascript to identify it.
+type CallLimitError struct {
+	Code          string
+	SecurityGroup string
+	FunctionGroup string
+	Function      string
+}
+
+func (m *CallLimitError) Error() string {
+	what := "too many calls of "
+	if m.Code == ErrorCodeQuotaExceeded {
+		what = "the call quota is exceeded for "
+	}
+
+	return m.Code + ": " + what + m.FunctionGroup + "." + m.Function + " by the security group " + m.SecurityGroup
+}
+
+//endregion
+
+//region Configuration
+
+type RateLimit struct {
+	// CallsPerSecond is the rate at which the calls are allowed once the burst is consumed.
+	CallsPerSecond float64
+
+	// Burst is the number of calls which can be done at once. Is 1 if not set.
+	Burst int
+
+	// MaxDelay allows delaying the calls of the functions returning a promise, and of
+	// most async functions, instead of rejecting them, if they can be done after at
+	// most this duration. See RegisteredFunction.CanBeDelayed.
+	MaxDelay time.Duration
+}
+
+type callLimitRule struct {
+	securityGroup   string
+	functionPattern string
+	rateLimit       *RateLimit
+	quota           int64
+}
+
+func (m *callLimitRule) matches(securityGroup string, functionKey string) bool {
+	if matched, _ := path.Match(m.securityGroup, securityGroup); !matched {
+		return false
+	}
+
+	matched, _ := path.Match(m.functionPattern, functionKey)
+	return matched
+}
+
+// SetRateLimit limits how often the scripts of a security group can call the functions
+// matching the pattern. The security group and the pattern use the syntax of path.Match,
+// the pattern being on "functionGroup.functionName". The first rule set which matches
+// a function is used.
+func (m *FunctionRegistry) SetRateLimit(securityGroup string, functionPattern string, limit RateLimit) {
+	checkCallLimitPatterns("SetRateLimit", securityGroup, functionPattern)
+
+	if limit.CallsPerSecond <= 0 {
+		panic("SetRateLimit: the rate must be positive")
+	}
+
+	if limit.Burst < 0 {
+		panic("SetRateLimit: the burst can't be negative")
+	}
+
+	if limit.Burst == 0 {
+		limit.Burst = 1
+	}
+
+	gCallLimiter.addRule(&callLimitRule{securityGroup: securityGroup, functionPattern: functionPattern, rateLimit: &limit})
+}
+
+// SetCallQuota limits the number of calls that the scripts of a security group can do
+// to the functions matching the pattern, until ResetCallQuotas is called.
+// The patterns are like for SetRateLimit.
+func (m *FunctionRegistry) SetCallQuota(securityGroup string, functionPattern string, maxCalls int64) {
+	checkCallLimitPatterns("SetCallQuota", securityGroup, functionPattern)
+
+	if maxCalls < 0 {
+		panic("SetCallQuota: the quota can't be negative")
+	}
+
+	gCallLimiter.addRule(&callLimitRule{securityGroup: securityGroup, functionPattern: functionPattern, quota: maxCalls})
+}
+
+// ResetCallQuotas resets the quotas consumed by the security groups matching the pattern.
+func (m *FunctionRegistry) ResetCallQuotas(securityGroup string) {
+	gCallLimiter.resetQuotas(securityGroup)
+}
+
+// GetCallLimitCounters returns the counters of the functions which are limited,
+// sorted by security group and function.
+func (m *FunctionRegistry) GetCallLimitCounters() []CallLimitCounter {
+	return gCallLimiter.getCounters()
+}
+
+func checkCallLimitPatterns(caller string, securityGroup string, functionPattern string) {
+	if _, err := path.Match(securityGroup, ""); err != nil {
+		panic(caller + ": invalid security group pattern \"" + securityGroup + "\"")
+	}
+
+	if _, err := path.Match(functionPattern, ""); err != nil {
+		panic(caller + ": invalid function pattern \"" + functionPattern + "\"")
+	}
+}
+
+//endregion
+
+//region Limiter
+
+// CallLimitCounter are the counters of a function for a security group.
+type CallLimitCounter struct {
+	SecurityGroup string
+	FunctionGroup string
+	Function      string
+
+	Calls    int64
+	Rejected int64
+	Delayed  int64
+
+	// Quota is -1 if there is no quota.
+	Quota int64
+}
+
+type callLimitKey struct {
+	securityGroup string
+	functionKey   string
+}
+
+type callLimitState struct {
+	counter       CallLimitCounter
+	rateLimit     *RateLimit
+	tokens        float64
+	lastRefilling time.Time
+}
+
+type callLimiter struct {
+	rules     []*callLimitRule
+	states    map[callLimitKey]*callLimitState
+	installed bool
+	mutex     sync.Mutex
+}
+
+var gCallLimiter = &callLimiter{states: make(map[callLimitKey]*callLimitState)}
+
+func (m *callLimiter) addRule(rule *callLimitRule) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.rules = append(m.rules, rule)
+
+	// The states matched by the new rule are updated,
+	// while keeping their counters and the tokens of their bucket.
+	for key, state := range m.states {
+		if rule.matches(key.securityGroup, key.functionKey) {
+			m.applyRules(key, state)
+		}
+	}
+
+	if !m.installed {
+		m.installed = true
+		GetFunctionRegistry().Use(m.intercept)
+	}
+}
+
+func (m *callLimiter) resetQuotas(securityGroup string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for key, state := range m.states {
+		if matched, _ := path.Match(securityGroup, key.securityGroup); matched {
+			state.counter.Calls = 0
+		}
+	}
+}
+
+func (m *callLimiter) getCounters() []CallLimitCounter {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	res := make([]CallLimitCounter, 0, len(m.states))
+
+	for _, state := range m.states {
+		if (state.rateLimit != nil) || (state.counter.Quota != -1) {
+			res = append(res, state.counter)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].SecurityGroup != res[j].SecurityGroup {
+			return res[i].SecurityGroup < res[j].SecurityGroup
+		}
+
+		if res[i].FunctionGroup != res[j].FunctionGroup {
+			return res[i].FunctionGroup < res[j].FunctionGroup
+		}
+
+		return res[i].Function < res[j].Function
+	})
+
+	return res
+}
+
+// getState returns the state of a function for a security group.
+// The caller must lock the mutex.
+func (m *callLimiter) getState(securityGroup string, fct *RegisteredFunction) *callLimitState {
+	key := callLimitKey{securityGroup: securityGroup, functionKey: fct.Group + "." + fct.JsFunctionName}
+
+	if state := m.states[key]; state != nil {
+		return state
+	}
+
+	state := &callLimitState{
+		counter: CallLimitCounter{SecurityGroup: securityGroup, FunctionGroup: fct.Group, Function: fct.JsFunctionName, Quota: -1},
+	}
+
+	m.applyRules(key, state)
+	m.states[key] = state
+
+	return state
+}
+
+// applyRules sets the rate limit and the quota of a state from the first matching rules.
+// The caller must lock the mutex.
+func (m *callLimiter) applyRules(key callLimitKey, state *callLimitState) {
+	var rateLimitRule, quotaRule *callLimitRule
+
+	for _, rule := range m.rules {
+		if !rule.matches(key.securityGroup, key.functionKey) {
+			continue
+		}
+
+		if (rule.rateLimit != nil) && (rateLimitRule == nil) {
+			rateLimitRule = rule
+		} else if (rule.rateLimit == nil) && (quotaRule == nil) {
+			quotaRule = rule
+		}
+	}
+
+	if rateLimitRule == nil {
+		state.rateLimit = nil
+	} else if state.rateLimit != rateLimitRule.rateLimit {
+		if state.rateLimit == nil {
+			state.tokens = float64(rateLimitRule.rateLimit.Burst)
+			state.lastRefilling = time.Now()
+		} else {
+			state.tokens = min(state.tokens, float64(rateLimitRule.rateLimit.Burst))
+		}
+
+		state.rateLimit = rateLimitRule.rateLimit
+	}
+
+	if quotaRule == nil {
+		state.counter.Quota = -1
+	} else {
+		state.counter.Quota = quotaRule.quota
+	}
+}
+
+func (m *callLimiter) intercept(call *FunctionCall) error {
+	if call.Function == nil {
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	state := m.getState(call.SecurityGroup, call.Function)
+
+	if (state.rateLimit == nil) && (state.counter.Quota == -1) {
+		return nil
+	}
+
+	newError := func(code string) error {
+		state.counter.Rejected++
+		return &CallLimitError{Code: code, SecurityGroup: call.SecurityGroup, FunctionGroup: call.Function.Group, Function: call.Function.JsFunctionName}
+	}
+
+	if (state.counter.Quota != -1) && (state.counter.Calls >= state.counter.Quota) {
+		return newError(ErrorCodeQuotaExceeded)
+	}
+
+	if limit := state.rateLimit; limit != nil {
+		now := time.Now()
+		state.tokens = min(float64(limit.Burst), state.tokens+now.Sub(state.lastRefilling).Seconds()*limit.CallsPerSecond)
+		state.lastRefilling = now
+
+		if state.tokens < 1 {
+			// The token is reserved, the call being done once the bucket has it.
+			delay := time.Duration((1 - state.tokens) / limit.CallsPerSecond * float64(time.Second))
+
+			if (delay > limit.MaxDelay) || !call.DelayBy(delay) {
+				return newError(ErrorCodeRateLimited)
+			}
+
+			state.counter.Delayed++
+		}
+
+		state.tokens--
+	}
+
+	state.counter.Calls++
+	return nil
+}
+
+//endregion
@@ -0,0 +1,266 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package progpAPI
+
+import (
+	"io"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// A Go type can be exposed as a javascript class with FunctionModule.AddClass.
+// The constructor returns a *T, which is stored in a SharedResource, and the
+// instances keep the id of this resource. The methods of *T are registered as
+// functions which first parameter is the instance, and which aren't directly
+// visible to javascript. The generated method dispose releases the resource.
+//
+// The methods are added to the prototype of the constructor once he is bound, without being bound one by one.
+// So the checker given to ScriptEngine.SetAllowedFunctionsChecker is only called for the
+// class, with his javascript name, and a script allowed to create instances can call all
+// their methods. For the same reason, the capabilities are set on the class and a
+// SecurityPolicy can't have a rule targeting a method.
+
+// RegisteredClass is a Go type exposed as a javascript class.
+type RegisteredClass struct {
+	Group       string
+	JsClassName string
+
+	// GoType is the type returned by the constructor, which is a pointer to a struct.
+	GoType reflect.Type
+
+	Constructor *RegisteredFunction
+	Methods     []*RegisteredFunction
+}
+
+// WithCapabilities tags the class with these capabilities, see RegisteredFunction.WithCapabilities.
+// They are checked for the constructor, and the methods are tagged with the same ones,
+// which allows the binding manifest to list them. Can be called on nil.
+func (m *RegisteredClass) WithCapabilities(capabilities ...string) *RegisteredClass {
+	if m == nil {
+		return m
+	}
+
+	m.Constructor.WithCapabilities(capabilities...)
+
+	for _, method := range m.Methods {
+		method.Capabilities = slices.Clone(m.Constructor.Capabilities)
+	}
+
+	return m
+}
+
+// GetClassOfType returns the class which instances are of this type, or nil.
+func (m *FunctionRegistry) GetClassOfType(goType reflect.Type) *RegisteredClass {
+	return m.classesByType[goType]
+}
+
+// GetClass returns the class with this javascript name in this group, or nil.
+func (m *FunctionRegistry) GetClass(group string, jsClassName string) *RegisteredClass {
+	for _, class := range m.classesByType {
+		if (class.Group == group) && (class.JsClassName == jsClassName) {
+			return class
+		}
+	}
+
+	return nil
+}
+
+// GetAllClasses returns the classes sorted by group and name.
+func (m *FunctionRegistry) GetAllClasses() []*RegisteredClass {
+	res := make([]*RegisteredClass, 0, len(m.classesByType))
+
+	for _, class := range m.classesByType {
+		res = append(res, class)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Group+"."+res[i].JsClassName < res[j].Group+"."+res[j].JsClassName
+	})
+
+	return res
+}
+
+var gErrorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// isClassType returns true if the type is the type of the instances of a class.
+// These values are given by the instances, and so can't be null.
+func isClassType(goType reflect.Type) bool {
+	return (gFunctionRegistry != nil) && (gFunctionRegistry.classesByType[goType] != nil)
+}
+
+// AddClass exposes the type returned by constructor as a javascript class, which can be
+// created with new. The constructor is a function returning *T or (*T, error), with T a struct.
+// The methods are the names of the methods of *T, and when none are given all the exported methods
+// are used, except Dispose and Close. In javascript, the methods names start with a lowercase letter.
+//
+// The instances have a method dispose, also bound to Symbol.dispose, which releases the value.
+// His Dispose or Close method is then called, if it has one.
+// The class must be added before the functions receiving his instances.
+// Returns nil if the class can't be registered, see FunctionRegistry.Validate.
+func (m *FunctionModule) AddClass(jsClassName string, goConstructorName string, constructor any, methods ...string) *RegisteredClass {
+	return m.addClass(m.moduleName, jsClassName, goConstructorName, constructor, methods)
+}
+
+// AddClass is like FunctionModule.AddClass but for this group.
+func (m *FunctionGroup) AddClass(jsClassName string, goConstructorName string, constructor any, methods ...string) *RegisteredClass {
+	return m.goModule.addClass(m.jsGroupName, jsClassName, goConstructorName, constructor, methods)
+}
+
+func (m *FunctionModule) addClass(groupName string, jsClassName string, goConstructorName string, constructor any, methodNames []string) *RegisteredClass {
+	registry := m.functionRegistry
+
+	addError := func(kind RegistrationErrorKind, goFunctionName string, message string) {
+		registry.registrationErrors = append(registry.registrationErrors, RegistrationError{
+			Kind:           kind,
+			Group:          groupName,
+			JsFunctionName: jsClassName,
+			GoFunctionName: goFunctionName,
+			Message:        message,
+		})
+	}
+
+	ctorType := reflect.TypeOf(constructor)
+
+	if (ctorType == nil) || (ctorType.Kind() != reflect.Func) {
+		addError(RegistrationErrorNotAFunction, m.moduleName+"."+goConstructorName, "the constructor of a class must be a function")
+		return nil
+	}
+
+	isValidReturn := (ctorType.NumOut() == 1) || ((ctorType.NumOut() == 2) && (ctorType.Out(1) == gErrorType))
+
+	if !isValidReturn || (ctorType.Out(0).Kind() != reflect.Pointer) || (ctorType.Out(0).Elem().Kind() != reflect.Struct) {
+		addError(RegistrationErrorUnsupportedReturn, m.moduleName+"."+goConstructorName, "the constructor of a class must return *T or (*T, error), with T a struct")
+		return nil
+	}
+
+	goType := ctorType.Out(0)
+
+	if existing := registry.classesByType[goType]; existing != nil {
+		addError(RegistrationErrorDuplicateJsName, m.moduleName+"."+goConstructorName, "the type "+goType.String()+" is already exposed as the class "+existing.Group+"."+existing.JsClassName)
+		return nil
+	}
+
+	// Must be done before parsing the methods, since the instances aren't nullable.
+	class := &RegisteredClass{Group: groupName, JsClassName: jsClassName, GoType: goType}
+	registry.classesByType[goType] = class
+
+	class.Constructor = m.addFunction(functionKindSync, groupName, jsClassName, goConstructorName, constructor)
+
+	if class.Constructor == nil {
+		return nil
+	}
+
+	class.Constructor.ClassName = jsClassName
+	class.Constructor.IsClassConstructor = true
+
+	if len(methodNames) == 0 {
+		for i := 0; i < goType.NumMethod(); i++ {
+			if name := goType.Method(i).Name; (name != "Dispose") && (name != "Close") {
+				methodNames = append(methodNames, name)
+			}
+		}
+	}
+
+	hasError := false
+
+	for _, methodName := range methodNames {
+		goFunctionName := "(" + goType.String() + ")." + methodName
+		method, exists := goType.MethodByName(methodName)
+
+		if !exists {
+			hasError = true
+			addError(RegistrationErrorNotAFunction, goFunctionName, "the type "+goType.String()+" has no exported method "+methodName)
+			continue
+		}
+
+		if strings.HasSuffix(methodName, "Async") {
+			hasError = true
+			addError(RegistrationErrorAsyncSuffix, goFunctionName, "the methods of a class can't be asynchrone")
+			continue
+		}
+
+		fct := m.addMethod(groupName, class, classMethodJsName(methodName), goFunctionName, method.Func.Interface())
+
+		if fct == nil {
+			hasError = true
+		}
+	}
+
+	m.addMethod(groupName, class, "dispose", "progpAPI.DisposeClassInstance", DisposeClassInstance)
+
+	if hasError {
+		return nil
+	}
+
+	return class
+}
+
+// addMethod registers a method, which first parameter is the instance.
+func (m *FunctionModule) addMethod(groupName string, class *RegisteredClass, jsMethodName string, goFunctionName string, goFunctionRef any) *RegisteredFunction {
+	fct := m.functionRegistry.addFunction(functionKindSync, groupName, class.JsClassName+"."+jsMethodName, goFunctionName, goFunctionRef)
+
+	if fct == nil {
+		return nil
+	}
+
+	fct.ClassName = class.JsClassName
+
+	// The instance isn't an argument given by javascript.
+	fct.GoFunctionInfos.MinArgCount--
+
+	if fct.GoFunctionInfos.MaxArgCount != -1 {
+		fct.GoFunctionInfos.MaxArgCount--
+	}
+
+	class.Methods = append(class.Methods, fct)
+	return fct
+}
+
+// GetJsMethodName returns the name of the method in the javascript class.
+func (m *RegisteredFunction) GetJsMethodName() string {
+	return strings.TrimPrefix(m.JsFunctionName, m.ClassName+".")
+}
+
+// IsClassMethod returns true if the function is a method of a class,
+// which first parameter is the instance.
+func (m *RegisteredFunction) IsClassMethod() bool {
+	return (m.ClassName != "") && !m.IsClassConstructor
+}
+
+func classMethodJsName(goMethodName string) string {
+	asRunes := []rune(goMethodName)
+	asRunes[0] = unicode.ToLower(asRunes[0])
+	return string(asRunes)
+}
+
+// DisposeClassInstance is the method dispose of the classes.
+func DisposeClassInstance(instance *SharedResource) {
+	instance.Dispose()
+}
+
+// DisposeClassValue is called when the resource of an instance is disposed.
+// It calls the method Dispose or Close of the value, if it has one.
+func DisposeClassValue(value any) {
+	if disposable, ok := value.(interface{ Dispose() }); ok {
+		disposable.Dispose()
+	} else if closer, ok := value.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
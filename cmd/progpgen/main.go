@@ -0,0 +1,203 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Command progpgen generates the javascript bindings without having to start
+// the application. Go can't load a package at runtime, so progpgen writes a small
+// program importing the registration packages, then builds and executes it.
+// It must be executed from inside the Go module using theses packages.
+//
+// Usage:
+//
+//	progpgen --package github.com/me/myModule --out ./progpV8Engine [--ts ./typings] [--check]
+//
+// A package can be followed by ":FunctionName" when his functions are registered
+// by calling this function instead of by his init function.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type packageList []string
+
+func (m *packageList) String() string {
+	return strings.Join(*m, ",")
+}
+
+func (m *packageList) Set(value string) error {
+	for _, e := range strings.Split(value, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			*m = append(*m, e)
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	var packages packageList
+
+	flag.Var(&packages, "package", "registration package import path, with an optional \":FunctionName\" to call (repeatable)")
+	outputDir := flag.String("out", "", "directory where the C++, header and Go files are generated")
+	tsDir := flag.String("ts", "", "directory where the TypeScript declarations are generated (optional)")
+	check := flag.Bool("check", false, "don't write anything, exit with code 1 if a file is stale")
+	flag.Parse()
+
+	if len(packages) == 0 {
+		exitWithError(errors.New("at least one --package is required"))
+	}
+
+	if (*outputDir == "") && (*tsDir == "") {
+		exitWithError(errors.New("--out or --ts is required"))
+	}
+
+	exitCode, err := run(packages, *outputDir, *tsDir, *check)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	os.Exit(exitCode)
+}
+
+func exitWithError(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, "progpgen: "+err.Error())
+	os.Exit(2)
+}
+
+func run(packages []string, outputDir string, tsDir string, check bool) (int, error) {
+	var err error
+
+	// The program is executed from another directory.
+	if outputDir != "" {
+		if outputDir, err = filepath.Abs(outputDir); err != nil {
+			return 0, err
+		}
+	}
+
+	if tsDir != "" {
+		if tsDir, err = filepath.Abs(tsDir); err != nil {
+			return 0, err
+		}
+	}
+
+	program, err := buildProgram(packages, outputDir, tsDir, check)
+	if err != nil {
+		return 0, err
+	}
+
+	// The directory must be inside the current module, otherwise
+	// "go build" can't resolve the packages. Directories starting
+	// with a dot are ignored by the "./..." patterns.
+	//
+	tmpDir, err := os.MkdirTemp(".", ".progpgen-")
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	if err = os.WriteFile(filepath.Join(tmpDir, "main.go"), []byte(program), 0644); err != nil {
+		return 0, err
+	}
+
+	// Building then executing, instead of "go run", allows
+	// getting the exit code without messages added by Go.
+	//
+	binPath, err := filepath.Abs(filepath.Join(tmpDir, "progpgen-run"))
+	if err != nil {
+		return 0, err
+	}
+
+	cmd := exec.Command("go", "build", "-o", binPath, ".")
+	cmd.Dir = tmpDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err = cmd.Run(); err != nil {
+		return 0, errors.New("can't build the registration program: " + err.Error())
+	}
+
+	cmd = exec.Command(binPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+
+	return 0, err
+}
+
+var gGoIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func buildProgram(packages []string, outputDir string, tsDir string, check bool) (string, error) {
+	imports := ""
+	calls := ""
+
+	for i, pkg := range packages {
+		importPath, functionName, hasFunction := strings.Cut(pkg, ":")
+
+		if hasFunction {
+			if !gGoIdentifier.MatchString(functionName) {
+				return "", errors.New("invalid function name " + functionName)
+			}
+
+			alias := "pkg" + strconv.Itoa(i)
+			imports += "\n    " + alias + " " + strconv.Quote(importPath)
+			calls += "\n    " + alias + "." + functionName + "()"
+		} else {
+			imports += "\n    _ " + strconv.Quote(importPath)
+		}
+	}
+
+	template := `// Code generated by progpgen. DO NOT EDIT.
+
+package main
+
+import (
+    "os"
+    "github.com/progpjs/progpAPI/v2/codegen"%IMPORTS%
+)
+
+func main() {%CALLS%
+
+    os.Exit(codegen.RunCommand(codegen.CommandOptions{
+        OutputDir:     %OUTPUT_DIR%,
+        TypeScriptDir: %TS_DIR%,
+        Check:         %CHECK%,
+    }))
+}
+`
+
+	template = strings.ReplaceAll(template, "%IMPORTS%", imports)
+	template = strings.ReplaceAll(template, "%CALLS%", calls)
+	template = strings.ReplaceAll(template, "%OUTPUT_DIR%", strconv.Quote(outputDir))
+	template = strings.ReplaceAll(template, "%TS_DIR%", strconv.Quote(tsDir))
+	template = strings.ReplaceAll(template, "%CHECK%", strconv.FormatBool(check))
+
+	return template, nil
+}
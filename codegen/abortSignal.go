@@ -0,0 +1,228 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codegen
+
+import "strconv"
+
+// The functions using progpAPI.RegisteredFunction.WithAbortSignal receive an extra argument,
+// from which the C++ side extracts the AbortSignal. An id is given to the Go binding, which
+// associates it to a progpAPI.AbortableCall. Once the binding has returned without error, a listener
+// is added to the signal, which calls progpAbortSignalTriggered with this id when the script aborts.
+// The listener is removed once the callback settling the call is called.
+//
+// The callback of an async function is wrapped by progpAbortableCallback, which converts
+// the progpAPI.AbortError into a standard AbortError, like it's done when rejecting a promise.
+
+// abortSignalCodeFor returns the C++ code extracting the AbortSignal of the argument at paramPosition,
+// and the C++ code watching it after the call. The callback settling the call is the next argument.
+func (m *ProgpV8CodeGenerator) abortSignalCodeFor(paramPosition int) (string, string) {
+	if !m.cppHelpersAdded["abortSignal"] {
+		m.goLangInjectThis += goAbortSignalExport
+	}
+
+	m.AddCppHelper("throwErrors", cppHelperThrowErrors)
+	m.AddCppHelper("goErrors", cppHelperGoErrors)
+	m.AddCppHelper("settledHooks", cppHelperSettledHooks)
+	m.AddCppHelper("abortSignal", cppHelperAbortSignal)
+
+	v8Value := "callInfo[" + strconv.Itoa(paramPosition) + "]"
+	argNumber := strconv.Itoa(paramPosition + 1)
+
+	decoding := "    v8::Local<v8::Object> pAbortSignal;\n" +
+		"    if (!progpGetAbortSignal(v8Iso, " + v8Value + ", &pAbortSignal)) " +
+		"PROGP_THROW_TYPE_ERROR(\"argument " + argNumber + " must be an AbortSignal, an object with a signal property, or null\");\n" +
+		"    int64_t pAbortId = pAbortSignal.IsEmpty() ? 0 : gProgpNextAbortId++;\n"
+
+	watching := "    if (pAbortId != 0) progpWatchAbortSignal(v8Iso, pAbortSignal, pAbortId, callInfo[" + strconv.Itoa(paramPosition+1) + "]);"
+
+	return decoding, watching
+}
+
+// goAbortSignalExport are the Go functions called by the C++ listener of the signals.
+// They are added once, with the C++ helper.
+const goAbortSignalExport = `
+//export progpAbortSignalTriggered
+func progpAbortSignalTriggered(abortId C.longlong) {
+	progpAPI.TriggerAbortSignal(int64(abortId))
+}
+
+//export progpIsAbortableCallActive
+func progpIsAbortableCallActive(abortId C.longlong) C.int {
+	if progpAPI.IsAbortableCallActive(int64(abortId)) {
+		return 1
+	}
+
+	return 0
+}
+`
+
+// cppHelperAbortSignal extracts the AbortSignal given by javascript and listens to it.
+// It's added with ProgpV8CodeGenerator.AddCppHelper("abortSignal", cppHelperAbortSignal).
+const cppHelperAbortSignal = `
+#include <atomic>
+#include <vector>
+
+// Is shared by all the isolates, which can run on different threads.
+static std::atomic<int64_t> gProgpNextAbortId{1};
+
+static bool progpIsAbortSignal(v8::Isolate* v8Iso, v8::Local<v8::Object> obj) {
+    v8::Local<v8::Context> v8Ctx = v8Iso->GetCurrentContext();
+    return obj->Has(v8Ctx, v8::String::NewFromUtf8Literal(v8Iso, "aborted")).FromMaybe(false);
+}
+
+// progpGetAbortSignal extracts the signal from an AbortSignal or an object with a signal property.
+// The signal stays empty for null and undefined. Returns false if the value isn't valid.
+static bool progpGetAbortSignal(v8::Isolate* v8Iso, v8::Local<v8::Value> value, v8::Local<v8::Object>* signal) {
+    if (value->IsNullOrUndefined()) return true;
+    if (!value->IsObject()) return false;
+
+    v8::Local<v8::Context> v8Ctx = v8Iso->GetCurrentContext();
+    v8::Local<v8::Object> obj = value.As<v8::Object>();
+
+    if (!progpIsAbortSignal(v8Iso, obj)) {
+        v8::Local<v8::Value> inner;
+
+        if (!obj->Get(v8Ctx, v8::String::NewFromUtf8Literal(v8Iso, "signal")).ToLocal(&inner)) return false;
+        if (inner->IsNullOrUndefined()) return true;
+        if (!inner->IsObject() || !progpIsAbortSignal(v8Iso, inner.As<v8::Object>())) return false;
+
+        obj = inner.As<v8::Object>();
+    }
+
+    *signal = obj;
+    return true;
+}
+
+static void progpAbortSignalListener(const v8::FunctionCallbackInfo<v8::Value>& callInfo) {
+    progpAbortSignalTriggered((long long)callInfo.Data().As<v8::Number>()->Value());
+}
+
+// progpAbortSignalUnwatch is the settled hook removing the listener of the signal.
+// The data is an array [signal, listener, abortId]. A callback kept alive can
+// still be aborted, then the listener is kept until the call is done.
+static void progpAbortSignalUnwatch(const v8::FunctionCallbackInfo<v8::Value>& callInfo) {
+    v8::Isolate* v8Iso = callInfo.GetIsolate();
+    v8::Local<v8::Context> v8Ctx = v8Iso->GetCurrentContext();
+
+    v8::Local<v8::Array> data = callInfo.Data().As<v8::Array>();
+    int64_t abortId = (int64_t)data->Get(v8Ctx, 2).ToLocalChecked().As<v8::Number>()->Value();
+
+    if (progpIsAbortableCallActive((long long)abortId)) {
+        callInfo.GetReturnValue().Set(false);
+        return;
+    }
+
+    callInfo.GetReturnValue().Set(true);
+
+    v8::Local<v8::Object> signal = data->Get(v8Ctx, 0).ToLocalChecked().As<v8::Object>();
+    v8::Local<v8::Value> removeEventListener;
+
+    if (!signal->Get(v8Ctx, v8::String::NewFromUtf8Literal(v8Iso, "removeEventListener")).ToLocal(&removeEventListener) || !removeEventListener->IsFunction()) {
+        return;
+    }
+
+    v8::Local<v8::Value> args[] = { v8::String::NewFromUtf8Literal(v8Iso, "abort"), data->Get(v8Ctx, 1).ToLocalChecked() };
+
+    v8::TryCatch tryCatch(v8Iso);
+    (void)removeEventListener.As<v8::Function>()->Call(v8Ctx, signal, 2, args);
+}
+
+// progpWatchAbortSignal calls Go when the signal is aborted, or now if it's already aborted.
+// The listener is removed by a hook of settleFunction, which is the callback settling the call.
+static void progpWatchAbortSignal(v8::Isolate* v8Iso, v8::Local<v8::Object> signal, int64_t abortId, v8::Local<v8::Value> settleFunction) {
+    v8::Local<v8::Context> v8Ctx = v8Iso->GetCurrentContext();
+    v8::Local<v8::Value> aborted;
+
+    if (signal->Get(v8Ctx, v8::String::NewFromUtf8Literal(v8Iso, "aborted")).ToLocal(&aborted) && aborted->BooleanValue(v8Iso)) {
+        progpAbortSignalTriggered((long long)abortId);
+        return;
+    }
+
+    v8::Local<v8::Value> addEventListener;
+
+    if (!signal->Get(v8Ctx, v8::String::NewFromUtf8Literal(v8Iso, "addEventListener")).ToLocal(&addEventListener) || !addEventListener->IsFunction()) {
+        return;
+    }
+
+    v8::Local<v8::Function> listener = v8::Function::New(v8Ctx, progpAbortSignalListener, v8::Number::New(v8Iso, (double)abortId)).ToLocalChecked();
+
+    v8::Local<v8::Object> options = v8::Object::New(v8Iso);
+    options->Set(v8Ctx, v8::String::NewFromUtf8Literal(v8Iso, "once"), v8::True(v8Iso)).Check();
+
+    v8::Local<v8::Value> args[] = { v8::String::NewFromUtf8Literal(v8Iso, "abort"), listener, options };
+
+    v8::TryCatch tryCatch(v8Iso);
+    if (addEventListener.As<v8::Function>()->Call(v8Ctx, signal, 3, args).IsEmpty()) return;
+
+    v8::Local<v8::Array> watch = v8::Array::New(v8Iso, 3);
+    watch->Set(v8Ctx, 0, signal).Check();
+    watch->Set(v8Ctx, 1, listener).Check();
+    watch->Set(v8Ctx, 2, v8::Number::New(v8Iso, (double)abortId)).Check();
+
+    progpSetSettledHook(v8Iso, settleFunction, v8::Function::New(v8Ctx, progpAbortSignalUnwatch, watch).ToLocalChecked());
+}
+
+// progpAbortableCallback is given to the binding of an async function instead of his callback.
+// The data is an array [callback, this function]. The errors from Go are converted before calling the callback.
+static void progpAbortableCallback(const v8::FunctionCallbackInfo<v8::Value>& callInfo) {
+    v8::Isolate* v8Iso = callInfo.GetIsolate();
+    v8::Local<v8::Context> v8Ctx = v8Iso->GetCurrentContext();
+
+    v8::Local<v8::Array> data = callInfo.Data().As<v8::Array>();
+    v8::Local<v8::Function> callback = data->Get(v8Ctx, 0).ToLocalChecked().As<v8::Function>();
+
+    progpCallSettledHook(v8Iso, data->Get(v8Ctx, 1).ToLocalChecked().As<v8::Object>());
+
+    std::vector<v8::Local<v8::Value>> args(callInfo.Length());
+    for (int i = 0; i < callInfo.Length(); i++) args[i] = callInfo[i];
+
+    if (!args.empty() && !args[0]->IsNullOrUndefined()) args[0] = progpConvertGoError(v8Iso, args[0]);
+
+    v8::Local<v8::Value> result;
+
+    if (callback->Call(v8Ctx, v8::Undefined(v8Iso), (int)args.size(), args.data()).ToLocal(&result)) {
+        callInfo.GetReturnValue().Set(result);
+    }
+}
+
+// progpCallWithAbortableCallback calls the binding of an async function using an AbortSignal,
+// with his callback wrapped by progpAbortableCallback. An exception thrown by the binding
+// continues to the caller.
+static void progpCallWithAbortableCallback(const v8::FunctionCallbackInfo<v8::Value>& callInfo, v8::FunctionCallback binding, int callbackIndex) {
+    v8::Isolate* v8Iso = callInfo.GetIsolate();
+    v8::Local<v8::Context> v8Ctx = v8Iso->GetCurrentContext();
+
+    std::vector<v8::Local<v8::Value>> args(callInfo.Length());
+    for (int i = 0; i < callInfo.Length(); i++) args[i] = callInfo[i];
+
+    if ((callbackIndex < callInfo.Length()) && callInfo[callbackIndex]->IsFunction()) {
+        v8::Local<v8::Array> data = v8::Array::New(v8Iso, 2);
+        data->Set(v8Ctx, 0, callInfo[callbackIndex]).Check();
+
+        v8::Local<v8::Function> wrapper = v8::Function::New(v8Ctx, progpAbortableCallback, data).ToLocalChecked();
+        data->Set(v8Ctx, 1, wrapper).Check();
+        args[callbackIndex] = wrapper;
+    }
+
+    v8::Local<v8::Function> bindingFunction = v8::Function::New(v8Ctx, binding, callInfo.Data()).ToLocalChecked();
+    v8::Local<v8::Value> result;
+
+    if (bindingFunction->Call(v8Ctx, callInfo.This(), (int)args.size(), args.data()).ToLocal(&result)) {
+        callInfo.GetReturnValue().Set(result);
+    }
+}
+`
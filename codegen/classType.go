@@ -0,0 +1,244 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codegen
+
+import (
+	"errors"
+	"github.com/progpjs/progpAPI/v2"
+	"strconv"
+	"strings"
+)
+
+// TypeClassInstance is the type of the instances of a class registered with progpAPI.FunctionModule.AddClass.
+// An instance keeps the id of the SharedResource containing the Go value, which is what is given to Go.
+type TypeClassInstance struct {
+	class *progpAPI.RegisteredClass
+}
+
+func newTypeClassInstance(class *progpAPI.RegisteredClass) *TypeClassInstance {
+	return &TypeClassInstance{class: class}
+}
+
+func (m *TypeClassInstance) CppToCgoParamCall(paramName string, ctx *ProgpV8CodeGenerator) string {
+	return paramName
+}
+
+func (m *TypeClassInstance) CppArgResourcesFreeing(paramName string, ctx *ProgpV8CodeGenerator) string {
+	return ""
+}
+
+func (m *TypeClassInstance) V8ToCppDecoder(ctx *ProgpV8CodeGenerator) string {
+	return ""
+}
+
+// V8ValueToCppDecoder decodes an instance. The position 0 is used for the
+// instance receiving a method call, which is the 'this' of the call.
+func (m *TypeClassInstance) V8ValueToCppDecoder(paramName string, v8Value string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	ctx.AddCppHelper("throwErrors", cppHelperThrowErrors)
+	ctx.AddCppHelper("classes", cppHelperClasses)
+
+	errorMessage := "argument " + strconv.Itoa(paramPosition) + " must be an instance of " + m.class.JsClassName
+
+	if paramPosition == 0 {
+		errorMessage = "the method must be called on an instance of " + m.class.JsClassName
+	}
+
+	return "    double " + paramName + ";\n" +
+		"    if (!progpGetClassResourceId(v8Iso, " + v8Value + ", &" + paramName + ")) PROGP_THROW_TYPE_ERROR(\"" + errorMessage + "\");"
+}
+
+// ReturnTypeWrapper is only used by the constructor, which is the only function returning instances.
+func (m *TypeClassInstance) ReturnTypeWrapper(ctx *ProgpV8CodeGenerator) string {
+	return "ProgpFunctionReturnLong"
+}
+
+// ReturnTypeEncoder stores the id of the resource into the instance created by javascript.
+func (m *TypeClassInstance) ReturnTypeEncoder(ctx *ProgpV8CodeGenerator) string {
+	return "progpSetClassResourceId(v8Iso, callInfo.This(), (double)res);"
+}
+
+func (m *TypeClassInstance) CgoFunctionParamType(ctx *ProgpV8CodeGenerator) string {
+	return "C.double"
+}
+
+func (m *TypeClassInstance) CgoToGoDecoding(paramName string, ctx *ProgpV8CodeGenerator) (string, string) {
+	goTypeName := m.class.GoType.String()
+	valueName := paramName + "_value"
+
+	template := `	var %VALUE% %TYPE%
+
+	if %PARAM%_res := resolveSharedResourceFromDouble(res.currentEvent.id, %PARAM%); %PARAM%_res != nil {
+		%VALUE%, _ = %PARAM%_res.Value.(%TYPE%)
+	}
+
+	if %VALUE% == nil {
+		res.errorMessage = C.CString("the instance of %CLASS% is disposed")
+		return
+	}`
+
+	template = strings.ReplaceAll(template, "%VALUE%", valueName)
+	template = strings.ReplaceAll(template, "%TYPE%", goTypeName)
+	template = strings.ReplaceAll(template, "%PARAM%", paramName)
+	template = strings.ReplaceAll(template, "%CLASS%", m.class.JsClassName)
+
+	return template, valueName
+}
+
+func (m *TypeClassInstance) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string {
+	ctx.AddNamespace("github.com/progpjs/progpAPI/v2")
+
+	template := `
+	if goRes == nil {
+		res.errorMessage = C.CString("the constructor of %CLASS% returned nil")
+		return
+	}
+
+	instance := getSharedResourceContainerFromUIntPtr(res.currentEvent.id).NewSharedResource(goRes, progpAPI.DisposeClassValue)
+	res.value = C.long(instance.GetId())`
+
+	return strings.ReplaceAll(template, "%CLASS%", m.class.JsClassName)
+}
+
+// checkClassTypes returns an error if the instances of a class are used where they can't be.
+// Only the constructor returns them, and the async functions can't receive them since the
+// call would never end if the instance is disposed.
+func (m *ProgpV8CodeGenerator) checkClassTypes(fct *progpAPI.RegisteredFunction) error {
+	if _, isInstance := m.getType(fct.GoFunctionInfos.ReturnType).(*TypeClassInstance); isInstance && !fct.IsClassConstructor {
+		return errors.New("function " + fct.GoFunctionName + ": the instances of a class can only be returned by his constructor")
+	}
+
+	if fct.IsAsync || fct.IsPromise {
+		for _, paramType := range fct.GoFunctionInfos.ParamTypes {
+			if _, isInstance := m.getType(paramType).(*TypeClassInstance); isInstance {
+				return errors.New("function " + fct.GoFunctionName + ": the instances of a class can't be given to an async function")
+			}
+		}
+	}
+
+	return nil
+}
+
+// cppClassConstructorPrefix returns the C++ code which starts the constructor of a class.
+// When the class is bound, the constructor is called once without new by progpBindClassMethods,
+// which allows adding the methods to the prototype with the data of the constructor.
+func (m *ProgpV8CodeGenerator) cppClassConstructorPrefix(fct *progpAPI.RegisteredFunction) string {
+	m.AddCppHelper("throwErrors", cppHelperThrowErrors)
+	m.AddCppHelper("classes", cppHelperClasses)
+
+	class := progpAPI.GetFunctionRegistry().GetClass(fct.Group, fct.ClassName)
+	methodsTable := "gProgpClassMethods_" + fct.GoFunctionInfos.GeneratorUniqName
+
+	// The methods can be generated after the constructor.
+	declarations := ""
+	entries := ""
+
+	for _, method := range class.Methods {
+		functionName := "v8Function_" + method.GoFunctionInfos.GeneratorUniqName
+		declarations += "\nvoid " + functionName + "(const v8::FunctionCallbackInfo<v8::Value> &callInfo);"
+		entries += "\n    { " + strconv.Quote(method.GetJsMethodName()) + ", " + functionName + " },"
+	}
+
+	m.cppImplInjectThis += "\n" + declarations + "\n\nstatic const ProgpClassMethod " + methodsTable + "[] = {" + entries + "\n};"
+
+	return "    if (!callInfo.IsConstructCall()) {\n" +
+		"        if (progpIsClassInitCall(callInfo)) {\n" +
+		"            progpInitClassPrototype(v8Iso, callInfo, " + methodsTable + ", " + strconv.Itoa(len(class.Methods)) + ");\n" +
+		"            return;\n" +
+		"        }\n\n" +
+		"        PROGP_THROW_TYPE_ERROR(\"the class " + fct.ClassName + " must be created with new\");\n" +
+		"    }\n"
+}
+
+// cppHelperClasses stores the id of the resource into the instances, and creates the prototypes.
+// It's added with ProgpV8CodeGenerator.AddCppHelper("classes", cppHelperClasses).
+const cppHelperClasses = `
+#include <cstring>
+
+struct ProgpClassMethod {
+    const char* name;
+    v8::FunctionCallback callback;
+};
+
+static v8::Local<v8::Private> progpClassResourceKey(v8::Isolate* v8Iso) {
+    return v8::Private::ForApi(v8Iso, v8::String::NewFromUtf8Literal(v8Iso, "progpClassResource"));
+}
+
+// progpGetClassResourceId returns the id of the resource of an instance. Returns false if it's not an instance.
+static bool progpGetClassResourceId(v8::Isolate* v8Iso, v8::Local<v8::Value> value, double* resId) {
+    if (!value->IsObject()) return false;
+
+    v8::Local<v8::Value> id;
+    if (!value.As<v8::Object>()->GetPrivate(v8Iso->GetCurrentContext(), progpClassResourceKey(v8Iso)).ToLocal(&id) || !id->IsNumber()) return false;
+
+    *resId = id.As<v8::Number>()->Value();
+    return true;
+}
+
+static void progpSetClassResourceId(v8::Isolate* v8Iso, v8::Local<v8::Object> instance, double resId) {
+    instance->SetPrivate(v8Iso->GetCurrentContext(), progpClassResourceKey(v8Iso), v8::Number::New(v8Iso, resId)).Check();
+}
+
+// gProgpClassInitMarker is given by progpBindClassMethods to the constructor, as a v8::External
+// which scripts can't create, to tell him to add the methods to his prototype.
+static int gProgpClassInitMarker;
+
+static bool progpIsClassInitCall(const v8::FunctionCallbackInfo<v8::Value>& callInfo) {
+    return (callInfo.Length() == 1) && callInfo[0]->IsExternal() && (callInfo[0].As<v8::External>()->Value() == &gProgpClassInitMarker);
+}
+
+// progpBindClassMethods adds the methods of a class to his prototype, once his constructor is bound.
+// They are then visible before the first instance is created, and the subclasses inherit them.
+static void progpBindClassMethods(v8::Local<v8::Object> v8Host, const char* className) {
+    v8::Isolate* v8Iso = v8::Isolate::GetCurrent();
+    v8::Local<v8::Context> v8Ctx = v8Iso->GetCurrentContext();
+    v8::Local<v8::Value> constructor;
+
+    if (!v8Host->Get(v8Ctx, v8::String::NewFromUtf8(v8Iso, className).ToLocalChecked()).ToLocal(&constructor) || !constructor->IsFunction()) return;
+
+    v8::Local<v8::Value> args[] = { v8::External::New(v8Iso, &gProgpClassInitMarker) };
+    // Returns nothing, the methods being added by the constructor.
+    (void)constructor.As<v8::Function>()->Call(v8Ctx, constructor, 1, args);
+}
+
+// progpInitClassPrototype adds the methods to the prototype of the constructor, which is the 'this' of the call
+// done by progpBindClassMethods. The methods are created with the data of the constructor, which allows them finding their context.
+static void progpInitClassPrototype(v8::Isolate* v8Iso, const v8::FunctionCallbackInfo<v8::Value>& callInfo, const ProgpClassMethod* methods, int methodCount) {
+    v8::Local<v8::Context> v8Ctx = v8Iso->GetCurrentContext();
+    v8::Local<v8::Value> prototype;
+
+    if (!callInfo.This()->Get(v8Ctx, v8::String::NewFromUtf8Literal(v8Iso, "prototype")).ToLocal(&prototype) || !prototype->IsObject()) return;
+
+    v8::Local<v8::Object> proto = prototype.As<v8::Object>();
+
+    for (int i = 0; i < methodCount; i++) {
+        v8::Local<v8::String> name = v8::String::NewFromUtf8(v8Iso, methods[i].name).ToLocalChecked();
+        v8::Local<v8::Function> method = v8::Function::New(v8Ctx, methods[i].callback, callInfo.Data()).ToLocalChecked();
+        method->SetName(name);
+        proto->Set(v8Ctx, name, method).Check();
+
+        if (strcmp(methods[i].name, "dispose") == 0) {
+            // Allows the javascript 'using' keyword.
+            v8::Local<v8::Value> symbolClass, disposeSymbol;
+
+            if (v8Ctx->Global()->Get(v8Ctx, v8::String::NewFromUtf8Literal(v8Iso, "Symbol")).ToLocal(&symbolClass) && symbolClass->IsObject() &&
+                symbolClass.As<v8::Object>()->Get(v8Ctx, v8::String::NewFromUtf8Literal(v8Iso, "dispose")).ToLocal(&disposeSymbol) && disposeSymbol->IsSymbol()) {
+                proto->Set(v8Ctx, disposeSymbol, method).Check();
+            }
+        }
+    }
+}
+`
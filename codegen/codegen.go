@@ -17,11 +17,12 @@
 package codegen
 
 import (
+	"errors"
 	"fmt"
 	"github.com/progpjs/progpAPI/v2"
-	"log"
 	"os"
 	"path"
+	"reflect"
 	"slices"
 	"sort"
 	"strconv"
@@ -34,14 +35,22 @@ type ProgpV8CodeGenerator struct {
 	CurrentFunction *progpAPI.RegisteredFunction
 
 	outputDir    string
+	isDryRun     bool
+	result       *GenerationResult
 	namespaces   map[string]bool
 	functionList []*progpAPI.RegisteredFunction
 	typeMap      map[string]IsTypeHandler
 
+	// typeErrors are found when creating the generator, and reported by GenerateCode.
+	typeErrors []error
+
 	cppImplInjectThis   string
 	cppHeaderInjectThis string
 	goLangInjectThis    string
 
+	cppHelpers      string
+	cppHelpersAdded map[string]bool
+
 	fileCppImpl   string
 	fileCppHeader string
 	fileGoLang    string
@@ -55,6 +64,15 @@ func NewProgpV8Codegen() *ProgpV8CodeGenerator {
 	typeMap[""] = &TypeVoid{}
 	typeMap["bool"] = &TypeBool{}
 	typeMap["int"] = &TypeInt{}
+	typeMap["int8"] = newTypeInt("int8", 8, false)
+	typeMap["int16"] = newTypeInt("int16", 16, false)
+	typeMap["int32"] = newTypeInt("int32", 32, false)
+	typeMap["int64"] = newTypeInt("int64", 64, false)
+	typeMap["uint"] = newTypeInt("uint", 0, true)
+	typeMap["uint8"] = newTypeInt("uint8", 8, true)
+	typeMap["uint16"] = newTypeInt("uint16", 16, true)
+	typeMap["uint32"] = newTypeInt("uint32", 32, true)
+	typeMap["uint64"] = newTypeInt("uint64", 64, true)
 	typeMap["float32"] = &TypeFloat32{}
 	typeMap["float64"] = &TypeFloat64{}
 	typeMap["string"] = &TypeString{}
@@ -63,36 +81,92 @@ func NewProgpV8Codegen() *ProgpV8CodeGenerator {
 	typeMap["progpAPI.JsFunction"] = &TypeJsFunction{}
 	typeMap["*progpAPI.SharedResource"] = &TypeSharedResource{}
 	typeMap["*progpAPI.SharedResourceContainer"] = &TypeSharedResourceContainer{}
+	typeMap["context.Context"] = &TypeGoContext{}
 	typeMap["progpAPI.StringBuffer"] = &TypeStringBuffer{}
-
-	//endregion
+	typeMap["time.Time"] = &TypeTime{}
+	typeMap["time.Duration"] = &TypeDuration{}
+	typeMap["[]float64"] = newTypeNumericSlice("float64", "double", "Float64Array", 8)
+	typeMap["[]float32"] = newTypeNumericSlice("float32", "float", "Float32Array", 4)
+	typeMap["[]int8"] = newTypeNumericSlice("int8", "int8_t", "Int8Array", 1)
+	typeMap["[]int16"] = newTypeNumericSlice("int16", "int16_t", "Int16Array", 2)
+	typeMap["[]int32"] = newTypeNumericSlice("int32", "int32_t", "Int32Array", 4)
+	typeMap["[]int64"] = newTypeNumericSlice("int64", "int64_t", "BigInt64Array", 8)
+	typeMap["[]uint16"] = newTypeNumericSlice("uint16", "uint16_t", "Uint16Array", 2)
+	typeMap["[]uint32"] = newTypeNumericSlice("uint32", "uint32_t", "Uint32Array", 4)
+	typeMap["[]uint64"] = newTypeNumericSlice("uint64", "uint64_t", "BigUint64Array", 8)
+	typeMap["[]string"] = &TypeStringSlice{}
+	typeMap["map[string]string"] = &TypeStringMap{}
+
+	// Handlers added with RegisterTypeHandler, they can replace the built-in ones.
+	addRegisteredTypeHandlers(typeMap)
 
 	fctRegistry := progpAPI.GetFunctionRegistry()
+	var typeErrors []error
+
+	for _, class := range fctRegistry.GetAllClasses() {
+		typeName := class.GoType.String()
+
+		if GetRegisteredTypeHandler(typeName) != nil {
+			// Same name, but can be from another package, see RegisterTypeHandlerFor.
+			typeErrors = append(typeErrors, errors.New("class "+class.JsClassName+": the type "+typeName+" of the package "+typePackagePath(class.GoType)+
+				" has a handler registered with RegisterTypeHandler, which can't be used for the instances of a class"))
+
+			continue
+		}
+
+		typeMap[typeName] = newTypeClassInstance(class)
+	}
+
+	//endregion
 	namespaces := fctRegistry.GetNamespaces()
 
 	// Function list must be sorted in order to always generate the same output.
 	functionList := fctRegistry.GetAllFunctions(true)
 
 	return &ProgpV8CodeGenerator{
-		namespaces:   namespaces,
-		functionList: functionList,
-		typeMap:      typeMap,
+		namespaces:      namespaces,
+		functionList:    functionList,
+		typeMap:         typeMap,
+		typeErrors:      typeErrors,
+		cppHelpersAdded: make(map[string]bool),
 	}
 }
 
-func (m *ProgpV8CodeGenerator) GenerateCode(autoUpdateDir string) {
+// EnableDryRun allows computing the changes without writing the files.
+// It's mainly used to check that the committed bindings aren't stale.
+func (m *ProgpV8CodeGenerator) EnableDryRun(enabled bool) {
+	m.isDryRun = enabled
+}
+
+// GenerateCode generates the bindings inside the directory autoUpdateDir.
+// It doesn't exit when the files are updated, it's the responsibility
+// of the caller to check the result and to restart if required.
+func (m *ProgpV8CodeGenerator) GenerateCode(autoUpdateDir string) *GenerationResult {
+	m.result = newGenerationResult(m.isDryRun)
+
+	// Functions with errors aren't registered, so their bindings
+	// would silently be missing if we don't stop here.
+	//
+	if regErrors := progpAPI.GetFunctionRegistry().Validate(); len(regErrors) != 0 {
+		for i := range regErrors {
+			m.result.Errors = append(m.result.Errors, &regErrors[i])
+		}
+
+		return m.result
+	}
+
+	if len(m.typeErrors) != 0 {
+		m.result.Errors = append(m.result.Errors, m.typeErrors...)
+		return m.result
+	}
+
 	if autoUpdateDir == "" {
-		return
+		return m.result
 	}
 
-	if state, err := os.Stat(autoUpdateDir); err == nil {
-		if !state.IsDir() {
-			println("Error, directory ", autoUpdateDir, " isn't a valid directory")
-			os.Exit(1)
-		}
-	} else {
-		println("Error, directory ", autoUpdateDir, " isn't a valid directory")
-		os.Exit(1)
+	if state, err := os.Stat(autoUpdateDir); (err != nil) || !state.IsDir() {
+		m.result.Errors = append(m.result.Errors, errors.New("directory "+autoUpdateDir+" isn't a valid directory"))
+		return m.result
 	}
 
 	m.outputDir = autoUpdateDir
@@ -100,7 +174,11 @@ func (m *ProgpV8CodeGenerator) GenerateCode(autoUpdateDir string) {
 	m.generateFunctionCallers()
 	m.generateCodeForExportedGoFunctions()
 
-	m.generateFinalFiles()
+	if !m.result.HasErrors() {
+		m.generateFinalFiles()
+	}
+
+	return m.result
 }
 
 //region Common
@@ -124,31 +202,24 @@ func (m *ProgpV8CodeGenerator) getNamespaces() []string {
 	return nsListArray
 }
 
-func (m *ProgpV8CodeGenerator) saveFileIfNotTheSame(filePath string, newContent string) bool {
-	oldContentB, err := os.ReadFile(filePath)
-	oldContent := string(oldContentB)
-
-	if (err != nil) || (oldContent != newContent) {
-		dirPath := path.Dir(filePath)
-
-		err = os.MkdirAll(dirPath, os.ModePerm)
-		if err != nil {
-			log.Fatal("Can't create directory " + dirPath)
-		}
-
-		err = os.WriteFile(filePath, []byte(newContent), os.ModePerm)
-		if err != nil {
-			log.Fatal("Can't write file " + filePath)
-		}
-
-		_ = os.WriteFile(filePath+".old", []byte(oldContent), os.ModePerm)
+// AddCppHelper adds C++ code at the beginning of the generated file, before the functions.
+// It's for the macros and the functions shared by the code generated by the type handlers.
+// The code is only added once for a name.
+func (m *ProgpV8CodeGenerator) AddCppHelper(name string, cppCode string) {
+	if m.cppHelpersAdded[name] {
+		return
+	}
 
-		println("Codegen has updated file " + filePath)
+	m.cppHelpersAdded[name] = true
+	m.cppHelpers += "\n" + cppCode
+}
 
-		return true
-	}
+func (m *ProgpV8CodeGenerator) saveFileIfNotTheSame(filePath string, newContent string) bool {
+	return m.result.saveFile(filePath, newContent, true)
+}
 
-	return false
+func (m *ProgpV8CodeGenerator) addError(err error) {
+	m.result.Errors = append(m.result.Errors, err)
 }
 
 func (m *ProgpV8CodeGenerator) generateFinalFiles() {
@@ -162,6 +233,8 @@ func (m *ProgpV8CodeGenerator) generateFinalFiles() {
 
 	var template string
 
+	manifest := progpAPI.GetFunctionRegistry().BuildBindingManifest()
+
 	//region File : generated.cpp
 
 	template = `#ifndef PROGP_STANDALONE
@@ -170,11 +243,12 @@ func (m *ProgpV8CodeGenerator) generateFinalFiles() {
 #include "_cgo_export.h"
 #include <iostream>
 #include <stdexcept>
-%INJECT_HERE%
+%HELPERS%%INJECT_HERE%
 
 #endif // PROGP_STANDALONE
 `
 
+	template = strings.ReplaceAll(template, "%HELPERS%", m.cppHelpers)
 	template = strings.ReplaceAll(template, "%INJECT_HERE%", m.cppImplInjectThis)
 	m.fileCppImpl += template
 
@@ -206,34 +280,26 @@ import (%NAMESPACES%
 )
 
 %INJECT_HERE%
+
+const progpBindingManifest = %MANIFEST%
+
+func init() {
+	progpAPI.SetLinkedBindingManifest(progpBindingManifest)
+}
 `
 	template = strings.ReplaceAll(template, "%INJECT_HERE%", m.goLangInjectThis)
+	template = strings.ReplaceAll(template, "%MANIFEST%", strconv.Quote(manifest.ToJson(false)))
 	template = strings.ReplaceAll(template, "%NAMESPACES%", nsList)
 	m.fileGoLang += template
 
 	//endregion
 
-	hasUpdated := false
+	m.saveFileIfNotTheSame(path.Join(m.outputDir, "generated.cpp"), m.fileCppImpl)
+	m.saveFileIfNotTheSame(path.Join(m.outputDir, "generated.h"), m.fileCppHeader)
+	m.saveFileIfNotTheSame(path.Join(m.outputDir, "generated.go"), m.fileGoLang)
 
-	if m.saveFileIfNotTheSame(path.Join(m.outputDir, "generated.cpp"), m.fileCppImpl) {
-		hasUpdated = true
-	}
-
-	if m.saveFileIfNotTheSame(path.Join(m.outputDir, "generated.h"), m.fileCppHeader) {
-		hasUpdated = true
-	}
-
-	if m.saveFileIfNotTheSame(path.Join(m.outputDir, "generated.go"), m.fileGoLang) {
-		hasUpdated = true
-	}
-
-	if hasUpdated {
-		println("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
-		println("!  Javascript binding code has been updated.  !")
-		println("!  A restart is required.                     !")
-		println("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
-		os.Exit(1)
-	}
+	// Isn't required by the engine, but allows auditing the bindings without reading the generated code.
+	m.saveFileIfNotTheSame(path.Join(m.outputDir, "manifest.json"), manifest.ToJson(true))
 }
 
 //endregion
@@ -243,8 +309,7 @@ import (%NAMESPACES%
 func (m *ProgpV8CodeGenerator) generateCodeForExportedGoFunctions() {
 	for _, f := range m.functionList {
 		if err := m.glueCodeCreateBindingFunctionsFor(f); err != nil {
-			log.Fatal(err)
-			return
+			m.addError(err)
 		}
 	}
 
@@ -252,7 +317,7 @@ func (m *ProgpV8CodeGenerator) generateCodeForExportedGoFunctions() {
 }
 
 func (m *ProgpV8CodeGenerator) tryToCreateTypeHandler(typeName string) IsTypeHandler {
-	return &CustomType{typeName: typeName}
+	return newCustomType(typeName)
 }
 
 func (m *ProgpV8CodeGenerator) getType(typeName string) IsTypeHandler {
@@ -267,19 +332,31 @@ func (m *ProgpV8CodeGenerator) getType(typeName string) IsTypeHandler {
 		return res
 	}
 
-	log.Fatal("Type " + typeName + " not found by the code generator engine")
-	return nil
+	// Files aren't written when there is an error, so we
+	// can safely continue with a placeholder.
+	//
+	m.addError(errors.New("type " + typeName + " not found by the code generator engine"))
+	return &TypeVoid{}
 }
 
 func (m *ProgpV8CodeGenerator) createGroupFunctions() {
 	toInject := "\n\nvoid exposeGoFunctionsToV8(ProgpContext progpCtx, const std::string& group, v8::Local<v8::Object> v8Host) {"
 
 	for _, f := range m.functionList {
+		if f.IsClassMethod() {
+			// Are added to the prototype of the class once his constructor is bound.
+			continue
+		}
+
 		template := "\n    PROGP_BIND_FUNCTION(\"%FUNCTION_GROUP%\", \"%FUNCTION_NAME%\", (f_progp_v8_function)v8Function_%FUNCTION_FULL_NAME%);"
 		template = strings.ReplaceAll(template, "%FUNCTION_GROUP%", f.Group)
 		template = strings.ReplaceAll(template, "%FUNCTION_NAME%", f.JsFunctionName)
 		template = strings.ReplaceAll(template, "%FUNCTION_FULL_NAME%", f.GoFunctionInfos.GeneratorUniqName)
 		toInject += template
+
+		if f.IsClassConstructor {
+			toInject += "\n    if (group == " + strconv.Quote(f.Group) + ") progpBindClassMethods(v8Host, " + strconv.Quote(f.JsFunctionName) + ");"
+		}
 	}
 
 	toInject += "\n}"
@@ -296,25 +373,197 @@ func (m *ProgpV8CodeGenerator) glueCodeCreateBindingFunctionsFor(fct *progpAPI.R
 	cppCallParamsList := ""
 	cppExtraBeforeCall := ""
 	cppFreeResources := ""
+	cppAfterSuccess := ""
+	cppOnError := ""
 
 	goParams := ""
 	goAllParamsDecoding := ""
-	goCallParamsList := ""
+	var goCallParams []string
 
-	returnTypeWrapper := m.getType(fct.GoFunctionInfos.ReturnType).ReturnTypeWrapper(m)
-	returnTypeEncoder := m.getType(fct.GoFunctionInfos.ReturnType).ReturnTypeEncoder(m)
+	// The cancel functions of the contexts of an async call, which
+	// are called once the callback or the promise settles.
+	var goContextCancels []string
 
-	if fct.IsAsync {
+	// Is the callback of an async function, wrapped by a *progpAPI.SettlingJsFunction.
+	goSettlingCallback := ""
+
+	if err := m.checkTypeHandlerCollisions(fct); err != nil {
+		return err
+	}
+
+	returnTypeHandler := m.getType(fct.GoFunctionInfos.ReturnType)
+	hasResults := len(fct.GoFunctionInfos.ResultTypes) != 0
+
+	if hasResults {
+		if err := m.checkResultTypes(fct); err != nil {
+			return err
+		}
+
+		returnTypeHandler = newTypeResults(fct)
+	}
+
+	if err := m.checkClassTypes(fct); err != nil {
+		return err
+	}
+
+	var promiseResolver IsPromiseResolver
+
+	if fct.IsPromise {
+		if err := m.checkPromiseParamTypes(fct); err != nil {
+			return err
+		}
+
+		resolver, err := m.getPromiseResolver(fct, returnTypeHandler)
+		if err != nil {
+			return err
+		}
+
+		promiseResolver = resolver
+
+		// The result is given to the callback settling the promise.
+		returnTypeHandler = m.getType("")
+	}
+
+	returnTypeWrapper := returnTypeHandler.ReturnTypeWrapper(m)
+	returnTypeEncoder := returnTypeHandler.ReturnTypeEncoder(m)
+
+	if fct.IsAsync || fct.IsPromise {
 		cppExtraBeforeCall += "\n    progp_IncreaseContextRef(progpCtx);\n    resWrapper.isAsync = true;"
+
+		// The callback won't be called if the binding fails, for example if
+		// a parameter can't be decoded or an interceptor rejects the call.
+		cppOnError += "\n\t\tprogp_DecreaseContextRef(progpCtx);"
 	}
 
+	cppParamsCount := 0
+
+	// Is the position of the callback of an async function using an AbortSignal.
+	cppAbortableCallbackIndex := -1
+
+	// A handler registered for a pointer type makes the parameter required,
+	// which can increase the minimum computed by the registry.
+	cppMinParamsCount := fct.GoFunctionInfos.MinArgCount
+
 	if len(fct.GoFunctionInfos.ParamTypes) != 0 {
 		cppAllParamsDecoding = ""
-		cppParamsCount := 0
 		cppParamOffset := 0
+		cppIsVariadic := false
 
 		for offset, paramType := range fct.GoFunctionInfos.ParamTypes {
 			argName := "p" + strconv.Itoa(offset)
+			isAbortableCallback := fct.UseAbortSignal && fct.IsAsync && (offset == len(fct.GoFunctionInfos.ParamTypes)-1)
+
+			if (offset == 0) && fct.IsClassMethod() {
+				// The instance is the 'this' of the call. It's an instance of
+				// the class, or a *progpAPI.SharedResource for the method dispose.
+				//
+				cppAllParamsDecoding += (&TypeClassInstance{class: progpAPI.GetFunctionRegistry().GetClass(fct.Group, fct.ClassName)}).V8ValueToCppDecoder(argName, "callInfo.This()", 0, m) + "\n"
+				cppCallParamsList += ", " + argName
+				goParams += ", " + argName + " C.double"
+
+				cgoParamDecoding, cgoParamCall := m.getType(paramType).CgoToGoDecoding(argName, m)
+
+				if cgoParamDecoding != "" {
+					goAllParamsDecoding += "\n" + cgoParamDecoding + "\n"
+				}
+
+				goCallParams = append(goCallParams, cgoParamCall)
+				continue
+			}
+
+			if isAbortableCallback {
+				// The signal is the argument before the callback.
+				cppAbortDecoding, cppAbortWatching := m.abortSignalCodeFor(cppParamOffset)
+
+				cppAllParamsDecoding += cppAbortDecoding
+				cppCallParamsList += ", pAbortId"
+				cppAfterSuccess += "\n" + cppAbortWatching
+				goParams += ", pAbortId C.longlong"
+
+				cppParamsCount++
+				cppParamOffset++
+				cppAbortableCallbackIndex = cppParamOffset
+			}
+
+			if fct.GoFunctionInfos.IsVariadic && (offset == len(fct.GoFunctionInfos.ParamTypes)-1) {
+				// Each remaining argument is decoded by the handler of the element type.
+				// The decoded values are gathered into a C++ array given to Go.
+				//
+				cppDecoding, cgoParamType, goDecoding, err := m.variadicParamCode(fct, offset, cppParamOffset)
+				if err != nil {
+					return err
+				}
+
+				cppAllParamsDecoding += cppDecoding
+				cppCallParamsList += ", " + argName + ".data(), " + argName + "_count"
+				goParams += ", " + argName + " " + cgoParamType + ", " + argName + "_count C.int"
+				goAllParamsDecoding += goDecoding
+
+				goCallParams = append(goCallParams, "n"+argName+"...")
+				cppIsVariadic = true
+				continue
+			}
+
+			if valueType := nullableParamValueType(fct, offset); valueType != nil {
+				// Javascript can give null or undefined, or omit the trailing ones.
+				// The value is decoded by the handler of the value type, and a flag
+				// telling if the value is set is given to Go.
+				//
+				isSetName := argName + "_isSet"
+				valueTypeName := valueType.String()
+				typeHandler := m.getType(valueTypeName)
+
+				decoder, ok := typeHandler.(IsOptionalV8ValueDecoder)
+				if !ok {
+					return errors.New("function " + fct.GoFunctionName + ": type " + valueTypeName + " can't be used as an optional parameter")
+				}
+
+				v8Value := "callInfo[" + strconv.Itoa(cppParamOffset) + "]"
+
+				cppAllParamsDecoding += "    bool " + isSetName + " = !" + v8Value + "->IsNullOrUndefined();\n"
+				cppAllParamsDecoding += decoder.OptionalV8ValueToCppDecoder(argName, v8Value, isSetName, cppParamOffset+1, m) + "\n"
+				cppCallParamsList += ", " + typeHandler.CppToCgoParamCall(argName, m) + ", " + isSetName
+
+				if freeingResources := typeHandler.CppArgResourcesFreeing(argName, m); freeingResources != "" {
+					cppFreeResources += "\n" + freeingResources
+				}
+
+				goParams += ", " + argName + " " + typeHandler.CgoFunctionParamType(m) + ", " + isSetName + " C.int"
+
+				cgoParamDecoding, cgoParamCall := typeHandler.CgoToGoDecoding(argName, m)
+				if cgoParamCall == "" {
+					cgoParamCall = argName
+				}
+
+				goValueName := "n" + argName
+				isOptional := progpAPI.IsOptionalType(fct.GoFunctionInfos.ParamTypeRefs[offset])
+
+				if isOptional {
+					goAllParamsDecoding += "\n\tvar " + goValueName + " progpAPI.Optional[" + valueTypeName + "]"
+				} else {
+					goAllParamsDecoding += "\n\tvar " + goValueName + " *" + valueTypeName
+				}
+
+				goAllParamsDecoding += "\n\n\tif " + isSetName + " != 0 {"
+
+				if cgoParamDecoding != "" {
+					goAllParamsDecoding += "\n" + cgoParamDecoding + "\n"
+				}
+
+				if isOptional {
+					goAllParamsDecoding += "\n\t\t" + goValueName + " = progpAPI.Some[" + valueTypeName + "](" + cgoParamCall + ")"
+				} else {
+					goAllParamsDecoding += "\n\t\t" + goValueName + "_value := " + cgoParamCall
+					goAllParamsDecoding += "\n\t\t" + goValueName + " = &" + goValueName + "_value"
+				}
+
+				goAllParamsDecoding += "\n\t}\n"
+				goCallParams = append(goCallParams, goValueName)
+
+				cppParamsCount++
+				cppParamOffset++
+				continue
+			}
 
 			freeingResources := m.getType(paramType).CppArgResourcesFreeing(argName, m)
 			if freeingResources != "" {
@@ -326,9 +575,15 @@ func (m *ProgpV8CodeGenerator) glueCodeCreateBindingFunctionsFor(fct *progpAPI.R
 				goParams += ", p" + strconv.Itoa(offset) + " " + asCgoParam
 			}
 
-			asV8ValueDecoder := m.getType(paramType).V8ToCppDecoder(m)
+			if decoder, ok := m.getType(paramType).(IsV8ValueDecoder); ok {
+				v8Value := "callInfo[" + strconv.Itoa(cppParamOffset) + "]"
+
+				cppCallParamsList += ", " + m.getType(paramType).CppToCgoParamCall(argName, m)
+				cppAllParamsDecoding += decoder.V8ValueToCppDecoder(argName, v8Value, cppParamOffset+1, m) + "\n"
 
-			if asV8ValueDecoder != "" {
+				cppParamsCount++
+				cppParamOffset++
+			} else if asV8ValueDecoder := m.getType(paramType).V8ToCppDecoder(m); asV8ValueDecoder != "" {
 				v := m.getType(paramType).CppToCgoParamCall(argName, m)
 
 				cppCallParamsList += ", " + v
@@ -338,24 +593,93 @@ func (m *ProgpV8CodeGenerator) glueCodeCreateBindingFunctionsFor(fct *progpAPI.R
 				cppParamOffset++
 			}
 
+			cppMinParamsCount = max(cppMinParamsCount, cppParamsCount)
+
 			cgoParamDecoding, cgoParamCall := m.getType(paramType).CgoToGoDecoding(argName, m)
 
 			if cgoParamDecoding != "" {
 				goAllParamsDecoding += "\n" + cgoParamDecoding + "\n"
 			}
 
-			if cgoParamCall != "" {
-				goCallParamsList += ", " + cgoParamCall
-			} else {
-				goCallParamsList += ", " + argName
+			if cgoParamCall == "" {
+				cgoParamCall = argName
+			}
+
+			if _, isGoContext := m.getType(paramType).(*TypeGoContext); isGoContext && (fct.IsAsync || fct.IsPromise) {
+				goContextCancels = append(goContextCancels, argName+"_cancel")
+			}
+
+			if _, isCallback := m.getType(paramType).(*TypeJsFunction); isCallback && fct.IsAsync && (offset == len(fct.GoFunctionInfos.ParamTypes)-1) {
+				// Allows knowing when the async call settles.
+				goAllParamsDecoding += "\n\tcallback := progpAPI.WatchJsFunctionSettling(" + cgoParamCall + ")\n"
+
+				for _, cancel := range goContextCancels {
+					goAllParamsDecoding += "\tcallback.CancelOnSettled(" + cancel + ")\n"
+				}
+
+				cgoParamCall = "callback"
+				goSettlingCallback = "callback"
+			}
+
+			if isAbortableCallback {
+				cgoParamCall = "abortableCall.WrapCallback(" + cgoParamCall + ")"
 			}
+
+			goCallParams = append(goCallParams, cgoParamCall)
 		}
 
-		if cppParamsCount > 0 {
+		if fct.IsPromise {
+			// Is checked before calling, in order to reject the promise.
+		} else if cppIsVariadic {
+			if cppMinParamsCount > 0 {
+				m.AddCppHelper("throwErrors", cppHelperThrowErrors)
+				minCount := strconv.Itoa(cppMinParamsCount)
+
+				cppAllParamsDecoding = "    if (callInfo.Length() < " + minCount + ") " +
+					"PROGP_THROW_TYPE_ERROR(\"expects at least " + minCount + " arguments\");\n" + cppAllParamsDecoding
+			}
+		} else if cppMinParamsCount != cppParamsCount {
+			m.AddCppHelper("throwErrors", cppHelperThrowErrors)
+
+			minCount := strconv.Itoa(cppMinParamsCount)
+			maxCount := strconv.Itoa(cppParamsCount)
+
+			cppAllParamsDecoding = "    if ((callInfo.Length() < " + minCount + ") || (callInfo.Length() > " + maxCount + ")) " +
+				"PROGP_THROW_TYPE_ERROR(\"expects between " + minCount + " and " + maxCount + " arguments\");\n" + cppAllParamsDecoding
+		} else if cppParamsCount > 0 {
 			cppAllParamsDecoding = "    V8CALLARG_EXPECT_ARGCOUNT(" + strconv.Itoa(cppParamsCount) + ");\n" + cppAllParamsDecoding
 		}
 	}
 
+	if fct.IsClassConstructor {
+		cppAllParamsDecoding = m.cppClassConstructorPrefix(fct) + cppAllParamsDecoding
+	}
+
+	if fct.IsPromise && fct.UseAbortSignal {
+		// The signal is an optional argument after the others.
+		cppAbortDecoding, cppAbortWatching := m.abortSignalCodeFor(cppParamsCount)
+
+		cppAllParamsDecoding += cppAbortDecoding
+		cppCallParamsList += ", pAbortId"
+		cppAfterSuccess += "\n" + cppAbortWatching
+		goParams += ", pAbortId C.longlong"
+
+		cppParamsCount++
+	}
+
+	if fct.UseAbortSignal {
+		// The call is only registered once the arguments are decoded, since
+		// the signal isn't watched if the binding returns an error.
+		goAllParamsDecoding = "\n\tabortableCall := progpAPI.NewAbortableCall(int64(pAbortId))\n" + goAllParamsDecoding
+		goAllParamsDecoding += "\n\tabortableCall.Start()\n"
+	}
+
+	if fct.IsPromise {
+		cppAllParamsDecoding += "    V8CALLARG_EXPECT_FUNCTION(pCallback, " + strconv.Itoa(cppParamsCount) + ");\n"
+		cppCallParamsList += ", pCallback"
+		goParams += ", pCallback C.ProgpV8FunctionPtr"
+	}
+
 	if cppExtraBeforeCall != "" {
 		cppExtraBeforeCall = "\n" + cppExtraBeforeCall
 	}
@@ -363,14 +687,12 @@ func (m *ProgpV8CodeGenerator) glueCodeCreateBindingFunctionsFor(fct *progpAPI.R
 	goParams = "res *C." + returnTypeWrapper + goParams
 	cppCallParamsList = "&resWrapper" + cppCallParamsList
 
-	if goCallParamsList != "" {
-		goCallParamsList = goCallParamsList[2:]
-	}
-
 	//endregion
 
 	//region C++ functions
 
+	m.AddCppHelper("goErrors", cppHelperGoErrors)
+
 	template := `
 
 void v8Function_%FUNCTION_FULL_NAME%(const v8::FunctionCallbackInfo<v8::Value> &callInfo) {
@@ -381,23 +703,39 @@ void v8Function_%FUNCTION_FULL_NAME%(const v8::FunctionCallbackInfo<v8::Value> &
 	resWrapper.currentEvent = progpCtx->event;
 	progpCgoBinding__%FUNCTION_FULL_NAME%(%CALL_PARAMS_LIST%);
 	%FREE_RESOURCES%
-    if (resWrapper.errorMessage!=nullptr) {
+    if (resWrapper.errorMessage!=nullptr) {%ON_ERROR%
 		auto msg = std::string(resWrapper.errorMessage);
 		delete(resWrapper.errorMessage);
+
+		// The errors with a code, and the AbortError, are thrown like for a promise.
+		if (progpIsSpecialGoError(msg)) {
+			v8Iso->ThrowException(progpNewErrorFromGo(v8Iso, msg));
+			return;
+		}
+
         throw std::runtime_error(msg.c_str());
-    } else if (resWrapper.constErrorMessage!= nullptr) {
+    } else if (resWrapper.constErrorMessage!= nullptr) {%ON_ERROR%
 		auto msg = std::string(resWrapper.errorMessage);
         throw std::runtime_error(resWrapper.errorMessage);
     }
-
+%AFTER_SUCCESS%
     %RETURN_TYPE_ENCODER%
 	PROGP_V8FUNCTION_AFTER
 }`
 
-	if fct.GoFunctionInfos.ReturnType != "" {
+	if ((fct.GoFunctionInfos.ReturnType != "") || hasResults) && (promiseResolver == nil) {
 		returnTypeEncoder = "auto res = resWrapper.value;\n    " + returnTypeEncoder
 	}
 
+	cppFunctionName := fct.GoFunctionInfos.GeneratorUniqName
+
+	if (promiseResolver != nil) || (cppAbortableCallbackIndex != -1) {
+		// The body is called by the function creating the promise,
+		// or by the function wrapping the callback.
+		cppFunctionName += "_body"
+	}
+
+	template = strings.ReplaceAll(template, "v8Function_%FUNCTION_FULL_NAME%", "v8Function_"+cppFunctionName)
 	template = strings.ReplaceAll(template, "%FUNCTION_FULL_NAME%", fct.GoFunctionInfos.GeneratorUniqName)
 	template = strings.ReplaceAll(template, "%PARAMS_DECODING%", cppAllParamsDecoding)
 	template = strings.ReplaceAll(template, "%CALL_PARAMS_LIST%", cppCallParamsList)
@@ -405,6 +743,47 @@ void v8Function_%FUNCTION_FULL_NAME%(const v8::FunctionCallbackInfo<v8::Value> &
 	template = strings.ReplaceAll(template, "%RETURN_TYPE_ENCODER%", returnTypeEncoder)
 	template = strings.ReplaceAll(template, "%EXTRA_BEFORE_CALL%", cppExtraBeforeCall)
 	template = strings.ReplaceAll(template, "%FREE_RESOURCES%", cppFreeResources)
+	template = strings.ReplaceAll(template, "%AFTER_SUCCESS%", cppAfterSuccess)
+	template = strings.ReplaceAll(template, "%ON_ERROR%", cppOnError)
+
+	if promiseResolver != nil {
+		goPromiseResolving, resultKind := promiseResolver.GoValueToPromiseResolving(m)
+
+		if (resultKind == PromiseResultMsgPack) || (resultKind == PromiseResultBigInt) {
+			m.AddCppHelper("msgPack", cppHelperMsgPack)
+		}
+
+		m.AddCppHelper("goErrors", cppHelperGoErrors)
+		m.AddCppHelper("settledHooks", cppHelperSettledHooks)
+		m.AddCppHelper("promise", cppHelperPromise)
+
+		template += `
+
+void v8Function_%FUNCTION_FULL_NAME%(const v8::FunctionCallbackInfo<v8::Value> &callInfo) {
+	progpCallAsPromise(callInfo, v8Function_%FUNCTION_FULL_NAME%_body, %MIN_ARG_COUNT%, %ARG_COUNT%, %RESULT_KIND%);
+}`
+
+		template = strings.ReplaceAll(template, "%FUNCTION_FULL_NAME%", fct.GoFunctionInfos.GeneratorUniqName)
+		template = strings.ReplaceAll(template, "%MIN_ARG_COUNT%", strconv.Itoa(cppMinParamsCount))
+		template = strings.ReplaceAll(template, "%ARG_COUNT%", strconv.Itoa(cppParamsCount))
+		template = strings.ReplaceAll(template, "%RESULT_KIND%", strconv.Itoa(int(resultKind)))
+
+		m.cppImplInjectThis += template
+		m.glueCodeCreatePromiseGoBinding(fct, promiseResolver, goPromiseResolving, goParams, goAllParamsDecoding, goCallParams, goContextCancels)
+
+		return nil
+	}
+
+	if cppAbortableCallbackIndex != -1 {
+		template += `
+
+void v8Function_%FUNCTION_FULL_NAME%(const v8::FunctionCallbackInfo<v8::Value> &callInfo) {
+	progpCallWithAbortableCallback(callInfo, v8Function_%FUNCTION_FULL_NAME%_body, %CALLBACK_INDEX%);
+}`
+
+		template = strings.ReplaceAll(template, "%FUNCTION_FULL_NAME%", fct.GoFunctionInfos.GeneratorUniqName)
+		template = strings.ReplaceAll(template, "%CALLBACK_INDEX%", strconv.Itoa(cppAbortableCallbackIndex))
+	}
 
 	m.cppImplInjectThis += template
 
@@ -415,9 +794,18 @@ void v8Function_%FUNCTION_FULL_NAME%(const v8::FunctionCallbackInfo<v8::Value> &
 	returnOutput := ""
 	returnProcessing := ""
 
-	if fct.GoFunctionInfos.ReturnType != "" {
+	if hasResults {
+		outputs := returnTypeHandler.(*TypeResults).goResultNames()
+
+		if fct.GoFunctionInfos.ReturnErrorOffset != -1 {
+			outputs = append(outputs, "err")
+		}
+
+		returnOutput = strings.Join(outputs, ", ") + " := "
+		returnProcessing = returnTypeHandler.GoValueToCgoValue(m)
+	} else if fct.GoFunctionInfos.ReturnType != "" {
 		returnOutput = "goRes := "
-		returnProcessing = m.getType(fct.GoFunctionInfos.ReturnType).GoValueToCgoValue(m)
+		returnProcessing = returnTypeHandler.GoValueToCgoValue(m)
 
 		if fct.GoFunctionInfos.ReturnErrorOffset != -1 {
 			if fct.GoFunctionInfos.ReturnErrorOffset == 0 {
@@ -434,10 +822,17 @@ void v8Function_%FUNCTION_FULL_NAME%(const v8::FunctionCallbackInfo<v8::Value> &
 		errorProcessing := `
 
 	if err != nil {
+		interceptedCall.SetError(err)%RELEASE_ABORTABLE_CALL%
 		res.errorMessage = C.CString(err.Error())
 		return
 	}`
 
+		if fct.UseAbortSignal {
+			errorProcessing = strings.ReplaceAll(errorProcessing, "%RELEASE_ABORTABLE_CALL%", "\n\t\tabortableCall.Release()")
+		} else {
+			errorProcessing = strings.ReplaceAll(errorProcessing, "%RELEASE_ABORTABLE_CALL%", "")
+		}
+
 		if returnProcessing == "" {
 			returnProcessing = errorProcessing
 		} else {
@@ -447,11 +842,13 @@ void v8Function_%FUNCTION_FULL_NAME%(const v8::FunctionCallbackInfo<v8::Value> &
 		returnProcessing = "\n" + returnProcessing
 	}
 
+	goArgs, goArgNames, goCallParams := hoistGoCallParams(goCallParams)
+
 	template = `
 //export progpCgoBinding__%FUNCTION_FULL_NAME%
 func progpCgoBinding__%FUNCTION_FULL_NAME%(%FUNCTION_PARAMS%) {
 	defer progpAPI.CatchFatalErrors()
-%PARAMS_DECODING%
+%PARAMS_DECODING%%GO_ARGS%%INTERCEPTORS%
 	%RETURN_OUTPUT%%GO_FUNCTION_NAME%(%CALL_PARAMS_LIST%)%RETURN_PROCESSING%
 }`
 
@@ -459,7 +856,16 @@ func progpCgoBinding__%FUNCTION_FULL_NAME%(%FUNCTION_PARAMS%) {
 	template = strings.ReplaceAll(template, "%FUNCTION_NAME%", fct.JsFunctionName)
 	template = strings.ReplaceAll(template, "%FUNCTION_PARAMS%", goParams)
 	template = strings.ReplaceAll(template, "%PARAMS_DECODING%", goAllParamsDecoding)
-	template = strings.ReplaceAll(template, "%CALL_PARAMS_LIST%", goCallParamsList)
+	template = strings.ReplaceAll(template, "%GO_ARGS%", goArgs)
+
+	if fct.IsAsync {
+		goCall := fct.GoFunctionName + "(" + strings.Join(goCallParams, ", ") + ")"
+		template = strings.ReplaceAll(template, "%INTERCEPTORS%", goAsyncInterceptorsCode(fct, goArgNames, goCall, goSettlingCallback))
+	} else {
+		template = strings.ReplaceAll(template, "%INTERCEPTORS%", goInterceptorsCode(fct, goArgNames))
+	}
+
+	template = strings.ReplaceAll(template, "%CALL_PARAMS_LIST%", strings.Join(goCallParams, ", "))
 	template = strings.ReplaceAll(template, "%GO_FUNCTION_NAME%", fct.GoFunctionName)
 
 	template = strings.ReplaceAll(template, "%RETURN_OUTPUT%", returnOutput)
@@ -472,10 +878,220 @@ func progpCgoBinding__%FUNCTION_FULL_NAME%(%FUNCTION_PARAMS%) {
 	return nil
 }
 
+// glueCodeCreatePromiseGoBinding creates the Go binding of a function returning a promise.
+// The arguments are decoded before returning, since they can point to the C++ memory,
+// then the function is called in a goroutine and his result is given to the callback.
+// The contexts of the call are cancelled once the promise is settled.
+func (m *ProgpV8CodeGenerator) glueCodeCreatePromiseGoBinding(fct *progpAPI.RegisteredFunction, promiseResolver IsPromiseResolver, goPromiseResolving string, goParams string, goAllParamsDecoding string, goCallParams []string, goContextCancels []string) {
+	goArgs, goArgNames, goCallParams := hoistGoCallParams(goCallParams)
+	goInterceptorsBefore, goInterceptorsInGoroutine := goPromiseInterceptorsCode(fct, goArgNames)
+
+	for _, cancel := range goContextCancels {
+		goInterceptorsInGoroutine = "\t\tdefer " + cancel + "()\n" + goInterceptorsInGoroutine
+	}
+
+	goCallback := "newV8Function(res.isAsync, pCallback, res.currentEvent)"
+
+	if fct.UseAbortSignal {
+		goCallback = "abortableCall.WrapCallback(" + goCallback + ")"
+	}
+
+	returnOutput := ""
+
+	if results, ok := promiseResolver.(*TypeResults); ok {
+		returnOutput = strings.Join(results.goResultNames(), ", ")
+	} else if fct.GoFunctionInfos.ReturnType != "" {
+		returnOutput = "goRes"
+	}
+
+	if fct.GoFunctionInfos.ReturnErrorOffset != -1 {
+		if returnOutput == "" {
+			returnOutput = "err"
+		} else if fct.GoFunctionInfos.ReturnErrorOffset == 0 {
+			returnOutput = "err, " + returnOutput
+		} else {
+			returnOutput += ", err"
+		}
+	}
+
+	goPromiseResolving = "\n\t\t" + strings.TrimLeft(goPromiseResolving, " ")
+
+	if fct.GoFunctionInfos.ReturnErrorOffset != -1 {
+		goPromiseResolving = goPromiseRejectOnError + "\n" + goPromiseResolving
+	}
+
+	if returnOutput != "" {
+		returnOutput += " := "
+	}
+
+	template := `
+//export progpCgoBinding__%FUNCTION_FULL_NAME%
+func progpCgoBinding__%FUNCTION_FULL_NAME%(%FUNCTION_PARAMS%) {
+	defer progpAPI.CatchFatalErrors()
+%PARAMS_DECODING%%GO_ARGS%
+	callback := %CALLBACK%%INTERCEPTORS_BEFORE%
+
+	progpAPI.SafeGoRoutine(func() {
+		defer progpAPI.RejectOnPanic(callback)
+%INTERCEPTORS_IN_GOROUTINE%
+		%RETURN_OUTPUT%%GO_FUNCTION_NAME%(%CALL_PARAMS_LIST%)%PROMISE_RESOLVING%
+	})
+}`
+
+	template = strings.ReplaceAll(template, "%FUNCTION_FULL_NAME%", fct.GoFunctionInfos.GeneratorUniqName)
+	template = strings.ReplaceAll(template, "%FUNCTION_PARAMS%", goParams)
+	template = strings.ReplaceAll(template, "%PARAMS_DECODING%", goAllParamsDecoding)
+	template = strings.ReplaceAll(template, "%GO_ARGS%", goArgs)
+	template = strings.ReplaceAll(template, "%CALLBACK%", goCallback)
+	template = strings.ReplaceAll(template, "%INTERCEPTORS_BEFORE%", goInterceptorsBefore)
+	template = strings.ReplaceAll(template, "%INTERCEPTORS_IN_GOROUTINE%", goInterceptorsInGoroutine)
+	template = strings.ReplaceAll(template, "%CALL_PARAMS_LIST%", strings.Join(goCallParams, ", "))
+	template = strings.ReplaceAll(template, "%GO_FUNCTION_NAME%", fct.GoFunctionName)
+	template = strings.ReplaceAll(template, "%RETURN_OUTPUT%", returnOutput)
+	template = strings.ReplaceAll(template, "%PROMISE_RESOLVING%", goPromiseResolving)
+
+	m.goLangInjectThis += template
+}
+
+// gVariadicCppItemTypes are the C++ types of the arrays given to Go,
+// for the variadic parameters which elements are passed by value.
+var gVariadicCppItemTypes = map[string]string{
+	"C.int":       "int",
+	"C.longlong":  "long long",
+	"C.ulonglong": "unsigned long long",
+	"C.double":    "double",
+}
+
+// cppHelperVariadic contains the includes required by the decoding of the variadic parameters.
+const cppHelperVariadic = `
+#include <algorithm>
+#include <string>
+#include <vector>
+`
+
+// variadicParamCode returns the code decoding the remaining arguments of a variadic function,
+// starting at the argument firstArg. Each argument is decoded by the handler of the element
+// type, like an optional parameter, and the values are gathered into a C++ array.
+//
+// It returns the C++ decoding, the cgo type of the array, and the Go code building
+// the slice "n<paramName>" from this array.
+func (m *ProgpV8CodeGenerator) variadicParamCode(fct *progpAPI.RegisteredFunction, offset int, firstArg int) (string, string, string, error) {
+	argName := "p" + strconv.Itoa(offset)
+	elemTypeName := fct.GoFunctionInfos.ParamTypeRefs[offset].Elem().String()
+	typeHandler := m.getType(elemTypeName)
+
+	newError := func() error {
+		return errors.New("function " + fct.GoFunctionName + ": variadic parameters of type " + elemTypeName + " aren't supported")
+	}
+
+	decoder, ok := typeHandler.(IsOptionalV8ValueDecoder)
+	if !ok || (typeHandler.CppArgResourcesFreeing(argName+"_item", m) != "") {
+		return "", "", "", newError()
+	}
+
+	cgoType := typeHandler.CgoFunctionParamType(m)
+
+	// The strings, buffers and encoded values are given through a pointer to a s_progp_goStringOut.
+	// Their bytes are copied, since they belong to variables only existing inside the loop.
+	isByPointer := cgoType == "*C.s_progp_goStringOut"
+	cppItemType, isByValue := gVariadicCppItemTypes[cgoType]
+
+	if !isByPointer && !isByValue {
+		return "", "", "", newError()
+	}
+
+	m.AddCppHelper("variadic", cppHelperVariadic)
+	position := strconv.Itoa(firstArg + 1)
+
+	itemDecoding := decoder.OptionalV8ValueToCppDecoder(argName+"_item", "callInfo["+strconv.Itoa(firstArg)+" + i]", "true", firstArg+1, m)
+	itemDecoding = strings.ReplaceAll(itemDecoding, "\"argument "+position+" ", "\"each argument from "+position+" ")
+	itemDecoding = "    " + strings.ReplaceAll(itemDecoding, "\n", "\n    ")
+
+	cppCode := "    int " + argName + "_count = std::max(callInfo.Length() - " + strconv.Itoa(firstArg) + ", 0);\n"
+
+	if isByPointer {
+		cppCode += "    std::vector<s_progp_goStringOut> " + argName + "(" + argName + "_count);\n"
+		cppCode += "    std::vector<std::string> " + argName + "_buffers(" + argName + "_count);\n"
+	} else {
+		cppCode += "    std::vector<" + cppItemType + "> " + argName + "(" + argName + "_count);\n"
+	}
+
+	cppCode += "    for (int i = 0; i < " + argName + "_count; i++) {\n" + itemDecoding + "\n"
+
+	if isByPointer {
+		cppCode += "        if (" + argName + "_item.n > 0) " + argName + "_buffers[i].assign(" + argName + "_item.p, " + argName + "_item.n);\n"
+		cppCode += "        " + argName + "[i].p = (char*)" + argName + "_buffers[i].data();\n"
+		cppCode += "        " + argName + "[i].n = " + argName + "_item.n;\n"
+	} else {
+		cppCode += "        " + argName + "[i] = " + argName + "_item;\n"
+	}
+
+	cppCode += "    }\n"
+
+	cgoParamDecoding, cgoParamCall := typeHandler.CgoToGoDecoding(argName+"_item", m)
+	if cgoParamCall == "" {
+		cgoParamCall = argName + "_item"
+	}
+
+	m.AddNamespace("unsafe")
+
+	goCode := "\n\t" + argName + "_items := unsafe.Slice(" + argName + ", int(" + argName + "_count))"
+	goCode += "\n\tn" + argName + " := make([]" + elemTypeName + ", len(" + argName + "_items))\n"
+	goCode += "\n\tfor i := range " + argName + "_items {"
+
+	if isByPointer {
+		goCode += "\n\t\t" + argName + "_item := &" + argName + "_items[i]"
+	} else {
+		goCode += "\n\t\t" + argName + "_item := " + argName + "_items[i]"
+		cgoType = "*" + cgoType
+	}
+
+	if cgoParamDecoding != "" {
+		goCode += "\n" + cgoParamDecoding
+	}
+
+	goCode += "\n\t\tn" + argName + "[i] = " + cgoParamCall + "\n\t}\n"
+
+	return cppCode, cgoType, goCode, nil
+}
+
+// nullableParamValueType returns the type of the value of a parameter which javascript
+// can set to null or undefined, or nil if the parameter isn't nullable.
+// A handler registered for the exact type, for example "*myModule.MyType", takes priority.
+func nullableParamValueType(fct *progpAPI.RegisteredFunction, offset int) reflect.Type {
+	infos := fct.GoFunctionInfos
+
+	if (offset >= len(infos.ParamNullable)) || !infos.ParamNullable[offset] {
+		return nil
+	}
+
+	if GetRegisteredTypeHandler(infos.ParamTypes[offset]) != nil {
+		return nil
+	}
+
+	return progpAPI.GetNullableParamValueType(infos.ParamTypeRefs[offset])
+}
+
 //endregion
 
 //region Generation of javascript functions callers
 
+func (m *ProgpV8CodeGenerator) checkFunctionCallerTypes(signature string, toBuild *functionCallerToBuild) error {
+	// Param 0 is the interface type and param 1 the function ref.
+	for _, inputParam := range toBuild.paramTypes[2:] {
+		typeHandler := m.typeMap[inputParam]
+		if typeHandler == nil {
+			return errors.New("function caller " + signature + ": unsupported type " + inputParam)
+		}
+
+		if _, isSupported := typeHandler.(IsFunctionCallerSupportedType); !isSupported {
+			return errors.New("function caller " + signature + ": type " + inputParam + " can't be sent to a javascript function")
+		}
+	}
+
+	return nil
+}
+
 func (m *ProgpV8CodeGenerator) generateFunctionCallers() {
 	allFunctionInfos := getAllFunctionCallerToBuild()
 	if allFunctionInfos == nil {
@@ -483,22 +1099,46 @@ func (m *ProgpV8CodeGenerator) generateFunctionCallers() {
 	}
 
 	var allFunctionsSign []string
-	for sign := range allFunctionInfos {
+
+	for sign, toBuild := range allFunctionInfos {
+		if err := m.checkFunctionCallerTypes(sign, toBuild); err != nil {
+			m.addError(err)
+			continue
+		}
+
 		allFunctionsSign = append(allFunctionsSign, sign)
+
+		// Required since the types of the parameters are in the function header.
+		for _, ns := range toBuild.namespaces {
+			m.AddNamespace(ns)
+		}
 	}
 
 	// Allow to always generate code in the same order.
 	slices.Sort(allFunctionsSign)
 
-	//region Generate C++ code
+	// The id is derived from the signature, doing that adding
+	// a function caller doesn't change the code of the others.
+	//
+	functionIds := make(map[string]string)
+	usedIds := make(map[string]bool)
 
-	nextFunctionId := 1
+	for _, sign := range allFunctionsSign {
+		functionId := getFunctionCallerId(sign)
+
+		for i := 2; usedIds[functionId]; i++ {
+			functionId = getFunctionCallerId(sign) + "_" + strconv.Itoa(i)
+		}
+
+		usedIds[functionId] = true
+		functionIds[sign] = functionId
+	}
+
+	//region Generate C++ code
 
 	for _, sign := range allFunctionsSign {
 		toBuild := allFunctionInfos[sign]
-
-		functionId := nextFunctionId
-		nextFunctionId++
+		functionId := functionIds[sign]
 
 		vExtra := ""
 		vArgArray := ""
@@ -518,15 +1158,7 @@ func (m *ProgpV8CodeGenerator) generateFunctionCallers() {
 
 			i -= 2
 
-			typeHandler0 := m.typeMap[inputParam]
-			if typeHandler0 == nil {
-				panic("Unsupported type " + inputParam)
-			}
-
-			typeHandler, isSupported := typeHandler0.(IsFunctionCallerSupportedType)
-			if !isSupported {
-				panic("Unsupported function caller type " + inputParam)
-			}
+			typeHandler := m.typeMap[inputParam].(IsFunctionCallerSupportedType)
 
 			vArgArray += typeHandler.FcCppToV8Encoder(i)
 			vFunctionHeader += typeHandler.FcCppFunctionHeader(i)
@@ -535,6 +1167,12 @@ func (m *ProgpV8CodeGenerator) generateFunctionCallers() {
 				// Required for buffer allocation.
 				vExtra = "v8Ctx->Enter();"
 			}
+
+			if preparer, ok := typeHandler.(IsFunctionCallerPreparer); ok {
+				if preparer.FcPrepare(m) {
+					vExtra = "v8Ctx->Enter();"
+				}
+			}
 		}
 
 		cppBodyTemplate := `
@@ -555,12 +1193,12 @@ void progpJsFunctionCaller_%FUNCTION_ID%(FCT_CALLBACK_PARAMS%FUNCTION_HEADER%);
 `
 
 		cppBodyTemplate = strings.ReplaceAll(cppBodyTemplate, "%EXTRA%", vExtra)
-		cppBodyTemplate = strings.ReplaceAll(cppBodyTemplate, "%FUNCTION_ID%", strconv.Itoa(functionId))
+		cppBodyTemplate = strings.ReplaceAll(cppBodyTemplate, "%FUNCTION_ID%", functionId)
 		cppBodyTemplate = strings.ReplaceAll(cppBodyTemplate, "%FUNCTION_HEADER%", vFunctionHeader)
 		cppBodyTemplate = strings.ReplaceAll(cppBodyTemplate, "%ARG_ARRAY%", vArgArray)
 		cppBodyTemplate = strings.ReplaceAll(cppBodyTemplate, "%ARG_COUNT%", strconv.Itoa(vArgCount))
 
-		cppHeaderTemplate = strings.ReplaceAll(cppHeaderTemplate, "%FUNCTION_ID%", strconv.Itoa(functionId))
+		cppHeaderTemplate = strings.ReplaceAll(cppHeaderTemplate, "%FUNCTION_ID%", functionId)
 		cppHeaderTemplate = strings.ReplaceAll(cppHeaderTemplate, "%FUNCTION_HEADER%", vFunctionHeader)
 
 		m.cppImplInjectThis = m.cppImplInjectThis + cppBodyTemplate
@@ -571,15 +1209,11 @@ void progpJsFunctionCaller_%FUNCTION_ID%(FCT_CALLBACK_PARAMS%FUNCTION_HEADER%);
 
 	//region Generate Go code
 
-	nextFunctionId = 1
-
 	fInitContent := ""
 
 	for _, fctSignature := range allFunctionsSign {
 		toBuild := allFunctionInfos[fctSignature]
-
-		functionId := nextFunctionId
-		nextFunctionId++
+		functionId := functionIds[fctSignature]
 
 		callParams := ""
 		goToCppConv := ""
@@ -605,7 +1239,7 @@ void progpJsFunctionCaller_%FUNCTION_ID%(FCT_CALLBACK_PARAMS%FUNCTION_HEADER%);
 			callParams += typeHandler.FcGoToCppCallParam(i)
 		}
 
-		fInitContent += fmt.Sprintf("\n    registerFunctionCaller(&jsFunctionCaller_%d{}, \"%s\")", functionId, fctSignature)
+		fInitContent += fmt.Sprintf("\n    registerFunctionCaller(&jsFunctionCaller_%s{}, \"%s\")", functionId, fctSignature)
 
 		template := `
 
@@ -613,7 +1247,7 @@ type jsFunctionCaller_%FUNCTION_ID% struct {
 }
 
 func (m *jsFunctionCaller_%FUNCTION_ID%) Call(%FUNCTION_HEADER%) {
-	jsF := jsFunction.(*v8Function)
+	jsF := progpAPI.UnwrapJsFunction(jsFunction).(*v8Function)
 	functionPtr, resourceContainer := jsF.prepareCall()
 	if functionPtr == nil {
 		return
@@ -632,7 +1266,7 @@ func (m *jsFunctionCaller_%FUNCTION_ID%) Call(%FUNCTION_HEADER%) {
 	}
 }`
 
-		template = strings.ReplaceAll(template, "%FUNCTION_ID%", strconv.Itoa(functionId))
+		template = strings.ReplaceAll(template, "%FUNCTION_ID%", functionId)
 		template = strings.ReplaceAll(template, "%FUNCTION_HEADER%", functionHeader)
 		template = strings.ReplaceAll(template, "%GO_T0_CPP_CONV%", goToCppConv)
 		template = strings.ReplaceAll(template, "%CALL_PARAM%", callParams)
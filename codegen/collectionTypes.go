@@ -0,0 +1,486 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codegen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const cppHelperCollections = `
+#include <cmath>
+#include <cstring>
+#include <limits>
+#include <string>
+#include <type_traits>
+#include <vector>
+
+template <typename T>
+static bool progpV8ToArrayItem(v8::Local<v8::Value> item, T* out) {
+    if (item->IsBigInt()) {
+        // Only allowed for BigInt64Array and BigUint64Array items.
+        if (!std::is_integral<T>::value || (sizeof(T) != 8)) return false;
+
+        bool isLossless = true;
+        if (std::is_signed<T>::value) *out = (T)item.As<v8::BigInt>()->Int64Value(&isLossless);
+        else *out = (T)item.As<v8::BigInt>()->Uint64Value(&isLossless);
+        return isLossless;
+    }
+
+    if (!item->IsNumber()) return false;
+    double d = item.As<v8::Number>()->Value();
+
+    if (std::is_integral<T>::value) {
+        if (!(d >= (double)std::numeric_limits<T>::lowest()) || !(d <= (double)std::numeric_limits<T>::max())) return false;
+        if (std::trunc(d) != d) return false;
+
+        // The max of a 64 bits integer is rounded up once converted to a double.
+        if ((sizeof(T) == 8) && (d >= (std::is_signed<T>::value ? 9223372036854775808.0 : 18446744073709551616.0))) return false;
+    }
+
+    *out = (T)d;
+    return true;
+}
+
+// Decodes a typed array, or an array of numbers, into a buffer.
+// The typed array memory is directly used, so Go does the only copy.
+// Returns 0 if ok, 1 if it's not an array and 2 if an item is invalid.
+//
+template <typename T>
+static int progpV8ToNumericArray(v8::Isolate* v8Iso, v8::Local<v8::Value> value, bool isExpectedTypedArray, std::vector<T>& items, s_progp_goStringOut* out) {
+    if (isExpectedTypedArray) {
+        auto typedArray = value.As<v8::TypedArray>();
+        out->p = (char*)typedArray->Buffer()->GetBackingStore()->Data() + typedArray->ByteOffset();
+        out->n = (int)typedArray->ByteLength();
+        return 0;
+    }
+
+    if (!value->IsArray()) return 1;
+
+    auto v8Ctx = v8Iso->GetCurrentContext();
+    auto array = value.As<v8::Array>();
+    uint32_t length = array->Length();
+    items.resize(length);
+
+    for (uint32_t i = 0; i < length; i++) {
+        v8::Local<v8::Value> item;
+        if (!array->Get(v8Ctx, i).ToLocal(&item)) return 2;
+        if (!progpV8ToArrayItem<T>(item, &items[i])) return 2;
+    }
+
+    out->p = (char*)items.data();
+    out->n = (int)(length * sizeof(T));
+    return 0;
+}
+
+template <typename TA>
+static v8::Local<TA> progpNewTypedArray(v8::Isolate* v8Iso, const void* data, size_t size, size_t itemSize) {
+    auto buffer = v8::ArrayBuffer::New(v8Iso, size);
+    if (size > 0) memcpy(buffer->GetBackingStore()->Data(), data, size);
+    return TA::New(buffer, 0, size / itemSize);
+}
+
+// Strings are packed as with progpAPI.PackStringList:
+// an uint32 little endian size followed by the UTF-8 bytes.
+//
+static void progpPackString(v8::Isolate* v8Iso, v8::Local<v8::String> value, std::string& packed) {
+    int size = value->Utf8Length(v8Iso);
+    char header[4] = {(char)(size & 0xFF), (char)((size >> 8) & 0xFF), (char)((size >> 16) & 0xFF), (char)((size >> 24) & 0xFF)};
+    packed.append(header, 4);
+
+    size_t offset = packed.size();
+    packed.resize(offset + size);
+    value->WriteUtf8(v8Iso, &packed[offset], size, nullptr, v8::String::NO_NULL_TERMINATION);
+}
+
+static bool progpUnpackString(v8::Isolate* v8Iso, const char* data, size_t size, size_t* offset, v8::Local<v8::String>* out) {
+    if (*offset + 4 > size) return false;
+
+    auto header = (const unsigned char*)data + *offset;
+    size_t itemSize = (size_t)header[0] | ((size_t)header[1] << 8) | ((size_t)header[2] << 16) | ((size_t)header[3] << 24);
+    *offset += 4;
+
+    if (*offset + itemSize > size) return false;
+
+    *out = v8::String::NewFromUtf8(v8Iso, data + *offset, v8::NewStringType::kNormal, (int)itemSize).ToLocalChecked();
+    *offset += itemSize;
+    return true;
+}
+
+// Returns 0 if ok, 1 if it's not an array and 2 if an item isn't a string.
+static int progpV8ToStringArray(v8::Isolate* v8Iso, v8::Local<v8::Value> value, std::string& packed, s_progp_goStringOut* out) {
+    if (!value->IsArray()) return 1;
+
+    auto v8Ctx = v8Iso->GetCurrentContext();
+    auto array = value.As<v8::Array>();
+    uint32_t length = array->Length();
+
+    for (uint32_t i = 0; i < length; i++) {
+        v8::Local<v8::Value> item;
+        if (!array->Get(v8Ctx, i).ToLocal(&item) || !item->IsString()) return 2;
+        progpPackString(v8Iso, item.As<v8::String>(), packed);
+    }
+
+    out->p = (char*)packed.data();
+    out->n = (int)packed.size();
+    return 0;
+}
+
+// Returns 0 if ok, 1 if it's not an object and 2 if a value isn't a string.
+static int progpV8ToStringMap(v8::Isolate* v8Iso, v8::Local<v8::Value> value, std::string& packed, s_progp_goStringOut* out) {
+    if (!value->IsObject() || value->IsArray()) return 1;
+
+    auto v8Ctx = v8Iso->GetCurrentContext();
+    auto object = value.As<v8::Object>();
+
+    v8::Local<v8::Array> keys;
+    auto filter = (v8::PropertyFilter)(v8::ONLY_ENUMERABLE | v8::SKIP_SYMBOLS);
+    if (!object->GetOwnPropertyNames(v8Ctx, filter, v8::KeyConversionMode::kConvertToString).ToLocal(&keys)) return 1;
+
+    uint32_t length = keys->Length();
+
+    for (uint32_t i = 0; i < length; i++) {
+        v8::Local<v8::Value> key, item;
+        if (!keys->Get(v8Ctx, i).ToLocal(&key) || !object->Get(v8Ctx, key).ToLocal(&item) || !item->IsString()) return 2;
+
+        progpPackString(v8Iso, key.As<v8::String>(), packed);
+        progpPackString(v8Iso, item.As<v8::String>(), packed);
+    }
+
+    out->p = (char*)packed.data();
+    out->n = (int)packed.size();
+    return 0;
+}
+
+static v8::Local<v8::Array> progpUnpackStringArray(v8::Isolate* v8Iso, const char* data, size_t size) {
+    std::vector<v8::Local<v8::Value>> items;
+    size_t offset = 0;
+    v8::Local<v8::String> item;
+
+    while (progpUnpackString(v8Iso, data, size, &offset, &item)) items.push_back(item);
+
+    return v8::Array::New(v8Iso, items.data(), items.size());
+}
+
+static v8::Local<v8::Object> progpUnpackStringMap(v8::Isolate* v8Iso, const char* data, size_t size) {
+    auto v8Ctx = v8Iso->GetCurrentContext();
+    auto res = v8::Object::New(v8Iso);
+    size_t offset = 0;
+    v8::Local<v8::String> key, item;
+
+    while (progpUnpackString(v8Iso, data, size, &offset, &key) && progpUnpackString(v8Iso, data, size, &offset, &item)) {
+        res->Set(v8Ctx, key, item).Check();
+    }
+
+    return res;
+}
+`
+
+func addCollectionHelpers(ctx *ProgpV8CodeGenerator) {
+	ctx.AddCppHelper("throwErrors", cppHelperThrowErrors)
+	ctx.AddCppHelper("collections", cppHelperCollections)
+	ctx.AddNamespace("unsafe")
+}
+
+//region []float64, []int32, ... / typed arrays
+
+// TypeNumericSlice exchanges a slice of numbers as a javascript typed array,
+// for example a []float64 as a Float64Array. As parameter, an array of numbers
+// is also accepted, but requires converting each item.
+type TypeNumericSlice struct {
+	goItemType  string
+	cppItemType string
+	jsArrayType string
+	itemSize    int
+}
+
+func newTypeNumericSlice(goItemType string, cppItemType string, jsArrayType string, itemSize int) *TypeNumericSlice {
+	return &TypeNumericSlice{goItemType: goItemType, cppItemType: cppItemType, jsArrayType: jsArrayType, itemSize: itemSize}
+}
+
+func (m *TypeNumericSlice) V8ValueToCppDecoder(paramName string, v8Value string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return m.cppDecoder(paramName, v8Value, "", paramPosition, ctx)
+}
+
+func (m *TypeNumericSlice) OptionalV8ValueToCppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return m.cppDecoder(paramName, v8Value, isSet, paramPosition, ctx)
+}
+
+func (m *TypeNumericSlice) cppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	addCollectionHelpers(ctx)
+
+	template := `    s_progp_goStringOut %PARAM_NAME%%INIT%;
+    std::vector<%CPP_TYPE%> %PARAM_NAME%_items;
+    switch (%IF_SET%progpV8ToNumericArray<%CPP_TYPE%>(v8Iso, %V8_VALUE%, %V8_VALUE%->Is%JS_TYPE%(), %PARAM_NAME%_items, &%PARAM_NAME%)) {
+        case 1: PROGP_THROW_TYPE_ERROR("argument %POSITION% must be a %JS_TYPE% or an array of numbers");
+        case 2: PROGP_THROW_RANGE_ERROR("argument %POSITION% contains an invalid %GO_TYPE% value");
+    }`
+
+	template = strings.ReplaceAll(template, "%PARAM_NAME%", paramName)
+	template = strings.ReplaceAll(template, "%V8_VALUE%", v8Value)
+	template = strings.ReplaceAll(template, "%CPP_TYPE%", m.cppItemType)
+	template = strings.ReplaceAll(template, "%JS_TYPE%", m.jsArrayType)
+	template = strings.ReplaceAll(template, "%GO_TYPE%", m.goItemType)
+	template = strings.ReplaceAll(template, "%POSITION%", strconv.Itoa(paramPosition))
+
+	return optionalSwitchReplacer(template, isSet)
+}
+
+func (m *TypeNumericSlice) CppToCgoParamCall(paramName string, ctx *ProgpV8CodeGenerator) string {
+	return "&" + paramName
+}
+
+func (m *TypeNumericSlice) V8ToCppDecoder(ctx *ProgpV8CodeGenerator) string {
+	// Is done by V8ValueToCppDecoder.
+	return ""
+}
+
+func (m *TypeNumericSlice) ReturnTypeWrapper(ctx *ProgpV8CodeGenerator) string {
+	return "ProgpFunctionReturnArrayBuffer"
+}
+
+func (m *TypeNumericSlice) ReturnTypeEncoder(ctx *ProgpV8CodeGenerator) string {
+	addCollectionHelpers(ctx)
+	return "callInfo.GetReturnValue().Set(progpNewTypedArray<v8::" + m.jsArrayType + ">(v8Iso, res, resWrapper.size, " + strconv.Itoa(m.itemSize) + "));"
+}
+
+func (m *TypeNumericSlice) CgoFunctionParamType(ctx *ProgpV8CodeGenerator) string {
+	return "*C.s_progp_goStringOut"
+}
+
+func (m *TypeNumericSlice) CppArgResourcesFreeing(paramName string, ctx *ProgpV8CodeGenerator) string {
+	return ""
+}
+
+func (m *TypeNumericSlice) CgoToGoDecoding(paramName string, ctx *ProgpV8CodeGenerator) (string, string) {
+	ctx.AddNamespace("slices")
+	ctx.AddNamespace("unsafe")
+
+	// The memory belongs to javascript, so the slice must be copied.
+	return "", "slices.Clone(unsafe.Slice((*" + m.goItemType + ")(unsafe.Pointer(" + paramName + ".p)), int(" + paramName + ".n)/" + strconv.Itoa(m.itemSize) + "))"
+}
+
+func (m *TypeNumericSlice) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string {
+	ctx.AddNamespace("unsafe")
+	return "    res.value = unsafe.Pointer(unsafe.SliceData(goRes))\n    res.size = C.int(len(goRes) * " + strconv.Itoa(m.itemSize) + ")"
+}
+
+func (m *TypeNumericSlice) FcPrepare(ctx *ProgpV8CodeGenerator) bool {
+	addCollectionHelpers(ctx)
+	return true
+}
+
+func (m *TypeNumericSlice) FcCppToV8Encoder(paramId int) string {
+	return fmt.Sprintf("    argArray[%d] = progpNewTypedArray<v8::%s>(v8Iso, p%d_buffer, p%d_size, %d);\n", paramId, m.jsArrayType, paramId, paramId, m.itemSize)
+}
+
+func (m *TypeNumericSlice) FcCppFunctionHeader(paramId int) string {
+	return fmt.Sprintf(", const char* p%d_buffer, size_t p%d_size", paramId, paramId)
+}
+
+func (m *TypeNumericSlice) FcGoToCppCallParam(paramId int) string {
+	return fmt.Sprintf("\n                (*C.char)(unsafe.Pointer(unsafe.SliceData(p%d))), C.size_t(len(p%d) * %d),", paramId, paramId, m.itemSize)
+}
+
+func (m *TypeNumericSlice) FcGoToCppConvCache(_ int) string {
+	return ""
+}
+
+//endregion
+
+//region []string
+
+// TypeStringSlice exchanges a []string as a javascript array of strings.
+// All the strings are sent in one buffer, see progpAPI.PackStringList.
+type TypeStringSlice struct {
+}
+
+func (m *TypeStringSlice) V8ValueToCppDecoder(paramName string, v8Value string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return m.cppDecoder(paramName, v8Value, "", paramPosition, ctx)
+}
+
+func (m *TypeStringSlice) OptionalV8ValueToCppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return m.cppDecoder(paramName, v8Value, isSet, paramPosition, ctx)
+}
+
+func (m *TypeStringSlice) cppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	addCollectionHelpers(ctx)
+
+	template := `    s_progp_goStringOut %PARAM_NAME%%INIT%;
+    std::string %PARAM_NAME%_packed;
+    switch (%IF_SET%progpV8ToStringArray(v8Iso, %V8_VALUE%, %PARAM_NAME%_packed, &%PARAM_NAME%)) {
+        case 1: PROGP_THROW_TYPE_ERROR("argument %POSITION% must be an array of strings");
+        case 2: PROGP_THROW_TYPE_ERROR("argument %POSITION% must only contain strings");
+    }`
+
+	template = strings.ReplaceAll(template, "%PARAM_NAME%", paramName)
+	template = strings.ReplaceAll(template, "%V8_VALUE%", v8Value)
+	template = strings.ReplaceAll(template, "%POSITION%", strconv.Itoa(paramPosition))
+
+	return optionalSwitchReplacer(template, isSet)
+}
+
+func (m *TypeStringSlice) CppToCgoParamCall(paramName string, ctx *ProgpV8CodeGenerator) string {
+	return "&" + paramName
+}
+
+func (m *TypeStringSlice) V8ToCppDecoder(ctx *ProgpV8CodeGenerator) string {
+	// Is done by V8ValueToCppDecoder.
+	return ""
+}
+
+func (m *TypeStringSlice) ReturnTypeWrapper(ctx *ProgpV8CodeGenerator) string {
+	return "ProgpFunctionReturnArrayBuffer"
+}
+
+func (m *TypeStringSlice) ReturnTypeEncoder(ctx *ProgpV8CodeGenerator) string {
+	addCollectionHelpers(ctx)
+	return "callInfo.GetReturnValue().Set(progpUnpackStringArray(v8Iso, (const char*)res, resWrapper.size));"
+}
+
+func (m *TypeStringSlice) CgoFunctionParamType(ctx *ProgpV8CodeGenerator) string {
+	return "*C.s_progp_goStringOut"
+}
+
+func (m *TypeStringSlice) CppArgResourcesFreeing(paramName string, ctx *ProgpV8CodeGenerator) string {
+	return ""
+}
+
+func (m *TypeStringSlice) CgoToGoDecoding(paramName string, ctx *ProgpV8CodeGenerator) (string, string) {
+	ctx.AddNamespace("unsafe")
+	return "", "progpAPI.UnpackStringList(unsafe.Slice((*byte)(unsafe.Pointer(" + paramName + ".p)), int(" + paramName + ".n)))"
+}
+
+func (m *TypeStringSlice) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string {
+	ctx.AddNamespace("unsafe")
+	return "    asBytes := progpAPI.PackStringList(goRes)\n    res.value = unsafe.Pointer(unsafe.SliceData(asBytes))\n    res.size = C.int(len(asBytes))"
+}
+
+func (m *TypeStringSlice) FcPrepare(ctx *ProgpV8CodeGenerator) bool {
+	addCollectionHelpers(ctx)
+	return true
+}
+
+func (m *TypeStringSlice) FcCppToV8Encoder(paramId int) string {
+	return fmt.Sprintf("    argArray[%d] = progpUnpackStringArray(v8Iso, p%d_buffer, p%d_size);\n", paramId, paramId, paramId)
+}
+
+func (m *TypeStringSlice) FcCppFunctionHeader(paramId int) string {
+	return fmt.Sprintf(", const char* p%d_buffer, size_t p%d_size", paramId, paramId)
+}
+
+func (m *TypeStringSlice) FcGoToCppCallParam(paramId int) string {
+	return fmt.Sprintf("\n                (*C.char)(unsafe.Pointer(unsafe.SliceData(p%d_packed))), C.size_t(len(p%d_packed)),", paramId, paramId)
+}
+
+func (m *TypeStringSlice) FcGoToCppConvCache(paramId int) string {
+	return fmt.Sprintf("\n        p%d_packed := progpAPI.PackStringList(p%d)", paramId, paramId)
+}
+
+//endregion
+
+//region map[string]string
+
+// TypeStringMap exchanges a map[string]string as a plain javascript object.
+// Only the enumerable own properties are read, and they must be strings.
+type TypeStringMap struct {
+}
+
+func (m *TypeStringMap) V8ValueToCppDecoder(paramName string, v8Value string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return m.cppDecoder(paramName, v8Value, "", paramPosition, ctx)
+}
+
+func (m *TypeStringMap) OptionalV8ValueToCppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return m.cppDecoder(paramName, v8Value, isSet, paramPosition, ctx)
+}
+
+func (m *TypeStringMap) cppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	addCollectionHelpers(ctx)
+
+	template := `    s_progp_goStringOut %PARAM_NAME%%INIT%;
+    std::string %PARAM_NAME%_packed;
+    switch (%IF_SET%progpV8ToStringMap(v8Iso, %V8_VALUE%, %PARAM_NAME%_packed, &%PARAM_NAME%)) {
+        case 1: PROGP_THROW_TYPE_ERROR("argument %POSITION% must be an object");
+        case 2: PROGP_THROW_TYPE_ERROR("argument %POSITION% must only contain strings");
+    }`
+
+	template = strings.ReplaceAll(template, "%PARAM_NAME%", paramName)
+	template = strings.ReplaceAll(template, "%V8_VALUE%", v8Value)
+	template = strings.ReplaceAll(template, "%POSITION%", strconv.Itoa(paramPosition))
+
+	return optionalSwitchReplacer(template, isSet)
+}
+
+func (m *TypeStringMap) CppToCgoParamCall(paramName string, ctx *ProgpV8CodeGenerator) string {
+	return "&" + paramName
+}
+
+func (m *TypeStringMap) V8ToCppDecoder(ctx *ProgpV8CodeGenerator) string {
+	// Is done by V8ValueToCppDecoder.
+	return ""
+}
+
+func (m *TypeStringMap) ReturnTypeWrapper(ctx *ProgpV8CodeGenerator) string {
+	return "ProgpFunctionReturnArrayBuffer"
+}
+
+func (m *TypeStringMap) ReturnTypeEncoder(ctx *ProgpV8CodeGenerator) string {
+	addCollectionHelpers(ctx)
+	return "callInfo.GetReturnValue().Set(progpUnpackStringMap(v8Iso, (const char*)res, resWrapper.size));"
+}
+
+func (m *TypeStringMap) CgoFunctionParamType(ctx *ProgpV8CodeGenerator) string {
+	return "*C.s_progp_goStringOut"
+}
+
+func (m *TypeStringMap) CppArgResourcesFreeing(paramName string, ctx *ProgpV8CodeGenerator) string {
+	return ""
+}
+
+func (m *TypeStringMap) CgoToGoDecoding(paramName string, ctx *ProgpV8CodeGenerator) (string, string) {
+	ctx.AddNamespace("unsafe")
+	return "", "progpAPI.UnpackStringMap(unsafe.Slice((*byte)(unsafe.Pointer(" + paramName + ".p)), int(" + paramName + ".n)))"
+}
+
+func (m *TypeStringMap) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string {
+	ctx.AddNamespace("unsafe")
+	return "    asBytes := progpAPI.PackStringMap(goRes)\n    res.value = unsafe.Pointer(unsafe.SliceData(asBytes))\n    res.size = C.int(len(asBytes))"
+}
+
+func (m *TypeStringMap) FcPrepare(ctx *ProgpV8CodeGenerator) bool {
+	addCollectionHelpers(ctx)
+	return true
+}
+
+func (m *TypeStringMap) FcCppToV8Encoder(paramId int) string {
+	return fmt.Sprintf("    argArray[%d] = progpUnpackStringMap(v8Iso, p%d_buffer, p%d_size);\n", paramId, paramId, paramId)
+}
+
+func (m *TypeStringMap) FcCppFunctionHeader(paramId int) string {
+	return fmt.Sprintf(", const char* p%d_buffer, size_t p%d_size", paramId, paramId)
+}
+
+func (m *TypeStringMap) FcGoToCppCallParam(paramId int) string {
+	return fmt.Sprintf("\n                (*C.char)(unsafe.Pointer(unsafe.SliceData(p%d_packed))), C.size_t(len(p%d_packed)),", paramId, paramId)
+}
+
+func (m *TypeStringMap) FcGoToCppConvCache(paramId int) string {
+	return fmt.Sprintf("\n        p%d_packed := progpAPI.PackStringMap(p%d)", paramId, paramId)
+}
+
+//endregion
@@ -0,0 +1,96 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codegen
+
+import (
+	"errors"
+	"sort"
+)
+
+// CommandOptions are the options of RunCommand.
+type CommandOptions struct {
+	// OutputDir is where the C++, header and Go files are generated.
+	// Nothing is generated if empty.
+	OutputDir string
+
+	// TypeScriptDir is where the TypeScript declarations are generated.
+	// Nothing is generated if empty.
+	TypeScriptDir string
+
+	// Check allows only checking if the files are up-to-date.
+	Check bool
+}
+
+// RunCommand generates the bindings for the functions currently registered.
+// It's the entry point of the command progpgen, which builds a small program
+// importing the registration packages and then calling this function.
+//
+// Returns the exit code: 0 if ok, 1 if the files are stale (only with Check) and 2 on error.
+func RunCommand(options CommandOptions) int {
+	result := newGenerationResult(options.Check)
+
+	if (options.OutputDir == "") && (options.TypeScriptDir == "") {
+		result.Errors = append(result.Errors, errors.New("no output directory"))
+	}
+
+	if options.OutputDir != "" {
+		generator := NewProgpV8Codegen()
+		generator.EnableDryRun(options.Check)
+		result.Merge(generator.GenerateCode(options.OutputDir))
+	}
+
+	if options.TypeScriptDir != "" {
+		generator := NewTypeScriptGenerator()
+		generator.EnableDryRun(options.Check)
+		result.Merge(generator.GenerateDeclarations(options.TypeScriptDir))
+	}
+
+	if options.Check {
+		var filePaths []string
+		for filePath := range result.Diffs {
+			filePaths = append(filePaths, filePath)
+		}
+
+		sort.Strings(filePaths)
+
+		for _, filePath := range filePaths {
+			print(result.Diffs[filePath])
+		}
+	}
+
+	if result.HasErrors() {
+		for _, err := range result.Errors {
+			println("Codegen error: " + err.Error())
+		}
+
+		return 2
+	}
+
+	for _, filePath := range result.ChangedFiles {
+		if options.Check {
+			println("Stale file " + filePath)
+		} else {
+			println("Codegen has updated file " + filePath)
+		}
+	}
+
+	if options.Check && result.HasChanges() {
+		return 1
+	}
+
+	return 0
+}
@@ -16,6 +16,11 @@
 
 package codegen
 
+import (
+	"strconv"
+	"strings"
+)
+
 type IsTypeHandler interface {
 	// CppToCgoParamCall allows casting const char* to char*.
 	// Strings coming from v8 are const char*, which allow to remember that they must not be deleted.
@@ -142,9 +147,195 @@ type IsTypeHandler interface {
 	GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string
 }
 
+// cppHelperThrowErrors allows the decoders to throw a javascript error, and exit the function.
+// It's added with ProgpV8CodeGenerator.AddCppHelper("throwErrors", cppHelperThrowErrors).
+const cppHelperThrowErrors = `
+#define PROGP_THROW_TYPE_ERROR(msg) { v8Iso->ThrowException(v8::Exception::TypeError(v8::String::NewFromUtf8(v8Iso, msg).ToLocalChecked())); return; }
+#define PROGP_THROW_RANGE_ERROR(msg) { v8Iso->ThrowException(v8::Exception::RangeError(v8::String::NewFromUtf8(v8Iso, msg).ToLocalChecked())); return; }
+`
+
+// cppHelperSettledHooks allows the functions settling a call, like the callback settling a promise,
+// to call a hook once the call is done. The hook is stored in a private property of the function,
+// and is removed once it returns true.
+// It's added with ProgpV8CodeGenerator.AddCppHelper("settledHooks", cppHelperSettledHooks).
+const cppHelperSettledHooks = `
+static v8::Local<v8::Private> progpSettledHookKey(v8::Isolate* v8Iso) {
+    return v8::Private::ForApi(v8Iso, v8::String::NewFromUtf8Literal(v8Iso, "progpSettledHook"));
+}
+
+static void progpSetSettledHook(v8::Isolate* v8Iso, v8::Local<v8::Value> settleFunction, v8::Local<v8::Function> hook) {
+    if (!settleFunction->IsFunction()) return;
+    settleFunction.As<v8::Object>()->SetPrivate(v8Iso->GetCurrentContext(), progpSettledHookKey(v8Iso), hook).Check();
+}
+
+static void progpCallSettledHook(v8::Isolate* v8Iso, v8::Local<v8::Object> settleFunction) {
+    v8::Local<v8::Context> v8Ctx = v8Iso->GetCurrentContext();
+    v8::Local<v8::Value> hook;
+
+    if (!settleFunction->GetPrivate(v8Ctx, progpSettledHookKey(v8Iso)).ToLocal(&hook) || !hook->IsFunction()) return;
+
+    v8::TryCatch tryCatch(v8Iso);
+    v8::Local<v8::Value> isDone;
+
+    if (hook.As<v8::Function>()->Call(v8Ctx, v8::Undefined(v8Iso), 0, nullptr).ToLocal(&isDone) && isDone->IsTrue()) {
+        settleFunction->DeletePrivate(v8Ctx, progpSettledHookKey(v8Iso)).Check();
+    }
+}
+`
+
+// cppHelperGoErrors converts the message of a Go error into a javascript error.
+// It's added with ProgpV8CodeGenerator.AddCppHelper("goErrors", cppHelperGoErrors).
+const cppHelperGoErrors = `
+#include <string>
+
+// Returns true if the message is the one of a progpAPI.AbortError, or of an
+// error with a code like progpAPI.CallLimitError, which progpNewErrorFromGo converts.
+static bool progpIsSpecialGoError(const std::string& message) {
+    if (message.rfind("AbortError: ", 0) == 0) return true;
+    return (message.rfind("ERR_", 0) == 0) && (message.find(": ") != std::string::npos);
+}
+
+static v8::Local<v8::Value> progpNewErrorFromGo(v8::Isolate* v8Iso, const std::string& message) {
+    v8::Local<v8::Context> v8Ctx = v8Iso->GetCurrentContext();
+
+    // Is a progpAPI.AbortError, which must be seen as the standard AbortError.
+    if (message.rfind("AbortError: ", 0) == 0) {
+        v8::Local<v8::Value> error = v8::Exception::Error(v8::String::NewFromUtf8(v8Iso, message.substr(12).c_str()).ToLocalChecked());
+        error.As<v8::Object>()->Set(v8Ctx, v8::String::NewFromUtf8Literal(v8Iso, "name"), v8::String::NewFromUtf8Literal(v8Iso, "AbortError")).Check();
+        return error;
+    }
+
+    // Is an error with a code, like progpAPI.CallLimitError.
+    if ((message.rfind("ERR_", 0) == 0) && (message.find(": ") != std::string::npos)) {
+        size_t sep = message.find(": ");
+        std::string code = message.substr(0, sep);
+
+        v8::Local<v8::Value> error = v8::Exception::Error(v8::String::NewFromUtf8(v8Iso, message.substr(sep + 2).c_str()).ToLocalChecked());
+        error.As<v8::Object>()->Set(v8Ctx, v8::String::NewFromUtf8Literal(v8Iso, "code"), v8::String::NewFromUtf8(v8Iso, code.c_str()).ToLocalChecked()).Check();
+        return error;
+    }
+
+    return v8::Exception::Error(v8::String::NewFromUtf8(v8Iso, message.c_str()).ToLocalChecked());
+}
+
+// progpConvertGoError converts the error given by Go to a callback, which is a string or an Error.
+// The other values, and the errors which aren't special, are kept as is.
+static v8::Local<v8::Value> progpConvertGoError(v8::Isolate* v8Iso, v8::Local<v8::Value> error) {
+    if (error->IsString()) return progpNewErrorFromGo(v8Iso, *v8::String::Utf8Value(v8Iso, error));
+    if (!error->IsNativeError()) return error;
+
+    v8::Local<v8::Context> v8Ctx = v8Iso->GetCurrentContext();
+    v8::Local<v8::Value> message;
+
+    if (!error.As<v8::Object>()->Get(v8Ctx, v8::String::NewFromUtf8Literal(v8Iso, "message")).ToLocal(&message) || !message->IsString()) {
+        return error;
+    }
+
+    std::string asString = *v8::String::Utf8Value(v8Iso, message);
+    if (!progpIsSpecialGoError(asString)) return error;
+
+    return progpNewErrorFromGo(v8Iso, asString);
+}
+`
+
 type IsFunctionCallerSupportedType interface {
 	FcCppToV8Encoder(paramId int) string
 	FcCppFunctionHeader(paramId int) string
 	FcGoToCppCallParam(paramId int) string
 	FcGoToCppConvCache(paramId int) string
 }
+
+// IsV8ValueDecoder can be implemented by a type handler when the decoding
+// can't be done with one of the V8CALLARG_EXPECT_* macros, for example
+// when the value must be checked. When implemented, V8ToCppDecoder isn't used.
+//
+// It returns the C++ code declaring the variable paramName, from v8Value which is
+// an expression of type v8::Local<v8::Value>. The position of the parameter,
+// starting at 1, allows having clear error messages.
+//
+// ===> Inside "codeBinding.cpp":
+//
+//	V8CALLARG_EXPECT_ARGCOUNT(1);
+//	int64_t p0;											<--- HERE
+//	switch (progpV8ToInt64(callInfo[0], ..., &p0)) {	<--- HERE
+//
+// .
+type IsV8ValueDecoder interface {
+	V8ValueToCppDecoder(paramName string, v8Value string, paramPosition int, ctx *ProgpV8CodeGenerator) string
+}
+
+// IsFunctionCallerPreparer can be implemented by a type supported by the function callers
+// when the generated code requires C++ helpers (see AddCppHelper) or Go imports.
+type IsFunctionCallerPreparer interface {
+	// FcPrepare is called for each function caller using this type.
+	// Returns true if the v8 context must be entered, which is
+	// required in order to create buffers and objects.
+	FcPrepare(ctx *ProgpV8CodeGenerator) bool
+}
+
+// IsOptionalV8ValueDecoder is implemented by the type handlers which values can be
+// received as an optional parameter, a pointer or a progpAPI.Optional. It's like
+// IsV8ValueDecoder, but the value is only decoded when the C++ expression isSet is true.
+// Otherwise, paramName must still be declared since it's given to the Go function.
+//
+// ===> Inside "codeBinding.cpp":
+//
+//	bool p0_isSet = !callInfo[0]->IsNullOrUndefined();
+//	double p0 = 0;											<--- HERE
+//	if (p0_isSet) { ... }									<--- HERE
+//
+// .
+type IsOptionalV8ValueDecoder interface {
+	OptionalV8ValueToCppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string
+}
+
+// cppHelperOptional contains the decoders used by the optional parameters,
+// for the types which are decoded by the V8CALLARG_EXPECT_* macros otherwise.
+const cppHelperOptional = `
+#include <string>
+
+static void progpV8StringToGoString(v8::Isolate* v8Iso, v8::Local<v8::String> value, std::string& buffer, s_progp_goStringOut* out) {
+    int size = value->Utf8Length(v8Iso);
+    buffer.resize(size);
+    if (size > 0) value->WriteUtf8(v8Iso, &buffer[0], size, nullptr, v8::String::NO_NULL_TERMINATION);
+
+    out->p = (char*)buffer.data();
+    out->n = size;
+}
+
+static bool progpV8ToJson(v8::Isolate* v8Iso, v8::Local<v8::Value> value, std::string& buffer, s_progp_goStringOut* out) {
+    v8::Local<v8::String> asJson;
+    if (!v8::JSON::Stringify(v8Iso->GetCurrentContext(), value).ToLocal(&asJson)) return false;
+
+    progpV8StringToGoString(v8Iso, asJson, buffer, out);
+    return true;
+}
+`
+
+// optionalCppDecoder returns the decoding of an optional value, where cppDecoding is only
+// executed when the value is set. The declarations are done before, since the variables
+// are used after. The variables %PARAM_NAME%, %V8_VALUE% and %POSITION% are replaced.
+func optionalCppDecoder(cppDeclarations string, cppDecoding string, paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	ctx.AddCppHelper("throwErrors", cppHelperThrowErrors)
+	ctx.AddCppHelper("optional", cppHelperOptional)
+
+	template := cppDeclarations + "\n    if (" + isSet + ") {\n" + cppDecoding + "\n    }"
+
+	template = strings.ReplaceAll(template, "%PARAM_NAME%", paramName)
+	template = strings.ReplaceAll(template, "%V8_VALUE%", v8Value)
+	template = strings.ReplaceAll(template, "%POSITION%", strconv.Itoa(paramPosition))
+
+	return template
+}
+
+// optionalSwitchReplacer completes the templates which decode a value with a switch on the
+// result of a C++ function, see TypeInt. When isSet isn't empty, the value is initialized
+// to zero and the function is only called if isSet is true. The auxiliary variables
+// stay in the same scope, since the decoded value can point to their memory.
+func optionalSwitchReplacer(template string, isSet string) string {
+	if isSet == "" {
+		return strings.NewReplacer("%INIT%", "", "%IF_SET%", "").Replace(template)
+	}
+
+	return strings.NewReplacer("%INIT%", "{}", "%IF_SET%", "!"+isSet+" ? 0 : ").Replace(template)
+}
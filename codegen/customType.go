@@ -16,8 +16,63 @@
 
 package codegen
 
+import (
+	"github.com/progpjs/progpAPI/v2"
+	"strconv"
+	"strings"
+)
+
+// CustomType handles the types without a dedicated handler, like the structs.
+// The value is encoded with JSON, or with MessagePack when selected with
+// progpAPI.FunctionRegistry.SetCustomTypeEncoding. In both cases the
+// encoded value is exchanged through a s_progp_goStringOut buffer.
 type CustomType struct {
 	typeName string
+	encoding progpAPI.CustomTypeEncoding
+}
+
+func newCustomType(typeName string) *CustomType {
+	return &CustomType{
+		typeName: typeName,
+		encoding: progpAPI.GetFunctionRegistry().GetCustomTypeEncoding(typeName),
+	}
+}
+
+func (m *CustomType) isMsgPack() bool {
+	return m.encoding == progpAPI.CustomTypeEncodingMsgPack
+}
+
+func (m *CustomType) V8ValueToCppDecoder(paramName string, v8Value string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	if !m.isMsgPack() {
+		return "    V8CALLARG_EXPECT_V8OBJECT_TOSTRING(" + paramName + ", " + strconv.Itoa(paramPosition-1) + ");"
+	}
+
+	ctx.AddCppHelper("throwErrors", cppHelperThrowErrors)
+	ctx.AddCppHelper("msgPack", cppHelperMsgPack)
+
+	template := `    s_progp_goStringOut %PARAM_NAME%;
+    std::string %PARAM_NAME%_packed;
+    if (!progpV8ToMsgPack(v8Iso, %V8_VALUE%, %PARAM_NAME%_packed, &%PARAM_NAME%)) PROGP_THROW_TYPE_ERROR("argument %POSITION% can't be encoded, it contains a function, a symbol or a cycle");`
+
+	template = strings.ReplaceAll(template, "%PARAM_NAME%", paramName)
+	template = strings.ReplaceAll(template, "%V8_VALUE%", v8Value)
+	template = strings.ReplaceAll(template, "%POSITION%", strconv.Itoa(paramPosition))
+
+	return template
+}
+
+func (m *CustomType) OptionalV8ValueToCppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	declarations := "    s_progp_goStringOut %PARAM_NAME%{};\n    std::string %PARAM_NAME%_packed;"
+
+	if m.isMsgPack() {
+		ctx.AddCppHelper("msgPack", cppHelperMsgPack)
+
+		return optionalCppDecoder(declarations, `        if (!progpV8ToMsgPack(v8Iso, %V8_VALUE%, %PARAM_NAME%_packed, &%PARAM_NAME%)) PROGP_THROW_TYPE_ERROR("argument %POSITION% can't be encoded, it contains a function, a symbol or a cycle");`,
+			paramName, v8Value, isSet, paramPosition, ctx)
+	}
+
+	return optionalCppDecoder(declarations, `        if (!progpV8ToJson(v8Iso, %V8_VALUE%, %PARAM_NAME%_packed, &%PARAM_NAME%)) PROGP_THROW_TYPE_ERROR("argument %POSITION% can't be encoded as JSON");`,
+		paramName, v8Value, isSet, paramPosition, ctx)
 }
 
 func (m *CustomType) CppToCgoParamCall(paramName string, ctx *ProgpV8CodeGenerator) string {
@@ -25,7 +80,8 @@ func (m *CustomType) CppToCgoParamCall(paramName string, ctx *ProgpV8CodeGenerat
 }
 
 func (m *CustomType) V8ToCppDecoder(ctx *ProgpV8CodeGenerator) string {
-	return "V8CALLARG_EXPECT_V8OBJECT_TOSTRING"
+	// Is done by V8ValueToCppDecoder.
+	return ""
 }
 
 func (m *CustomType) CgoFunctionParamType(ctx *ProgpV8CodeGenerator) string {
@@ -37,6 +93,13 @@ func (m *CustomType) ReturnTypeWrapper(ctx *ProgpV8CodeGenerator) string {
 }
 
 func (m *CustomType) ReturnTypeEncoder(ctx *ProgpV8CodeGenerator) string {
+	if m.isMsgPack() {
+		ctx.AddCppHelper("throwErrors", cppHelperThrowErrors)
+		ctx.AddCppHelper("msgPack", cppHelperMsgPack)
+
+		return "v8::Local<v8::Value> v8Res;\n    if (!progpMsgPackToV8(v8Iso, (const char*)res, resWrapper.size, &v8Res)) PROGP_THROW_TYPE_ERROR(\"the returned value isn't valid MessagePack\");\n    callInfo.GetReturnValue().Set(v8Res);"
+	}
+
 	return "v8::Local<v8::Value> v8Res;\n    V8VALUE_FROM_GOCUSTOM(v8Res, res, resWrapper.size);\n    callInfo.GetReturnValue().Set(v8Res);"
 }
 
@@ -47,11 +110,25 @@ func (m *CustomType) CppArgResourcesFreeing(paramName string, ctx *ProgpV8CodeGe
 
 func (m *CustomType) CgoToGoDecoding(paramName string, ctx *ProgpV8CodeGenerator) (string, string) {
 	typeName := m.typeName
-	ctx.AddNamespace("encoding/json")
+	ctx.AddNamespace("unsafe")
+
+	unmarshal := "json.Unmarshal"
+
+	if m.isMsgPack() {
+		// The bytes are only used while decoding, so they don't need to be copied.
+		unmarshal = "progpAPI.UnmarshalMsgPack"
+	} else {
+		ctx.AddNamespace("encoding/json")
+	}
 
 	res := "    b" + paramName + " := C.GoBytes(unsafe.Pointer(" + paramName + ".p), " + paramName + ".n)\n"
+
+	if m.isMsgPack() {
+		res = "    b" + paramName + " := unsafe.Slice((*byte)(unsafe.Pointer(" + paramName + ".p)), int(" + paramName + ".n))\n"
+	}
+
 	res += "    var v" + paramName + " " + typeName + "\n"
-	res += "    if err := json.Unmarshal(b" + paramName + ", &v" + paramName + "); err !=nil {\n"
+	res += "    if err := " + unmarshal + "(b" + paramName + ", &v" + paramName + "); err !=nil {\n"
 	res += "        res.errorMessage = C.CString(err.Error())\n"
 	res += "        return\n"
 	res += "    }"
@@ -60,9 +137,16 @@ func (m *CustomType) CgoToGoDecoding(paramName string, ctx *ProgpV8CodeGenerator
 }
 
 func (m *CustomType) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string {
-	ctx.AddNamespace("encoding/json")
+	marshal := "progpAPI.MarshalMsgPack"
+
+	if !m.isMsgPack() {
+		marshal = "json.Marshal"
+		ctx.AddNamespace("encoding/json")
+	}
 
-	return `    asBytes, err := json.Marshal(goRes)
+	ctx.AddNamespace("unsafe")
+
+	return `    asBytes, err := ` + marshal + `(goRes)
 
 	if err != nil {
 		res.errorMessage = C.CString(err.Error())
@@ -71,3 +155,322 @@ func (m *CustomType) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string {
 		res.size = C.int(len(asBytes))
 	}`
 }
+
+// cppHelperMsgPack encodes and decodes the values exchanged with CustomTypeEncodingMsgPack.
+// It follows the rules of JSON.stringify, except that the Date, the BigInt and
+// the binary data are kept, using the MessagePack timestamp extension for the Date.
+const cppHelperMsgPack = `
+#include <cmath>
+#include <cstdint>
+#include <cstring>
+#include <string>
+#include <vector>
+
+static void progpMsgPackWriteBE(std::string& out, uint64_t value, int size) {
+    for (int i = size - 1; i >= 0; i--) out.push_back((char)((value >> (i * 8)) & 0xFF));
+}
+
+static void progpMsgPackWriteHeader(std::string& out, size_t length, unsigned char fixCode, size_t fixMax, unsigned char code16, unsigned char code32) {
+    if (length <= fixMax) out.push_back((char)(fixCode | length));
+    else if (length <= 0xFFFF) { out.push_back((char)code16); progpMsgPackWriteBE(out, length, 2); }
+    else { out.push_back((char)code32); progpMsgPackWriteBE(out, length, 4); }
+}
+
+static void progpMsgPackWriteInt(std::string& out, int64_t value) {
+    if (value >= 0) {
+        uint64_t u = (uint64_t)value;
+        if (u <= 127) out.push_back((char)u);
+        else if (u <= 0xFF) { out.push_back((char)0xcc); progpMsgPackWriteBE(out, u, 1); }
+        else if (u <= 0xFFFF) { out.push_back((char)0xcd); progpMsgPackWriteBE(out, u, 2); }
+        else if (u <= 0xFFFFFFFF) { out.push_back((char)0xce); progpMsgPackWriteBE(out, u, 4); }
+        else { out.push_back((char)0xcf); progpMsgPackWriteBE(out, u, 8); }
+    }
+    else if (value >= -32) out.push_back((char)value);
+    else { out.push_back((char)0xd3); progpMsgPackWriteBE(out, (uint64_t)value, 8); }
+}
+
+static void progpMsgPackWriteBinary(std::string& out, const void* data, size_t size) {
+    if (size <= 0xFF) { out.push_back((char)0xc4); progpMsgPackWriteBE(out, size, 1); }
+    else if (size <= 0xFFFF) { out.push_back((char)0xc5); progpMsgPackWriteBE(out, size, 2); }
+    else { out.push_back((char)0xc6); progpMsgPackWriteBE(out, size, 4); }
+    if (size > 0) out.append((const char*)data, size);
+}
+
+static void progpMsgPackWriteString(v8::Isolate* v8Iso, std::string& out, v8::Local<v8::String> value) {
+    size_t size = (size_t)value->Utf8Length(v8Iso);
+
+    if ((size > 31) && (size <= 0xFF)) { out.push_back((char)0xd9); progpMsgPackWriteBE(out, size, 1); }
+    else progpMsgPackWriteHeader(out, size, 0xa0, 31, 0xda, 0xdb);
+
+    size_t offset = out.size();
+    out.resize(offset + size);
+    value->WriteUtf8(v8Iso, &out[offset], (int)size, nullptr, v8::String::NO_NULL_TERMINATION);
+}
+
+// Values ignored inside an object, as with JSON.stringify.
+static bool progpMsgPackIsSkipped(v8::Local<v8::Value> value) {
+    return value->IsUndefined() || value->IsFunction() || value->IsSymbol();
+}
+
+static bool progpMsgPackWrite(v8::Isolate* v8Iso, v8::Local<v8::Context> v8Ctx, v8::Local<v8::Value> value, std::string& out, int depth) {
+    if (depth > 1000) return false;
+
+    if (value->IsNullOrUndefined()) { out.push_back((char)0xc0); return true; }
+    if (value->IsBoolean()) { out.push_back(value->IsTrue() ? (char)0xc3 : (char)0xc2); return true; }
+
+    if (value->IsNumber()) {
+        double d = value.As<v8::Number>()->Value();
+
+        if ((std::trunc(d) == d) && (std::fabs(d) <= 9007199254740991.0)) progpMsgPackWriteInt(out, (int64_t)d);
+        else { uint64_t bits; memcpy(&bits, &d, 8); out.push_back((char)0xcb); progpMsgPackWriteBE(out, bits, 8); }
+        return true;
+    }
+
+    if (value->IsBigInt()) {
+        bool isLossless = true;
+        int64_t i = value.As<v8::BigInt>()->Int64Value(&isLossless);
+        if (isLossless) { progpMsgPackWriteInt(out, i); return true; }
+
+        uint64_t u = value.As<v8::BigInt>()->Uint64Value(&isLossless);
+        if (!isLossless) return false;
+        out.push_back((char)0xcf); progpMsgPackWriteBE(out, u, 8);
+        return true;
+    }
+
+    if (value->IsString()) { progpMsgPackWriteString(v8Iso, out, value.As<v8::String>()); return true; }
+
+    if (value->IsDate()) {
+        double ms = value.As<v8::Date>()->ValueOf();
+        if (std::isnan(ms)) { out.push_back((char)0xc0); return true; }
+
+        double sec = std::floor(ms / 1000);
+        uint64_t nsec = (uint64_t)((ms - sec * 1000) * 1000000);
+
+        // Timestamp 96: ext 8, size 12, type -1.
+        out.push_back((char)0xc7); out.push_back((char)12); out.push_back((char)0xff);
+        progpMsgPackWriteBE(out, nsec, 4);
+        progpMsgPackWriteBE(out, (uint64_t)(int64_t)sec, 8);
+        return true;
+    }
+
+    if (value->IsArrayBufferView()) {
+        auto view = value.As<v8::ArrayBufferView>();
+        std::vector<char> bytes(view->ByteLength());
+        if (!bytes.empty()) view->CopyContents(bytes.data(), bytes.size());
+        progpMsgPackWriteBinary(out, bytes.data(), bytes.size());
+        return true;
+    }
+
+    if (value->IsArrayBuffer()) {
+        auto buffer = value.As<v8::ArrayBuffer>();
+        progpMsgPackWriteBinary(out, buffer->GetBackingStore()->Data(), buffer->ByteLength());
+        return true;
+    }
+
+    if (value->IsArray()) {
+        auto array = value.As<v8::Array>();
+        uint32_t length = array->Length();
+        progpMsgPackWriteHeader(out, length, 0x90, 15, 0xdc, 0xdd);
+
+        for (uint32_t i = 0; i < length; i++) {
+            v8::Local<v8::Value> item;
+            if (!array->Get(v8Ctx, i).ToLocal(&item)) return false;
+
+            // As with JSON.stringify, the skipped values are null inside an array.
+            if (progpMsgPackIsSkipped(item)) { out.push_back((char)0xc0); continue; }
+            if (!progpMsgPackWrite(v8Iso, v8Ctx, item, out, depth + 1)) return false;
+        }
+
+        return true;
+    }
+
+    if (value->IsObject() && !value->IsFunction()) {
+        auto object = value.As<v8::Object>();
+
+        v8::Local<v8::Array> keys;
+        auto filter = (v8::PropertyFilter)(v8::ONLY_ENUMERABLE | v8::SKIP_SYMBOLS);
+        if (!object->GetOwnPropertyNames(v8Ctx, filter, v8::KeyConversionMode::kConvertToString).ToLocal(&keys)) return false;
+
+        std::vector<v8::Local<v8::Value>> entries;
+        uint32_t length = keys->Length();
+
+        for (uint32_t i = 0; i < length; i++) {
+            v8::Local<v8::Value> key, item;
+            if (!keys->Get(v8Ctx, i).ToLocal(&key) || !object->Get(v8Ctx, key).ToLocal(&item)) return false;
+            if (progpMsgPackIsSkipped(item)) continue;
+
+            entries.push_back(key);
+            entries.push_back(item);
+        }
+
+        progpMsgPackWriteHeader(out, entries.size() / 2, 0x80, 15, 0xde, 0xdf);
+
+        for (size_t i = 0; i < entries.size(); i += 2) {
+            progpMsgPackWriteString(v8Iso, out, entries[i].As<v8::String>());
+            if (!progpMsgPackWrite(v8Iso, v8Ctx, entries[i + 1], out, depth + 1)) return false;
+        }
+
+        return true;
+    }
+
+    // Is a function or a symbol.
+    return false;
+}
+
+static bool progpV8ToMsgPack(v8::Isolate* v8Iso, v8::Local<v8::Value> value, std::string& packed, s_progp_goStringOut* out) {
+    if (!progpMsgPackWrite(v8Iso, v8Iso->GetCurrentContext(), value, packed, 0)) return false;
+
+    out->p = (char*)packed.data();
+    out->n = (int)packed.size();
+    return true;
+}
+
+static bool progpMsgPackReadBE(const unsigned char* data, size_t size, size_t* offset, int count, uint64_t* out) {
+    if (*offset + count > size) return false;
+
+    uint64_t value = 0;
+    for (int i = 0; i < count; i++) value = (value << 8) | data[*offset + i];
+
+    *offset += count;
+    *out = value;
+    return true;
+}
+
+static v8::Local<v8::Value> progpMsgPackNumber(v8::Isolate* v8Iso, int64_t value) {
+    if ((value > 9007199254740991LL) || (value < -9007199254740991LL)) return v8::BigInt::New(v8Iso, value);
+    return v8::Number::New(v8Iso, (double)value);
+}
+
+static bool progpMsgPackRead(v8::Isolate* v8Iso, v8::Local<v8::Context> v8Ctx, const unsigned char* data, size_t size, size_t* offset, v8::Local<v8::Value>* out, int depth) {
+    if ((depth > 1000) || (*offset >= size)) return false;
+
+    unsigned char code = data[(*offset)++];
+    uint64_t length = 0;
+    uint64_t value = 0;
+    int kind = 0; // 1: string, 2: binary, 3: array, 4: map, 5: extension
+
+    if (code <= 0x7f) { *out = v8::Number::New(v8Iso, code); return true; }
+    if (code >= 0xe0) { *out = v8::Number::New(v8Iso, (int8_t)code); return true; }
+
+    if ((code & 0xf0) == 0x80) { kind = 4; length = code & 0x0f; }
+    else if ((code & 0xf0) == 0x90) { kind = 3; length = code & 0x0f; }
+    else if ((code & 0xe0) == 0xa0) { kind = 1; length = code & 0x1f; }
+    else switch (code) {
+        case 0xc0: *out = v8::Null(v8Iso); return true;
+        case 0xc2: *out = v8::False(v8Iso); return true;
+        case 0xc3: *out = v8::True(v8Iso); return true;
+        case 0xc4: case 0xc5: case 0xc6:
+            kind = 2; if (!progpMsgPackReadBE(data, size, offset, 1 << (code - 0xc4), &length)) return false; break;
+        case 0xc7: case 0xc8: case 0xc9:
+            kind = 5; if (!progpMsgPackReadBE(data, size, offset, 1 << (code - 0xc7), &length)) return false; break;
+        case 0xca: {
+            if (!progpMsgPackReadBE(data, size, offset, 4, &value)) return false;
+            uint32_t bits = (uint32_t)value; float f; memcpy(&f, &bits, 4);
+            *out = v8::Number::New(v8Iso, f); return true;
+        }
+        case 0xcb: {
+            if (!progpMsgPackReadBE(data, size, offset, 8, &value)) return false;
+            double d; memcpy(&d, &value, 8);
+            *out = v8::Number::New(v8Iso, d); return true;
+        }
+        case 0xcc: case 0xcd: case 0xce: case 0xcf:
+            if (!progpMsgPackReadBE(data, size, offset, 1 << (code - 0xcc), &value)) return false;
+            if (value > 9007199254740991ULL) *out = v8::BigInt::NewFromUnsigned(v8Iso, value);
+            else *out = v8::Number::New(v8Iso, (double)value);
+            return true;
+        case 0xd0: if (!progpMsgPackReadBE(data, size, offset, 1, &value)) return false; *out = v8::Number::New(v8Iso, (int8_t)value); return true;
+        case 0xd1: if (!progpMsgPackReadBE(data, size, offset, 2, &value)) return false; *out = v8::Number::New(v8Iso, (int16_t)value); return true;
+        case 0xd2: if (!progpMsgPackReadBE(data, size, offset, 4, &value)) return false; *out = v8::Number::New(v8Iso, (int32_t)value); return true;
+        case 0xd3: if (!progpMsgPackReadBE(data, size, offset, 8, &value)) return false; *out = progpMsgPackNumber(v8Iso, (int64_t)value); return true;
+        case 0xd4: case 0xd5: case 0xd6: case 0xd7: case 0xd8:
+            kind = 5; length = 1 << (code - 0xd4); break;
+        case 0xd9: case 0xda: case 0xdb:
+            kind = 1; if (!progpMsgPackReadBE(data, size, offset, 1 << (code - 0xd9), &length)) return false; break;
+        case 0xdc: case 0xdd:
+            kind = 3; if (!progpMsgPackReadBE(data, size, offset, 2 << (code - 0xdc), &length)) return false; break;
+        case 0xde: case 0xdf:
+            kind = 4; if (!progpMsgPackReadBE(data, size, offset, 2 << (code - 0xde), &length)) return false; break;
+        default: return false;
+    }
+
+    if (kind == 1) {
+        if (*offset + length > size) return false;
+        *out = v8::String::NewFromUtf8(v8Iso, (const char*)data + *offset, v8::NewStringType::kNormal, (int)length).ToLocalChecked();
+        *offset += length;
+        return true;
+    }
+
+    if (kind == 2) {
+        if (*offset + length > size) return false;
+        auto buffer = v8::ArrayBuffer::New(v8Iso, length);
+        if (length > 0) memcpy(buffer->GetBackingStore()->Data(), data + *offset, length);
+        *out = v8::Uint8Array::New(buffer, 0, length);
+        *offset += length;
+        return true;
+    }
+
+    if (kind == 5) {
+        if (*offset + 1 + length > size) return false;
+        int extType = (int8_t)data[(*offset)++];
+
+        // Are the results of a function having several ones, see progpAPI.MarshalMsgPackResults.
+        if ((extType == 1) || (extType == 2)) {
+            if ((length != 8) || !progpMsgPackReadBE(data, size, offset, 8, &value)) return false;
+            if (extType == 1) *out = v8::BigInt::New(v8Iso, (int64_t)value);
+            else *out = v8::BigInt::NewFromUnsigned(v8Iso, value);
+            return true;
+        }
+
+        if (extType == 3) {
+            auto buffer = v8::ArrayBuffer::New(v8Iso, length);
+            if (length > 0) memcpy(buffer->GetBackingStore()->Data(), data + *offset, length);
+            *out = buffer;
+            *offset += length;
+            return true;
+        }
+
+        // Otherwise, only the timestamps, which extension type is -1, are supported.
+        if (extType != -1) return false;
+
+        uint64_t sec = 0, nsec = 0;
+        if (length == 4) { progpMsgPackReadBE(data, size, offset, 4, &sec); }
+        else if (length == 8) { progpMsgPackReadBE(data, size, offset, 8, &value); nsec = value >> 34; sec = value & 0x3FFFFFFFFULL; }
+        else if (length == 12) { progpMsgPackReadBE(data, size, offset, 4, &nsec); progpMsgPackReadBE(data, size, offset, 8, &sec); }
+        else return false;
+
+        double ms = (double)(int64_t)sec * 1000 + (double)(nsec / 1000000);
+        return v8::Date::New(v8Ctx, ms).ToLocal(out);
+    }
+
+    if (kind == 3) {
+        // Each item takes at least one byte.
+        if (length > size - *offset) return false;
+
+        std::vector<v8::Local<v8::Value>> items(length);
+        for (uint64_t i = 0; i < length; i++) {
+            if (!progpMsgPackRead(v8Iso, v8Ctx, data, size, offset, &items[i], depth + 1)) return false;
+        }
+
+        *out = v8::Array::New(v8Iso, items.data(), items.size());
+        return true;
+    }
+
+    auto object = v8::Object::New(v8Iso);
+
+    for (uint64_t i = 0; i < length; i++) {
+        v8::Local<v8::Value> key, item;
+        if (!progpMsgPackRead(v8Iso, v8Ctx, data, size, offset, &key, depth + 1)) return false;
+        if (!progpMsgPackRead(v8Iso, v8Ctx, data, size, offset, &item, depth + 1)) return false;
+        if (object->Set(v8Ctx, key, item).IsNothing()) return false;
+    }
+
+    *out = object;
+    return true;
+}
+
+static bool progpMsgPackToV8(v8::Isolate* v8Iso, const char* data, size_t size, v8::Local<v8::Value>* out) {
+    size_t offset = 0;
+    if (!progpMsgPackRead(v8Iso, v8Iso->GetCurrentContext(), (const unsigned char*)data, size, &offset, out, 0)) return false;
+    return offset == size;
+}
+`
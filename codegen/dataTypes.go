@@ -16,7 +16,21 @@
 
 package codegen
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Used by the optional parameters of the types decoded by the V8CALLARG_EXPECT_* macros.
+const cppOptionalNumberDecoding = `        if (!%V8_VALUE%->IsNumber()) PROGP_THROW_TYPE_ERROR("argument %POSITION% must be a number");
+        %PARAM_NAME% = %V8_VALUE%.As<v8::Number>()->Value();`
+
+const cppOptionalStringDeclarations = `    s_progp_goStringOut %PARAM_NAME%{};
+    std::string %PARAM_NAME%_buffer;`
+
+const cppOptionalStringDecoding = `        if (!%V8_VALUE%->IsString()) PROGP_THROW_TYPE_ERROR("argument %POSITION% must be a string");
+        progpV8StringToGoString(v8Iso, %V8_VALUE%.As<v8::String>(), %PARAM_NAME%_buffer, &%PARAM_NAME%);`
 
 //region void
 
@@ -94,11 +108,170 @@ func (m *TypeBool) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string {
 	return "    if goRes {\n        res.value = C.int(1)\n    } else {\n        res.value = C.int(0)\n}"
 }
 
+func (m *TypeBool) OptionalV8ValueToCppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return optionalCppDecoder("    int %PARAM_NAME% = 0;", `        if (!%V8_VALUE%->IsBoolean()) PROGP_THROW_TYPE_ERROR("argument %POSITION% must be a boolean");
+        %PARAM_NAME% = %V8_VALUE%->IsTrue() ? 1 : 0;`, paramName, v8Value, isSet, paramPosition, ctx)
+}
+
 //endregion
 
-//region int
+//region int, int8 ... uint64
 
+// TypeInt handles all the Go integers. The value is checked in C++ before calling,
+// and a RangeError is thrown if it can't be stored without being truncated.
+//
+// int64 and uint64 are exchanged as BigInt when the function uses WithBigInt.
+// Otherwise, like int and uint, they are numbers limited to the integers
+// that a double can safely store (Number.MAX_SAFE_INTEGER).
 type TypeInt struct {
+	// goTypeName is the name of the Go type. The zero value is for "int".
+	goTypeName string
+
+	// bitSize is 8, 16, 32 or 64. It's 0 for int and uint,
+	// which are handled as 64 bits but never as BigInt.
+	bitSize int
+
+	isUnsigned bool
+}
+
+func newTypeInt(goTypeName string, bitSize int, isUnsigned bool) *TypeInt {
+	return &TypeInt{goTypeName: goTypeName, bitSize: bitSize, isUnsigned: isUnsigned}
+}
+
+// The biggest integer which can be stored in a double without losing precision.
+const jsMaxSafeInteger = 9007199254740991
+
+const cppHelperIntegers = `
+#include <cmath>
+#include <cstdint>
+
+// Decodes an integer sent as a number or as a BigInt.
+// Returns 0 if ok, 1 if it's not a number and 2 if it's out of range or not an integer.
+//
+static int progpV8ToInt64(v8::Local<v8::Value> value, int64_t min, int64_t max, int64_t* out) {
+    if (value->IsBigInt()) {
+        bool isLossless = true;
+        int64_t v = value.As<v8::BigInt>()->Int64Value(&isLossless);
+        if (!isLossless || (v < min) || (v > max)) return 2;
+        *out = v;
+        return 0;
+    }
+
+    if (!value->IsNumber()) return 1;
+    double d = value.As<v8::Number>()->Value();
+
+    // NaN fails all the comparisons. The last one is required
+    // since (double)INT64_MAX is rounded to 2^63.
+    if (!(d >= (double)min) || !(d <= (double)max) || (d >= 9223372036854775808.0)) return 2;
+    if (std::trunc(d) != d) return 2;
+
+    *out = (int64_t)d;
+    return 0;
+}
+
+static int progpV8ToUInt64(v8::Local<v8::Value> value, uint64_t max, uint64_t* out) {
+    if (value->IsBigInt()) {
+        bool isLossless = true;
+        uint64_t v = value.As<v8::BigInt>()->Uint64Value(&isLossless);
+        if (!isLossless || (v > max)) return 2;
+        *out = v;
+        return 0;
+    }
+
+    if (!value->IsNumber()) return 1;
+    double d = value.As<v8::Number>()->Value();
+
+    if (!(d >= 0) || !(d <= (double)max) || (d >= 18446744073709551616.0)) return 2;
+    if (std::trunc(d) != d) return 2;
+
+    *out = (uint64_t)d;
+    return 0;
+}
+`
+
+func (m *TypeInt) typeName() string {
+	if m.goTypeName == "" {
+		return "int"
+	}
+
+	return m.goTypeName
+}
+
+func (m *TypeInt) isBigInt(ctx *ProgpV8CodeGenerator) bool {
+	return (m.bitSize == 64) && (ctx.CurrentFunction != nil) && ctx.CurrentFunction.UseBigInt
+}
+
+// isSafeRangeLimited returns true if the type is bigger than
+// what a javascript number can safely store.
+func (m *TypeInt) isSafeRangeLimited() bool {
+	return (m.bitSize == 0) || (m.bitSize == 64)
+}
+
+// valueRange returns the min and max values accepted, as C++ literals.
+func (m *TypeInt) valueRange(ctx *ProgpV8CodeGenerator) (string, string) {
+	if m.isUnsigned {
+		if m.isBigInt(ctx) {
+			return "0", "UINT64_MAX"
+		} else if m.isSafeRangeLimited() {
+			return "0", strconv.FormatUint(jsMaxSafeInteger, 10) + "ULL"
+		}
+
+		return "0", strconv.FormatUint(1<<m.bitSize-1, 10) + "ULL"
+	}
+
+	if m.isBigInt(ctx) {
+		return "INT64_MIN", "INT64_MAX"
+	} else if m.isSafeRangeLimited() {
+		return "-" + strconv.FormatInt(jsMaxSafeInteger, 10) + "LL", strconv.FormatInt(jsMaxSafeInteger, 10) + "LL"
+	}
+
+	maxValue := int64(1)<<(m.bitSize-1) - 1
+	return strconv.FormatInt(-maxValue-1, 10) + "LL", strconv.FormatInt(maxValue, 10) + "LL"
+}
+
+func (m *TypeInt) V8ValueToCppDecoder(paramName string, v8Value string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return m.cppDecoder(paramName, v8Value, "", paramPosition, ctx)
+}
+
+func (m *TypeInt) OptionalV8ValueToCppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return m.cppDecoder(paramName, v8Value, isSet, paramPosition, ctx)
+}
+
+func (m *TypeInt) cppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	ctx.AddCppHelper("throwErrors", cppHelperThrowErrors)
+	ctx.AddCppHelper("integers", cppHelperIntegers)
+
+	minValue, maxValue := m.valueRange(ctx)
+	position := strconv.Itoa(paramPosition)
+
+	rangeText := "between " + strings.TrimRight(minValue, "UL") + " and " + strings.TrimRight(maxValue, "UL")
+	if m.isBigInt(ctx) {
+		rangeText = "in the " + m.typeName() + " range"
+	}
+
+	var template string
+
+	if m.isUnsigned {
+		template = `    uint64_t %PARAM_NAME%%INIT%;
+    switch (%IF_SET%progpV8ToUInt64(%V8_VALUE%, %MAX%, &%PARAM_NAME%)) {`
+	} else {
+		template = `    int64_t %PARAM_NAME%%INIT%;
+    switch (%IF_SET%progpV8ToInt64(%V8_VALUE%, %MIN%, %MAX%, &%PARAM_NAME%)) {`
+	}
+
+	template += `
+        case 1: PROGP_THROW_TYPE_ERROR("argument %POSITION% must be an integer");
+        case 2: PROGP_THROW_RANGE_ERROR("argument %POSITION% must be an integer %RANGE%");
+    }`
+
+	template = strings.ReplaceAll(template, "%PARAM_NAME%", paramName)
+	template = strings.ReplaceAll(template, "%V8_VALUE%", v8Value)
+	template = strings.ReplaceAll(template, "%MIN%", minValue)
+	template = strings.ReplaceAll(template, "%MAX%", maxValue)
+	template = strings.ReplaceAll(template, "%POSITION%", position)
+	template = strings.ReplaceAll(template, "%RANGE%", rangeText)
+
+	return optionalSwitchReplacer(template, isSet)
 }
 
 func (m *TypeInt) CppToCgoParamCall(paramName string, ctx *ProgpV8CodeGenerator) string {
@@ -106,19 +279,38 @@ func (m *TypeInt) CppToCgoParamCall(paramName string, ctx *ProgpV8CodeGenerator)
 }
 
 func (m *TypeInt) V8ToCppDecoder(ctx *ProgpV8CodeGenerator) string {
-	return "V8CALLARG_EXPECT_DOUBLE"
+	// Is done by V8ValueToCppDecoder.
+	return ""
 }
 
 func (m *TypeInt) ReturnTypeWrapper(ctx *ProgpV8CodeGenerator) string {
-	return "ProgpFunctionReturnLong"
+	if m.isBigInt(ctx) {
+		// The value is sent through a pointer, since a long
+		// isn't always 64 bits and a double is too small.
+		return "ProgpFunctionReturnArrayBuffer"
+	}
+
+	return "ProgpFunctionReturnDouble"
 }
 
 func (m *TypeInt) ReturnTypeEncoder(ctx *ProgpV8CodeGenerator) string {
+	if m.isBigInt(ctx) {
+		if m.isUnsigned {
+			return "callInfo.GetReturnValue().Set(v8::BigInt::NewFromUnsigned(v8Iso, *(uint64_t*)res));"
+		}
+
+		return "callInfo.GetReturnValue().Set(v8::BigInt::New(v8Iso, *(int64_t*)res));"
+	}
+
 	return "callInfo.GetReturnValue().Set(V8VALUE_FROM_DOUBLE(res));"
 }
 
 func (m *TypeInt) CgoFunctionParamType(ctx *ProgpV8CodeGenerator) string {
-	return "C.double"
+	if m.isUnsigned {
+		return "C.ulonglong"
+	}
+
+	return "C.longlong"
 }
 
 func (m *TypeInt) CppArgResourcesFreeing(paramName string, ctx *ProgpV8CodeGenerator) string {
@@ -126,11 +318,39 @@ func (m *TypeInt) CppArgResourcesFreeing(paramName string, ctx *ProgpV8CodeGener
 }
 
 func (m *TypeInt) CgoToGoDecoding(paramName string, ctx *ProgpV8CodeGenerator) (string, string) {
-	return "", "int(" + paramName + ")"
+	return "", m.typeName() + "(" + paramName + ")"
 }
 
 func (m *TypeInt) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string {
-	return "    res.value = C.long(goRes)"
+	if m.isBigInt(ctx) {
+		ctx.AddNamespace("unsafe")
+		return "    res.value = unsafe.Pointer(&goRes)"
+	}
+
+	if !m.isSafeRangeLimited() {
+		return "    res.value = C.double(goRes)"
+	}
+
+	ctx.AddNamespace("strconv")
+
+	var template string
+
+	if m.isUnsigned {
+		template = `    if uint64(goRes) > %MAX% {
+		res.errorMessage = C.CString("the returned value " + strconv.FormatUint(uint64(goRes), 10) + " can't be safely converted to a javascript number")
+		return
+	}
+`
+	} else {
+		template = `    if (int64(goRes) > %MAX%) || (int64(goRes) < -%MAX%) {
+		res.errorMessage = C.CString("the returned value " + strconv.FormatInt(int64(goRes), 10) + " can't be safely converted to a javascript number")
+		return
+	}
+`
+	}
+
+	template += "\n    res.value = C.double(goRes)"
+	return strings.ReplaceAll(template, "%MAX%", strconv.FormatInt(jsMaxSafeInteger, 10))
 }
 
 //endregion
@@ -172,6 +392,10 @@ func (m *TypeFloat32) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string {
 	return "    res.value = C.double(goRes)"
 }
 
+func (m *TypeFloat32) OptionalV8ValueToCppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return optionalCppDecoder("    double %PARAM_NAME% = 0;", cppOptionalNumberDecoding, paramName, v8Value, isSet, paramPosition, ctx)
+}
+
 //endregion
 
 //region float64
@@ -211,6 +435,10 @@ func (m *TypeFloat64) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string {
 	return "    res.value = C.double(goRes)"
 }
 
+func (m *TypeFloat64) OptionalV8ValueToCppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return optionalCppDecoder("    double %PARAM_NAME% = 0;", cppOptionalNumberDecoding, paramName, v8Value, isSet, paramPosition, ctx)
+}
+
 //endregion
 
 //region string
@@ -251,6 +479,10 @@ func (m *TypeString) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string {
 	return "    res.value = unsafe.Pointer(&goRes)"
 }
 
+func (m *TypeString) OptionalV8ValueToCppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return optionalCppDecoder(cppOptionalStringDeclarations, cppOptionalStringDecoding, paramName, v8Value, isSet, paramPosition, ctx)
+}
+
 //endregion
 
 //region progpAPI.StringBuffer
@@ -292,6 +524,10 @@ func (m *TypeStringBuffer) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string {
 	return "    resString:= unsafe.String(unsafe.SliceData(goRes), len(goRes))\n    res.value = unsafe.Pointer(&resString)"
 }
 
+func (m *TypeStringBuffer) OptionalV8ValueToCppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return optionalCppDecoder(cppOptionalStringDeclarations, cppOptionalStringDecoding, paramName, v8Value, isSet, paramPosition, ctx)
+}
+
 //endregion
 
 //region []uint / ArrayBuffer
@@ -451,6 +687,10 @@ func (m *TypeSharedResource) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string
 	return "    res.value = C.long(goRes.GetId())"
 }
 
+func (m *TypeSharedResource) OptionalV8ValueToCppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return optionalCppDecoder("    double %PARAM_NAME% = 0;", cppOptionalNumberDecoding, paramName, v8Value, isSet, paramPosition, ctx)
+}
+
 //endregion
 
 //region *progpAPI.TypeSharedResourceContainer
@@ -492,6 +732,82 @@ func (m *TypeSharedResourceContainer) GoValueToCgoValue(ctx *ProgpV8CodeGenerato
 
 //endregion
 
+//region context.Context
+
+// TypeGoContext is a context.Context given by the generated code, without consuming a javascript argument.
+// See progpAPI.NewCallContext for when it's cancelled.
+type TypeGoContext struct {
+}
+
+func (m *TypeGoContext) CppToCgoParamCall(paramName string, ctx *ProgpV8CodeGenerator) string {
+	return ""
+}
+
+func (m *TypeGoContext) CppArgResourcesFreeing(paramName string, ctx *ProgpV8CodeGenerator) string {
+	return ""
+}
+
+func (m *TypeGoContext) V8ToCppDecoder(ctx *ProgpV8CodeGenerator) string {
+	return ""
+}
+
+func (m *TypeGoContext) ReturnTypeWrapper(ctx *ProgpV8CodeGenerator) string {
+	return ""
+}
+
+func (m *TypeGoContext) ReturnTypeEncoder(ctx *ProgpV8CodeGenerator) string {
+	return ""
+}
+
+func (m *TypeGoContext) CgoFunctionParamType(ctx *ProgpV8CodeGenerator) string {
+	return ""
+}
+
+func (m *TypeGoContext) CgoToGoDecoding(paramName string, ctx *ProgpV8CodeGenerator) (string, string) {
+	ctx.AddNamespace("github.com/progpjs/progpAPI/v2")
+
+	fct := ctx.CurrentFunction
+	timeout := "0"
+
+	if fct.CallTimeout != 0 {
+		timeout = strconv.FormatInt(int64(fct.CallTimeout), 10)
+	}
+
+	res := "    " + paramName + "_ctx, " + paramName + "_cancel := progpAPI.NewCallContext(getSharedResourceContainerFromUIntPtr(res.currentEvent.id).GetScriptContext(), " + timeout + ")"
+
+	if fct.UseAbortSignal {
+		res += "\n    " + paramName + "_ctx = abortableCall.WrapContext(" + paramName + "_ctx)"
+	}
+
+	if !fct.IsAsync && !fct.IsPromise {
+		res += "\n    defer " + paramName + "_cancel()"
+	} else {
+		// For the async functions, it's called once the call settles. But the callback isn't called
+		// if the binding fails, for example if a parameter can't be decoded or an interceptor rejects the call.
+		template := `
+	defer func() {
+		if err := recover(); err != nil {
+			%PARAM%_cancel()
+			panic(err)
+		}
+
+		if res.errorMessage != nil {
+			%PARAM%_cancel()
+		}
+	}()`
+
+		res += strings.ReplaceAll(template, "%PARAM%", paramName)
+	}
+
+	return res, paramName + "_ctx"
+}
+
+func (m *TypeGoContext) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string {
+	return ""
+}
+
+//endregion
+
 //region >>> For function caller
 
 //region string
@@ -558,6 +874,54 @@ func (m *TypeFloat64) FcGoToCppConvCache(_ int) string {
 
 //endregion
 
+//region int, int8 ... uint64
+
+// For a function caller there is no way to choose the encoding, so int64 and uint64
+// are always sent as BigInt, while the others are numbers. For int and uint the
+// value isn't checked, as with a float64, and can lose precision beyond 2^53.
+
+func (m *TypeInt) FcCppToV8Encoder(paramId int) string {
+	if m.bitSize == 64 {
+		if m.isUnsigned {
+			return fmt.Sprintf("    argArray[%d] = v8::BigInt::NewFromUnsigned(v8Iso, (uint64_t)p%d);\n", paramId, paramId)
+		}
+
+		return fmt.Sprintf("    argArray[%d] = v8::BigInt::New(v8Iso, (int64_t)p%d);\n", paramId, paramId)
+	}
+
+	return fmt.Sprintf("    argArray[%d] = DOUBLE_TO_V8VALUE(p%d);\n", paramId, paramId)
+}
+
+func (m *TypeInt) FcCppFunctionHeader(paramId int) string {
+	if m.bitSize == 64 {
+		if m.isUnsigned {
+			return fmt.Sprintf(", unsigned long long p%d", paramId)
+		}
+
+		return fmt.Sprintf(", long long p%d", paramId)
+	}
+
+	return fmt.Sprintf(", double p%d", paramId)
+}
+
+func (m *TypeInt) FcGoToCppCallParam(paramId int) string {
+	if m.bitSize == 64 {
+		if m.isUnsigned {
+			return fmt.Sprintf("\n                (C.ulonglong)(p%d),", paramId)
+		}
+
+		return fmt.Sprintf("\n                (C.longlong)(p%d),", paramId)
+	}
+
+	return fmt.Sprintf("\n                (C.double)(p%d),", paramId)
+}
+
+func (m *TypeInt) FcGoToCppConvCache(_ int) string {
+	return ""
+}
+
+//endregion
+
 //region []uint / ArrayBuffer
 
 func (m *TypeUIntArray) FcCppToV8Encoder(paramId int) string {
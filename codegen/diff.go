@@ -0,0 +1,210 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codegen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Number of unchanged lines printed around each change.
+const diffContextLines = 3
+
+// Above this size (old lines x new lines) the changed block isn't
+// compared line by line, and is simply replaced as a whole.
+const diffMaxCompareCells = 4_000_000
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+// unifiedDiff returns the differences between two texts with the unified format.
+// Returns an empty string if the texts are the same.
+func unifiedDiff(filePath string, oldText string, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+
+	ops := diffLines(splitLines(oldText), splitLines(newText))
+
+	var res strings.Builder
+	filePath = strings.TrimPrefix(filePath, "/")
+	res.WriteString("--- a/" + filePath + "\n+++ b/" + filePath + "\n")
+
+	// Offset of the lines before each op.
+	oldOffsets := make([]int, len(ops)+1)
+	newOffsets := make([]int, len(ops)+1)
+
+	for i, op := range ops {
+		oldOffsets[i+1] = oldOffsets[i]
+		newOffsets[i+1] = newOffsets[i]
+
+		if op.kind != '+' {
+			oldOffsets[i+1]++
+		}
+
+		if op.kind != '-' {
+			newOffsets[i+1]++
+		}
+	}
+
+	i := 0
+
+	for i < len(ops) {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// Extend the hunk while the next change is near enough.
+		hunkEnd := i
+
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				hunkEnd = j
+			} else if j-hunkEnd > 2*diffContextLines {
+				break
+			}
+		}
+
+		from := max(0, i-diffContextLines)
+		to := min(len(ops), hunkEnd+diffContextLines+1)
+
+		oldCount := oldOffsets[to] - oldOffsets[from]
+		newCount := newOffsets[to] - newOffsets[from]
+
+		res.WriteString(fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(oldOffsets[from], oldCount), hunkRange(newOffsets[from], newCount)))
+
+		for _, op := range ops[from:to] {
+			res.WriteByte(op.kind)
+			res.WriteString(op.text)
+			res.WriteByte('\n')
+		}
+
+		i = to
+	}
+
+	return res.String()
+}
+
+func hunkRange(offset int, count int) string {
+	if count == 0 {
+		// The convention is to give the line before.
+		return fmt.Sprintf("%d,0", offset)
+	}
+
+	return fmt.Sprintf("%d,%d", offset+1, count)
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+func diffLines(oldLines []string, newLines []string) []diffOp {
+	// Generated files mostly change locally,
+	// so common prefix and suffix are removed first.
+
+	prefix := 0
+	for (prefix < len(oldLines)) && (prefix < len(newLines)) && (oldLines[prefix] == newLines[prefix]) {
+		prefix++
+	}
+
+	suffix := 0
+	for (suffix < len(oldLines)-prefix) && (suffix < len(newLines)-prefix) &&
+		(oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix]) {
+		suffix++
+	}
+
+	var res []diffOp
+
+	for _, line := range oldLines[:prefix] {
+		res = append(res, diffOp{kind: ' ', text: line})
+	}
+
+	oldMiddle := oldLines[prefix : len(oldLines)-suffix]
+	newMiddle := newLines[prefix : len(newLines)-suffix]
+
+	if len(oldMiddle)*len(newMiddle) <= diffMaxCompareCells {
+		res = append(res, diffLcs(oldMiddle, newMiddle)...)
+	} else {
+		for _, line := range oldMiddle {
+			res = append(res, diffOp{kind: '-', text: line})
+		}
+
+		for _, line := range newMiddle {
+			res = append(res, diffOp{kind: '+', text: line})
+		}
+	}
+
+	for _, line := range oldLines[len(oldLines)-suffix:] {
+		res = append(res, diffOp{kind: ' ', text: line})
+	}
+
+	return res
+}
+
+// diffLcs compares the lines using the longest common subsequence.
+func diffLcs(oldLines []string, newLines []string) []diffOp {
+	n := len(oldLines)
+	m := len(newLines)
+	width := m + 1
+
+	// lcs[i*width+j] is the LCS length of oldLines[i:] and newLines[j:].
+	lcs := make([]int32, (n+1)*width)
+
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i*width+j] = lcs[(i+1)*width+j+1] + 1
+			} else {
+				lcs[i*width+j] = max(lcs[(i+1)*width+j], lcs[i*width+j+1])
+			}
+		}
+	}
+
+	var res []diffOp
+	i, j := 0, 0
+
+	for (i < n) && (j < m) {
+		if oldLines[i] == newLines[j] {
+			res = append(res, diffOp{kind: ' ', text: oldLines[i]})
+			i++
+			j++
+		} else if lcs[(i+1)*width+j] >= lcs[i*width+j+1] {
+			res = append(res, diffOp{kind: '-', text: oldLines[i]})
+			i++
+		} else {
+			res = append(res, diffOp{kind: '+', text: newLines[j]})
+			j++
+		}
+	}
+
+	for ; i < n; i++ {
+		res = append(res, diffOp{kind: '-', text: oldLines[i]})
+	}
+
+	for ; j < m; j++ {
+		res = append(res, diffOp{kind: '+', text: newLines[j]})
+	}
+
+	return res
+}
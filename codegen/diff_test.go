@@ -0,0 +1,281 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codegen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns the lines "la", "lb", ... from the from-th to the to-th letter.
+func numberedLines(from int, to int) string {
+	var res strings.Builder
+
+	for i := from; i <= to; i++ {
+		res.WriteString("l" + string(rune('a'+i-1)) + "\n")
+	}
+
+	return res.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		filePath string
+		oldText  string
+		newText  string
+		expected string
+	}{
+		{
+			name:     "unchanged",
+			filePath: "file.txt",
+			oldText:  "a\nb\n",
+			newText:  "a\nb\n",
+			expected: "",
+		},
+		{
+			name:     "added file",
+			filePath: "file.txt",
+			oldText:  "",
+			newText:  "a\nb\n",
+			expected: "--- a/file.txt\n+++ b/file.txt\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:     "removed file",
+			filePath: "file.txt",
+			oldText:  "a\nb\n",
+			newText:  "",
+			expected: "--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name:     "absolute path",
+			filePath: "/dir/file.txt",
+			oldText:  "a\n",
+			newText:  "b\n",
+			expected: "--- a/dir/file.txt\n+++ b/dir/file.txt\n@@ -1,1 +1,1 @@\n-a\n+b\n",
+		},
+		{
+			name:     "changed line",
+			filePath: "file.txt",
+			oldText:  "a\nb\nc\nd\ne\n",
+			newText:  "a\nb\nX\nd\ne\n",
+			expected: "--- a/file.txt\n+++ b/file.txt\n@@ -1,5 +1,5 @@\n a\n b\n-c\n+X\n d\n e\n",
+		},
+		{
+			name:     "added line",
+			filePath: "file.txt",
+			oldText:  "a\nb\n",
+			newText:  "a\nX\nb\n",
+			expected: "--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,3 @@\n a\n+X\n b\n",
+		},
+		{
+			name:     "removed line",
+			filePath: "file.txt",
+			oldText:  "a\nX\nb\n",
+			newText:  "a\nb\n",
+			expected: "--- a/file.txt\n+++ b/file.txt\n@@ -1,3 +1,2 @@\n a\n-X\n b\n",
+		},
+		{
+			name:     "context is limited",
+			filePath: "file.txt",
+			oldText:  numberedLines(1, 9),
+			newText:  numberedLines(1, 4) + "X\n" + numberedLines(6, 9),
+			expected: "--- a/file.txt\n+++ b/file.txt\n@@ -2,7 +2,7 @@\n lb\n lc\n ld\n-le\n+X\n lf\n lg\n lh\n",
+		},
+		{
+			name:     "near changes share a hunk",
+			filePath: "file.txt",
+			oldText:  numberedLines(1, 8),
+			newText:  "X\n" + numberedLines(2, 7) + "Y\n",
+			expected: "--- a/file.txt\n+++ b/file.txt\n@@ -1,8 +1,8 @@\n-la\n+X\n lb\n lc\n ld\n le\n lf\n lg\n-lh\n+Y\n",
+		},
+		{
+			name:     "distant changes have their hunk",
+			filePath: "file.txt",
+			oldText:  numberedLines(1, 12),
+			newText:  "X\n" + numberedLines(2, 11) + "Y\n",
+			expected: "--- a/file.txt\n+++ b/file.txt\n@@ -1,4 +1,4 @@\n-la\n+X\n lb\n lc\n ld\n@@ -9,4 +9,4 @@\n li\n lj\n lk\n-ll\n+Y\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diff := unifiedDiff(test.filePath, test.oldText, test.newText)
+
+			if diff != test.expected {
+				t.Fatalf("unexpected diff:\n%s\nexpected:\n%s", diff, test.expected)
+			}
+		})
+	}
+}
+
+func TestGenerationResultSaveFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		oldContent  *string
+		newContent  string
+		isDryRun    bool
+		isChanged   bool
+		expected    string
+		expectedOld *string
+	}{
+		{
+			name:        "added file",
+			newContent:  "a\n",
+			isChanged:   true,
+			expected:    "a\n",
+			expectedOld: ptr(""),
+		},
+		{
+			name:       "added file in dry-run",
+			newContent: "a\n",
+			isDryRun:   true,
+			isChanged:  true,
+		},
+		{
+			name:        "changed file",
+			oldContent:  ptr("a\n"),
+			newContent:  "b\n",
+			isChanged:   true,
+			expected:    "b\n",
+			expectedOld: ptr("a\n"),
+		},
+		{
+			name:       "changed file in dry-run",
+			oldContent: ptr("a\n"),
+			newContent: "b\n",
+			isDryRun:   true,
+			isChanged:  true,
+			expected:   "a\n",
+		},
+		{
+			name:        "emptied file",
+			oldContent:  ptr("a\n"),
+			newContent:  "",
+			isChanged:   true,
+			expected:    "",
+			expectedOld: ptr("a\n"),
+		},
+		{
+			name:       "unchanged file",
+			oldContent: ptr("a\n"),
+			newContent: "a\n",
+			expected:   "a\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "dir", "file.txt")
+
+			if test.oldContent != nil {
+				if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.WriteFile(filePath, []byte(*test.oldContent), os.ModePerm); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result := newGenerationResult(test.isDryRun)
+
+			if isChanged := result.saveFile(filePath, test.newContent, true); isChanged != test.isChanged {
+				t.Fatalf("saveFile returned %t instead of %t", isChanged, test.isChanged)
+			}
+
+			if result.HasErrors() {
+				t.Fatalf("unexpected errors: %v", result.Err())
+			}
+
+			if result.HasChanges() != test.isChanged {
+				t.Fatalf("HasChanges returned %t instead of %t", result.HasChanges(), test.isChanged)
+			}
+
+			oldContent := ""
+
+			if test.oldContent != nil {
+				oldContent = *test.oldContent
+			}
+
+			if test.isChanged {
+				if (len(result.ChangedFiles) != 1) || (result.ChangedFiles[0] != filePath) {
+					t.Fatalf("unexpected changed files %v", result.ChangedFiles)
+				}
+
+				if diff := unifiedDiff(filePath, oldContent, test.newContent); result.Diffs[filePath] != diff {
+					t.Fatalf("unexpected diff:\n%s", result.Diffs[filePath])
+				}
+			} else if (len(result.ChangedFiles) != 0) || (len(result.Diffs) != 0) {
+				t.Fatalf("an unchanged file is recorded: %v", result.ChangedFiles)
+			}
+
+			content, err := os.ReadFile(filePath)
+
+			if test.isDryRun && (test.oldContent == nil) {
+				if !os.IsNotExist(err) {
+					t.Fatal("the file is written in dry-run")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if string(content) != test.expected {
+				t.Fatalf("the file contains %q instead of %q", content, test.expected)
+			}
+
+			old, err := os.ReadFile(filePath + ".old")
+
+			if test.expectedOld == nil {
+				if !os.IsNotExist(err) {
+					t.Fatal("the old version is kept while the file isn't written")
+				}
+			} else if (err != nil) || (string(old) != *test.expectedOld) {
+				t.Fatalf("the old version contains %q instead of %q", old, *test.expectedOld)
+			}
+		})
+	}
+}
+
+func TestGenerationResultMerge(t *testing.T) {
+	result := newGenerationResult(true)
+	result.ChangedFiles = []string{"a.txt"}
+	result.Diffs["a.txt"] = "diff a"
+
+	other := newGenerationResult(true)
+	other.ChangedFiles = []string{"b.txt"}
+	other.Diffs["b.txt"] = "diff b"
+	other.Errors = append(other.Errors, os.ErrNotExist)
+
+	result.Merge(other)
+
+	if strings.Join(result.ChangedFiles, ",") != "a.txt,b.txt" {
+		t.Fatalf("unexpected changed files %v", result.ChangedFiles)
+	}
+
+	if (result.Diffs["a.txt"] != "diff a") || (result.Diffs["b.txt"] != "diff b") {
+		t.Fatalf("unexpected diffs %v", result.Diffs)
+	}
+
+	if !result.HasErrors() || (result.Err() == nil) {
+		t.Fatal("the errors aren't merged")
+	}
+}
+
+func ptr(value string) *string {
+	return &value
+}
@@ -1,7 +1,9 @@
 package codegen
 
 import (
+	"fmt"
 	"github.com/progpjs/progpAPI/v2"
+	"hash/fnv"
 	"reflect"
 	"strings"
 )
@@ -30,7 +32,7 @@ func AddFunctionCallerToGenerate(reflectFct reflect.Type) {
 
 	// >>> Add to the function which need to be created
 
-	if (res.ReturnErrorOffset != -1) || (res.ReturnType != "") {
+	if (res.ReturnErrorOffset != -1) || (res.ReturnType != "") || (len(res.ResultTypes) != 0) {
 		panic("Returning a value isn't supported")
 	}
 
@@ -43,14 +45,24 @@ func AddFunctionCallerToGenerate(reflectFct reflect.Type) {
 		panic("The first parameter must be of type progpAPI.JsFunction")
 	}
 
+	progpAPI.GetFunctionRegistry().AddFunctionCallerSignature(signature)
+
 	gFunctionCallerToBuildMap[signature] = &functionCallerToBuild{
 		paramTypes: res.ParamTypes,
 		returnType: res.ReturnType,
+		namespaces: res.CallParamNamespaces,
 	}
 
 	gHasFunctionCallerToBuild = true
 }
 
+// getFunctionCallerId returns the id used to build the symbols of a function caller.
+func getFunctionCallerId(signature string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(signature))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
 func getAllFunctionCallerToBuild() map[string]*functionCallerToBuild {
 	if gHasFunctionCallerToBuild {
 		return gFunctionCallerToBuildMap
@@ -62,6 +74,7 @@ func getAllFunctionCallerToBuild() map[string]*functionCallerToBuild {
 type functionCallerToBuild struct {
 	paramTypes []string
 	returnType string
+	namespaces []string
 }
 
 var gHasFunctionCallerToBuild = false
@@ -0,0 +1,129 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codegen
+
+import (
+	"errors"
+	"os"
+	"path"
+)
+
+// GenerationResult is what the code generators return.
+// It lets the caller decide what to do when files are stale,
+// for example restarting the application or failing a CI job.
+type GenerationResult struct {
+	// IsDryRun is true if the files haven't been written.
+	IsDryRun bool
+
+	// ChangedFiles is the list of the files updated, or which would
+	// be updated when IsDryRun is true. Unchanged files aren't listed.
+	ChangedFiles []string
+
+	// Diffs contains the unified diff of each changed file.
+	Diffs map[string]string
+
+	Errors []error
+}
+
+func newGenerationResult(isDryRun bool) *GenerationResult {
+	return &GenerationResult{IsDryRun: isDryRun, Diffs: make(map[string]string)}
+}
+
+func (m *GenerationResult) HasChanges() bool {
+	return len(m.ChangedFiles) != 0
+}
+
+func (m *GenerationResult) HasErrors() bool {
+	return len(m.Errors) != 0
+}
+
+// Err returns all the errors as one error, or nil if no error.
+func (m *GenerationResult) Err() error {
+	return errors.Join(m.Errors...)
+}
+
+// Merge adds the content of another result to this one.
+func (m *GenerationResult) Merge(other *GenerationResult) {
+	m.ChangedFiles = append(m.ChangedFiles, other.ChangedFiles...)
+	m.Errors = append(m.Errors, other.Errors...)
+
+	for filePath, diff := range other.Diffs {
+		m.Diffs[filePath] = diff
+	}
+}
+
+// PrintReport prints the errors and the changed files.
+// If the bindings have been updated, then it also prints that
+// a restart is required, which is the caller responsibility.
+func (m *GenerationResult) PrintReport() {
+	for _, err := range m.Errors {
+		println("Codegen error: " + err.Error())
+	}
+
+	for _, filePath := range m.ChangedFiles {
+		if m.IsDryRun {
+			println("Codegen would update file " + filePath)
+		} else {
+			println("Codegen has updated file " + filePath)
+		}
+	}
+
+	if m.HasChanges() && !m.IsDryRun {
+		println("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
+		println("!  Javascript binding code has been updated.  !")
+		println("!  A restart is required.                     !")
+		println("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
+	}
+}
+
+// saveFile writes the file if his content has changed and records the change.
+// In dry-run mode, only the change is recorded.
+func (m *GenerationResult) saveFile(filePath string, newContent string, keepOldVersion bool) bool {
+	oldContentB, err := os.ReadFile(filePath)
+	oldContent := string(oldContentB)
+
+	if (err == nil) && (oldContent == newContent) {
+		return false
+	}
+
+	m.ChangedFiles = append(m.ChangedFiles, filePath)
+	m.Diffs[filePath] = unifiedDiff(filePath, oldContent, newContent)
+
+	if m.IsDryRun {
+		return true
+	}
+
+	dirPath := path.Dir(filePath)
+
+	err = os.MkdirAll(dirPath, os.ModePerm)
+	if err != nil {
+		m.Errors = append(m.Errors, errors.New("can't create directory "+dirPath+": "+err.Error()))
+		return true
+	}
+
+	err = os.WriteFile(filePath, []byte(newContent), os.ModePerm)
+	if err != nil {
+		m.Errors = append(m.Errors, errors.New("can't write file "+filePath+": "+err.Error()))
+		return true
+	}
+
+	if keepOldVersion {
+		_ = os.WriteFile(filePath+".old", []byte(oldContent), os.ModePerm)
+	}
+
+	return true
+}
@@ -0,0 +1,181 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codegen
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/progpjs/progpAPI/v2"
+)
+
+// The interceptors installed with FunctionRegistry.Use are called by the
+// generated Go bindings before calling the Go function. When none is installed,
+// the cost is only a check of progpAPI.HasCallInterceptors.
+
+// hoistGoCallParams evaluates the arguments of the Go function into variables,
+// which allows giving them to the interceptors without evaluating them twice.
+// Returns the code declaring the variables, their names, and the call parameters.
+func hoistGoCallParams(goCallParams []string) (string, []string, []string) {
+	code := ""
+	var argNames []string
+	var callParams []string
+
+	for i, goCallParam := range goCallParams {
+		argName := "arg" + strconv.Itoa(i)
+		argNames = append(argNames, argName)
+
+		if value, isVariadic := strings.CutSuffix(goCallParam, "..."); isVariadic {
+			code += "\n\t" + argName + " := " + value
+			callParams = append(callParams, argName+"...")
+		} else {
+			code += "\n\t" + argName + " := " + goCallParam
+			callParams = append(callParams, argName)
+		}
+	}
+
+	return code, argNames, callParams
+}
+
+// goInterceptCall returns the call of progpAPI.InterceptCall for this function.
+func goInterceptCall(fct *progpAPI.RegisteredFunction, argNames []string) string {
+	args := ""
+
+	for _, argName := range argNames {
+		args += ", " + argName
+	}
+
+	return "progpAPI.InterceptCall(" + strconv.Quote(fct.Group) + ", " + strconv.Quote(fct.JsFunctionName) +
+		", getSharedResourceContainerFromUIntPtr(res.currentEvent.id).GetScriptContext()" + args + ")"
+}
+
+// goInterceptorRejectCode returns the code returning the error of the interceptor rejecting a call.
+func goInterceptorRejectCode(fct *progpAPI.RegisteredFunction) string {
+	res := ""
+
+	if fct.UseAbortSignal {
+		// The signal won't be watched.
+		res += "\n\t\t\tabortableCall.Release()"
+	}
+
+	return res + `
+			res.errorMessage = C.CString(err.Error())
+			return`
+}
+
+// goInterceptorsCode returns the code calling the interceptors before a synchronous call.
+func goInterceptorsCode(fct *progpAPI.RegisteredFunction, argNames []string) string {
+	return `
+
+	var interceptedCall *progpAPI.FunctionCall
+
+	if progpAPI.HasCallInterceptors() {
+		var err error
+
+		if interceptedCall, err = ` + goInterceptCall(fct, argNames) + `; err != nil {` + goInterceptorRejectCode(fct) + `
+		}
+
+		defer interceptedCall.Done()
+	}
+`
+}
+
+// goAsyncInterceptorsCode returns the code calling the interceptors before calling an async
+// function. When an interceptor delays the call, the function is called from a goroutine
+// once the delay is elapsed, which doesn't block javascript. goCall is the call of the function.
+// The call is done once the callback settles, settlingCallback being the *progpAPI.SettlingJsFunction.
+func goAsyncInterceptorsCode(fct *progpAPI.RegisteredFunction, argNames []string, goCall string, settlingCallback string) string {
+	done := "interceptedCall.Done()"
+
+	if settlingCallback != "" {
+		done = "interceptedCall.DoneWhenSettled(" + settlingCallback + ")"
+	}
+
+	if !fct.CanBeDelayed() {
+		return strings.ReplaceAll(goInterceptorsCode(fct, argNames), "interceptedCall.Done()", done)
+	}
+
+	callback := argNames[len(argNames)-1]
+
+	if fct.GoFunctionInfos.ReturnErrorOffset != -1 {
+		goCall = `if err := ` + goCall + `; err != nil {
+					interceptedCall.SetError(err)
+					` + callback + `.CallWithError(err)
+				}`
+	}
+
+	return `
+
+	var interceptedCall *progpAPI.FunctionCall
+
+	if progpAPI.HasCallInterceptors() {
+		var err error
+
+		if interceptedCall, err = ` + goInterceptCall(fct, argNames) + `; err != nil {` + goInterceptorRejectCode(fct) + `
+		}
+
+		if interceptedCall.Delay > 0 {
+			progpAPI.SafeGoRoutine(func() {
+				defer ` + done + `
+
+				if err := interceptedCall.WaitDelay(); err != nil {
+					interceptedCall.SetError(err)
+					` + callback + `.CallWithError(err)
+					return
+				}
+
+				` + goCall + `
+			})
+
+			return
+		}
+
+		defer ` + done + `
+	}
+`
+}
+
+// goPromiseInterceptorsCode returns the code calling the interceptors before calling
+// a function returning a promise. The interceptors are called from the javascript
+// thread, while the error rejects the promise and the delay is waited from the goroutine.
+func goPromiseInterceptorsCode(fct *progpAPI.RegisteredFunction, argNames []string) (string, string) {
+	before := `
+
+	var interceptedCall *progpAPI.FunctionCall
+	var interceptedErr error
+
+	if progpAPI.HasCallInterceptors() {
+		interceptedCall, interceptedErr = ` + goInterceptCall(fct, argNames) + `
+	}`
+
+	inGoroutine := `
+		if interceptedErr != nil {
+			callback.CallWithError(interceptedErr)
+			return
+		} else if interceptedCall != nil {
+			defer interceptedCall.Done()
+
+			if err := interceptedCall.WaitDelay(); err != nil {
+				interceptedCall.SetError(err)
+				callback.CallWithError(err)
+				return
+			}
+		}
+`
+
+	return before, inGoroutine
+}
@@ -0,0 +1,308 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codegen
+
+import (
+	"errors"
+	"github.com/progpjs/progpAPI/v2"
+	"strconv"
+	"strings"
+)
+
+// The functions registered with progpAPI.FunctionModule.AddPromiseFunction are exposed
+// through two C++ functions. The first one creates the promise and calls the second one,
+// which is the binding of the Go function, with a callback settling the promise.
+// It's the same callback mechanism as the async functions, which allows the engine
+// to call it from his TaskQueue and to handle the reference counting of the context.
+
+// PromiseResultKind tells how the C++ side converts the value given
+// to the callback before resolving the promise with it.
+type PromiseResultKind int
+
+const (
+	// PromiseResultValue is for a value used as is.
+	PromiseResultValue PromiseResultKind = iota
+
+	// PromiseResultJson is for a string containing JSON, which is parsed.
+	PromiseResultJson
+
+	// PromiseResultMsgPack is for an ArrayBuffer containing MessagePack, which is decoded.
+	PromiseResultMsgPack
+
+	// PromiseResultDate is for a number of milliseconds since the epoch, which becomes a Date.
+	PromiseResultDate
+
+	// PromiseResultBigInt is like PromiseResultMsgPack, but the numbers are converted to BigInt.
+	PromiseResultBigInt
+)
+
+// IsPromiseResolver is implemented by the handlers of the types which can
+// be the result of a function returning a promise.
+type IsPromiseResolver interface {
+	// GoValueToPromiseResolving returns the Go code resolving the promise with the value of
+	// the variable goRes, by calling the progpAPI.JsFunction named callback. The code can
+	// reject the promise with callback.CallWithError, and must then return.
+	GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind)
+}
+
+// getPromiseResolver returns the resolver of the result of the current function.
+func (m *ProgpV8CodeGenerator) getPromiseResolver(fct *progpAPI.RegisteredFunction, returnTypeHandler IsTypeHandler) (IsPromiseResolver, error) {
+	resolver, ok := returnTypeHandler.(IsPromiseResolver)
+
+	if !ok {
+		return nil, errors.New("function " + fct.GoFunctionName + ": type " + fct.GoFunctionInfos.ReturnType + " can't be the result of a promise")
+	}
+
+	return resolver, nil
+}
+
+// checkPromiseParamTypes returns an error if a parameter can't be used after
+// the binding returns, which is the case of the values pointing to C++ memory.
+func (m *ProgpV8CodeGenerator) checkPromiseParamTypes(fct *progpAPI.RegisteredFunction) error {
+	for _, paramType := range fct.GoFunctionInfos.ParamTypes {
+		if _, isPointer := m.getType(paramType).(*TypeUnsafePointer); isPointer {
+			return errors.New("function " + fct.GoFunctionName + ": type " + paramType + " can't be a parameter of a function returning a promise")
+		}
+	}
+
+	return nil
+}
+
+// goPromiseRejectOnError is the Go code rejecting the promise if err isn't nil.
+const goPromiseRejectOnError = `
+		if err != nil {
+			interceptedCall.SetError(err)
+			callback.CallWithError(err)
+			return
+		}`
+
+//region Type handlers
+
+func (m *TypeVoid) GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind) {
+	return "    callback.CallWithUndefined()", PromiseResultValue
+}
+
+func (m *TypeBool) GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind) {
+	return "    callback.CallWithBool2(goRes)", PromiseResultValue
+}
+
+func (m *TypeInt) GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind) {
+	if m.isBigInt(ctx) {
+		return `    asBytes, err := progpAPI.MarshalMsgPack(goRes)` + goPromiseRejectOnError + `
+		callback.CallWithArrayBuffer2(asBytes)`, PromiseResultBigInt
+	}
+
+	if !m.isSafeRangeLimited() {
+		return "    callback.CallWithDouble2(float64(goRes))", PromiseResultValue
+	}
+
+	ctx.AddNamespace("errors")
+	ctx.AddNamespace("strconv")
+
+	var template string
+
+	if m.isUnsigned {
+		template = `    if uint64(goRes) > %MAX% {
+			callback.CallWithError(errors.New("the returned value " + strconv.FormatUint(uint64(goRes), 10) + " can't be safely converted to a javascript number"))
+			return
+		}
+`
+	} else {
+		template = `    if (int64(goRes) > %MAX%) || (int64(goRes) < -%MAX%) {
+			callback.CallWithError(errors.New("the returned value " + strconv.FormatInt(int64(goRes), 10) + " can't be safely converted to a javascript number"))
+			return
+		}
+`
+	}
+
+	template += "\t\tcallback.CallWithDouble2(float64(goRes))"
+
+	return strings.ReplaceAll(template, "%MAX%", strconv.FormatInt(jsMaxSafeInteger, 10)), PromiseResultValue
+}
+
+func (m *TypeFloat32) GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind) {
+	return "    callback.CallWithDouble2(float64(goRes))", PromiseResultValue
+}
+
+func (m *TypeFloat64) GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind) {
+	return "    callback.CallWithDouble2(goRes)", PromiseResultValue
+}
+
+func (m *TypeString) GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind) {
+	return "    callback.CallWithString2(goRes)", PromiseResultValue
+}
+
+func (m *TypeStringBuffer) GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind) {
+	return "    callback.CallWithStringBuffer2(goRes)", PromiseResultValue
+}
+
+func (m *TypeUIntArray) GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind) {
+	return "    callback.CallWithArrayBuffer2(goRes)", PromiseResultValue
+}
+
+func (m *TypeSharedResource) GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind) {
+	return "    callback.CallWithResource2(goRes)", PromiseResultValue
+}
+
+func (m *TypeTime) GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind) {
+	return "    callback.CallWithDouble2(float64(goRes.UnixMilli()))", PromiseResultDate
+}
+
+func (m *TypeDuration) GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind) {
+	ctx.AddNamespace("time")
+	return "    callback.CallWithDouble2(float64(goRes) / float64(time.Millisecond))", PromiseResultValue
+}
+
+// The collections are encoded with MessagePack, since the typed arrays can't be given to the callback.
+
+func (m *TypeNumericSlice) GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind) {
+	return goPromiseMsgPackResolving(), PromiseResultMsgPack
+}
+
+func (m *TypeStringSlice) GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind) {
+	return goPromiseMsgPackResolving(), PromiseResultMsgPack
+}
+
+func (m *TypeStringMap) GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind) {
+	return goPromiseMsgPackResolving(), PromiseResultMsgPack
+}
+
+func (m *CustomType) GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind) {
+	if m.isMsgPack() {
+		return goPromiseMsgPackResolving(), PromiseResultMsgPack
+	}
+
+	ctx.AddNamespace("encoding/json")
+
+	return `    asBytes, err := json.Marshal(goRes)` + goPromiseRejectOnError + `
+		callback.CallWithString2(string(asBytes))`, PromiseResultJson
+}
+
+func (m *TypeResults) GoValueToPromiseResolving(ctx *ProgpV8CodeGenerator) (string, PromiseResultKind) {
+	return m.goMarshalCode() + goPromiseRejectOnError + `
+		callback.CallWithArrayBuffer2(asBytes)`, PromiseResultMsgPack
+}
+
+func goPromiseMsgPackResolving() string {
+	return `    asBytes, err := progpAPI.MarshalMsgPack(goRes)` + goPromiseRejectOnError + `
+		callback.CallWithArrayBuffer2(asBytes)`
+}
+
+//endregion
+
+// cppHelperPromise creates the promise and the callback settling it.
+// It's added with ProgpV8CodeGenerator.AddCppHelper("promise", cppHelperPromise),
+// after cppHelperGoErrors and cppHelperSettledHooks.
+const cppHelperPromise = `
+#include <string>
+#include <vector>
+
+#define PROGP_PROMISE_RESULT_VALUE 0
+#define PROGP_PROMISE_RESULT_JSON 1
+#define PROGP_PROMISE_RESULT_MSGPACK 2
+#define PROGP_PROMISE_RESULT_DATE 3
+#define PROGP_PROMISE_RESULT_BIGINT 4
+
+// Is the callback given to the binding. The data is an array [resolver, resultKind, this function].
+static void progpPromiseSettle(const v8::FunctionCallbackInfo<v8::Value>& callInfo) {
+    v8::Isolate* v8Iso = callInfo.GetIsolate();
+    v8::Local<v8::Context> v8Ctx = v8Iso->GetCurrentContext();
+
+    v8::Local<v8::Array> data = callInfo.Data().As<v8::Array>();
+    v8::Local<v8::Promise::Resolver> resolver = data->Get(v8Ctx, 0).ToLocalChecked().As<v8::Promise::Resolver>();
+    int resultKind = data->Get(v8Ctx, 1).ToLocalChecked().As<v8::Int32>()->Value();
+
+    progpCallSettledHook(v8Iso, data->Get(v8Ctx, 2).ToLocalChecked().As<v8::Object>());
+
+    if ((callInfo.Length() > 0) && !callInfo[0]->IsNullOrUndefined()) {
+        resolver->Reject(v8Ctx, progpConvertGoError(v8Iso, callInfo[0])).Check();
+        return;
+    }
+
+    v8::Local<v8::Value> value = v8::Undefined(v8Iso);
+    if (callInfo.Length() > 1) value = callInfo[1];
+
+    v8::TryCatch tryCatch(v8Iso);
+
+    switch (resultKind) {
+        case PROGP_PROMISE_RESULT_JSON:
+            if (value->IsString() && !v8::JSON::Parse(v8Ctx, value.As<v8::String>()).ToLocal(&value)) {
+                resolver->Reject(v8Ctx, tryCatch.Exception()).Check();
+                return;
+            }
+            break;
+
+        case PROGP_PROMISE_RESULT_MSGPACK:
+        case PROGP_PROMISE_RESULT_BIGINT:
+            if (value->IsArrayBuffer()) {
+                std::shared_ptr<v8::BackingStore> store = value.As<v8::ArrayBuffer>()->GetBackingStore();
+
+                if (!progpMsgPackToV8(v8Iso, (const char*)store->Data(), store->ByteLength(), &value)) {
+                    resolver->Reject(v8Ctx, v8::Exception::TypeError(v8::String::NewFromUtf8(v8Iso, "the returned value isn't valid MessagePack").ToLocalChecked())).Check();
+                    return;
+                }
+
+                if ((resultKind == PROGP_PROMISE_RESULT_BIGINT) && value->IsNumber()) {
+                    value = v8::BigInt::New(v8Iso, (int64_t)value.As<v8::Number>()->Value());
+                }
+            }
+            break;
+
+        case PROGP_PROMISE_RESULT_DATE:
+            if (value->IsNumber()) value = v8::Date::New(v8Ctx, value.As<v8::Number>()->Value()).ToLocalChecked();
+            break;
+    }
+
+    resolver->Resolve(v8Ctx, value).Check();
+}
+
+// progpCallAsPromise calls the binding with the arguments and a callback settling the promise returned.
+// The binding is called with the data of the function called by javascript, which allows him finding his context.
+// A bad argument count, or an exception thrown while decoding the arguments, rejects the promise.
+static void progpCallAsPromise(const v8::FunctionCallbackInfo<v8::Value>& callInfo, v8::FunctionCallback binding, int minArgCount, int argCount, int resultKind) {
+    v8::Isolate* v8Iso = callInfo.GetIsolate();
+    v8::Local<v8::Context> v8Ctx = v8Iso->GetCurrentContext();
+    v8::Local<v8::Promise::Resolver> resolver = v8::Promise::Resolver::New(v8Ctx).ToLocalChecked();
+    callInfo.GetReturnValue().Set(resolver->GetPromise());
+
+    if ((callInfo.Length() < minArgCount) || (callInfo.Length() > argCount)) {
+        std::string msg = "expects " + std::to_string(argCount) + " arguments";
+        if (minArgCount != argCount) msg = "expects between " + std::to_string(minArgCount) + " and " + std::to_string(argCount) + " arguments";
+        resolver->Reject(v8Ctx, v8::Exception::TypeError(v8::String::NewFromUtf8(v8Iso, msg.c_str()).ToLocalChecked())).Check();
+        return;
+    }
+
+    v8::Local<v8::Array> settleData = v8::Array::New(v8Iso, 3);
+    settleData->Set(v8Ctx, 0, resolver).Check();
+    settleData->Set(v8Ctx, 1, v8::Integer::New(v8Iso, resultKind)).Check();
+
+    v8::Local<v8::Function> settle = v8::Function::New(v8Ctx, progpPromiseSettle, settleData).ToLocalChecked();
+    settleData->Set(v8Ctx, 2, settle).Check();
+
+    std::vector<v8::Local<v8::Value>> args(argCount + 1, v8::Undefined(v8Iso).As<v8::Value>());
+    for (int i = 0; (i < argCount) && (i < callInfo.Length()); i++) args[i] = callInfo[i];
+    args[argCount] = settle;
+
+    v8::Local<v8::Function> bindingFunction = v8::Function::New(v8Ctx, binding, callInfo.Data()).ToLocalChecked();
+
+    v8::TryCatch tryCatch(v8Iso);
+
+    if (bindingFunction->Call(v8Ctx, callInfo.This(), argCount + 1, args.data()).IsEmpty() && tryCatch.HasCaught()) {
+        resolver->Reject(v8Ctx, tryCatch.Exception()).Check();
+    }
+}
+`
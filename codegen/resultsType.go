@@ -0,0 +1,106 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codegen
+
+import (
+	"errors"
+	"github.com/progpjs/progpAPI/v2"
+	"strconv"
+	"strings"
+)
+
+// TypeResults handles the values returned by a function having several results.
+// They are encoded with MessagePack, which allows keeping the binary data and the
+// dates, and are received by javascript as an array, or as an object when they
+// are named with progpAPI.RegisteredFunction.WithResultNames.
+// See progpAPI.MarshalMsgPackResults for the type of each result.
+type TypeResults struct {
+	CustomType
+	resultCount int
+	resultNames []string
+	useBigInt   bool
+}
+
+func newTypeResults(fct *progpAPI.RegisteredFunction) *TypeResults {
+	return &TypeResults{
+		CustomType:  CustomType{encoding: progpAPI.CustomTypeEncodingMsgPack},
+		resultCount: len(fct.GoFunctionInfos.ResultTypes),
+		resultNames: fct.ResultNames,
+		useBigInt:   fct.UseBigInt,
+	}
+}
+
+// goResultNames returns the name of the Go variables receiving the results.
+func (m *TypeResults) goResultNames() []string {
+	res := make([]string, m.resultCount)
+
+	for i := range res {
+		res[i] = "goRes" + strconv.Itoa(i)
+	}
+
+	return res
+}
+
+func (m *TypeResults) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string {
+	ctx.AddNamespace("unsafe")
+
+	return m.goMarshalCode() + `
+
+	if err != nil {
+		res.errorMessage = C.CString(err.Error())
+	} else {
+		res.value = unsafe.Pointer(&asBytes[0])
+		res.size = C.int(len(asBytes))
+	}`
+}
+
+// goMarshalCode returns the Go code encoding the results into the variable asBytes.
+func (m *TypeResults) goMarshalCode() string {
+	names := "nil"
+
+	if m.resultNames != nil {
+		quoted := make([]string, len(m.resultNames))
+
+		for i, name := range m.resultNames {
+			quoted[i] = strconv.Quote(name)
+		}
+
+		names = "[]string{" + strings.Join(quoted, ", ") + "}"
+	}
+
+	return `    asBytes, err := progpAPI.MarshalMsgPackResults(` + names + `, ` + strconv.FormatBool(m.useBigInt) + `, ` + strings.Join(m.goResultNames(), ", ") + `)`
+}
+
+// checkResultTypes returns an error if a result can't be encoded with MessagePack,
+// which is the case of the values handled by the engine, like the shared resources,
+// and of the types having a handler registered by a module.
+func (m *ProgpV8CodeGenerator) checkResultTypes(fct *progpAPI.RegisteredFunction) error {
+	for _, resultType := range fct.GoFunctionInfos.ResultTypes {
+		isSupported := GetRegisteredTypeHandler(resultType) == nil
+
+		switch m.getType(resultType).(type) {
+		case *TypeVoid, *TypeUnsafePointer, *TypeJsFunction, *TypeSharedResource, *TypeSharedResourceContainer, *TypeGoContext, *TypeStringBuffer, *TypeDuration:
+			isSupported = false
+		}
+
+		if !isSupported {
+			return errors.New("function " + fct.GoFunctionName + ": type " + resultType + " can't be returned with other values")
+		}
+	}
+
+	return nil
+}
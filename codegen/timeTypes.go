@@ -0,0 +1,244 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codegen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const cppHelperTime = `
+#include <cmath>
+
+// Decodes a date sent as a Date or as a number of milliseconds since 1970.
+// Returns 0 if ok, 1 if it's not a date and 2 if it's an invalid date.
+//
+static int progpV8ToDateMs(v8::Local<v8::Value> value, double* out) {
+    double ms;
+
+    if (value->IsDate()) ms = value.As<v8::Date>()->ValueOf();
+    else if (value->IsNumber()) ms = value.As<v8::Number>()->Value();
+    else return 1;
+
+    // An invalid Date is NaN, which fails all the comparisons.
+    // A valid Date is at most 8.64e15 ms from 1970.
+    if (!(ms >= -8.64e15) || !(ms <= 8.64e15)) return 2;
+
+    *out = std::trunc(ms);
+    return 0;
+}
+
+// Decodes a duration sent as a number of milliseconds.
+// Returns 0 if ok, 1 if it's not a number and 2 if it's out of range.
+//
+static int progpV8ToDurationMs(v8::Local<v8::Value> value, double* out) {
+    if (!value->IsNumber()) return 1;
+    double ms = value.As<v8::Number>()->Value();
+
+    // A time.Duration is an int64 of nanoseconds.
+    if (!(ms >= -9223372036854.0) || !(ms <= 9223372036854.0)) return 2;
+
+    *out = ms;
+    return 0;
+}
+`
+
+// The biggest number of milliseconds, from 1970, which can be stored in a javascript Date.
+const jsMaxDateMs = 8640000000000000
+
+//region time.Time
+
+// TypeTime allows exchanging a time.Time as a javascript Date.
+// A number of milliseconds since 1970 is also accepted as parameter.
+// The precision is the millisecond.
+type TypeTime struct {
+}
+
+func (m *TypeTime) V8ValueToCppDecoder(paramName string, v8Value string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return m.cppDecoder(paramName, v8Value, "", paramPosition, ctx)
+}
+
+func (m *TypeTime) OptionalV8ValueToCppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return m.cppDecoder(paramName, v8Value, isSet, paramPosition, ctx)
+}
+
+func (m *TypeTime) cppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	ctx.AddCppHelper("throwErrors", cppHelperThrowErrors)
+	ctx.AddCppHelper("time", cppHelperTime)
+
+	template := `    double %PARAM_NAME%%INIT%;
+    switch (%IF_SET%progpV8ToDateMs(%V8_VALUE%, &%PARAM_NAME%)) {
+        case 1: PROGP_THROW_TYPE_ERROR("argument %POSITION% must be a Date");
+        case 2: PROGP_THROW_RANGE_ERROR("argument %POSITION% is an invalid Date");
+    }`
+
+	template = strings.ReplaceAll(template, "%PARAM_NAME%", paramName)
+	template = strings.ReplaceAll(template, "%V8_VALUE%", v8Value)
+	template = strings.ReplaceAll(template, "%POSITION%", strconv.Itoa(paramPosition))
+
+	return optionalSwitchReplacer(template, isSet)
+}
+
+func (m *TypeTime) CppToCgoParamCall(paramName string, ctx *ProgpV8CodeGenerator) string {
+	return paramName
+}
+
+func (m *TypeTime) V8ToCppDecoder(ctx *ProgpV8CodeGenerator) string {
+	// Is done by V8ValueToCppDecoder.
+	return ""
+}
+
+func (m *TypeTime) ReturnTypeWrapper(ctx *ProgpV8CodeGenerator) string {
+	return "ProgpFunctionReturnDouble"
+}
+
+func (m *TypeTime) ReturnTypeEncoder(ctx *ProgpV8CodeGenerator) string {
+	// The range is checked on the Go side, so it can't fail.
+	return "callInfo.GetReturnValue().Set(v8::Date::New(v8Iso->GetCurrentContext(), res).ToLocalChecked());"
+}
+
+func (m *TypeTime) CgoFunctionParamType(ctx *ProgpV8CodeGenerator) string {
+	return "C.double"
+}
+
+func (m *TypeTime) CppArgResourcesFreeing(paramName string, ctx *ProgpV8CodeGenerator) string {
+	return ""
+}
+
+func (m *TypeTime) CgoToGoDecoding(paramName string, ctx *ProgpV8CodeGenerator) (string, string) {
+	ctx.AddNamespace("time")
+	return "", "time.UnixMilli(int64(" + paramName + "))"
+}
+
+func (m *TypeTime) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string {
+	template := `    resMs := goRes.UnixMilli()
+	if (resMs > %MAX%) || (resMs < -%MAX%) {
+		res.errorMessage = C.CString("the returned time " + goRes.String() + " can't be converted to a javascript Date")
+		return
+	}
+
+    res.value = C.double(resMs)`
+
+	return strings.ReplaceAll(template, "%MAX%", strconv.FormatInt(jsMaxDateMs, 10))
+}
+
+func (m *TypeTime) FcCppToV8Encoder(paramId int) string {
+	return fmt.Sprintf("    argArray[%d] = v8::Date::New(v8Ctx, p%d).ToLocalChecked();\n", paramId, paramId)
+}
+
+func (m *TypeTime) FcCppFunctionHeader(paramId int) string {
+	return fmt.Sprintf(", double p%d", paramId)
+}
+
+func (m *TypeTime) FcGoToCppCallParam(paramId int) string {
+	// A time out of the range of the Date gives an invalid Date.
+	return fmt.Sprintf("\n                (C.double)(p%d.UnixMilli()),", paramId)
+}
+
+func (m *TypeTime) FcGoToCppConvCache(_ int) string {
+	return ""
+}
+
+//endregion
+
+//region time.Duration
+
+// TypeDuration allows exchanging a time.Duration as a number of milliseconds,
+// which is what the javascript functions like setTimeout are using.
+// The number can have a fractional part, for durations smaller than a millisecond.
+type TypeDuration struct {
+}
+
+func (m *TypeDuration) V8ValueToCppDecoder(paramName string, v8Value string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return m.cppDecoder(paramName, v8Value, "", paramPosition, ctx)
+}
+
+func (m *TypeDuration) OptionalV8ValueToCppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	return m.cppDecoder(paramName, v8Value, isSet, paramPosition, ctx)
+}
+
+func (m *TypeDuration) cppDecoder(paramName string, v8Value string, isSet string, paramPosition int, ctx *ProgpV8CodeGenerator) string {
+	ctx.AddCppHelper("throwErrors", cppHelperThrowErrors)
+	ctx.AddCppHelper("time", cppHelperTime)
+
+	template := `    double %PARAM_NAME%%INIT%;
+    switch (%IF_SET%progpV8ToDurationMs(%V8_VALUE%, &%PARAM_NAME%)) {
+        case 1: PROGP_THROW_TYPE_ERROR("argument %POSITION% must be a number of milliseconds");
+        case 2: PROGP_THROW_RANGE_ERROR("argument %POSITION% is out of the range of a duration");
+    }`
+
+	template = strings.ReplaceAll(template, "%PARAM_NAME%", paramName)
+	template = strings.ReplaceAll(template, "%V8_VALUE%", v8Value)
+	template = strings.ReplaceAll(template, "%POSITION%", strconv.Itoa(paramPosition))
+
+	return optionalSwitchReplacer(template, isSet)
+}
+
+func (m *TypeDuration) CppToCgoParamCall(paramName string, ctx *ProgpV8CodeGenerator) string {
+	return paramName
+}
+
+func (m *TypeDuration) V8ToCppDecoder(ctx *ProgpV8CodeGenerator) string {
+	// Is done by V8ValueToCppDecoder.
+	return ""
+}
+
+func (m *TypeDuration) ReturnTypeWrapper(ctx *ProgpV8CodeGenerator) string {
+	return "ProgpFunctionReturnDouble"
+}
+
+func (m *TypeDuration) ReturnTypeEncoder(ctx *ProgpV8CodeGenerator) string {
+	return "callInfo.GetReturnValue().Set(V8VALUE_FROM_DOUBLE(res));"
+}
+
+func (m *TypeDuration) CgoFunctionParamType(ctx *ProgpV8CodeGenerator) string {
+	return "C.double"
+}
+
+func (m *TypeDuration) CppArgResourcesFreeing(paramName string, ctx *ProgpV8CodeGenerator) string {
+	return ""
+}
+
+func (m *TypeDuration) CgoToGoDecoding(paramName string, ctx *ProgpV8CodeGenerator) (string, string) {
+	ctx.AddNamespace("time")
+	return "", "time.Duration(float64(" + paramName + ") * float64(time.Millisecond))"
+}
+
+func (m *TypeDuration) GoValueToCgoValue(ctx *ProgpV8CodeGenerator) string {
+	ctx.AddNamespace("time")
+	return "    res.value = C.double(float64(goRes) / float64(time.Millisecond))"
+}
+
+func (m *TypeDuration) FcCppToV8Encoder(paramId int) string {
+	return fmt.Sprintf("    argArray[%d] = DOUBLE_TO_V8VALUE(p%d);\n", paramId, paramId)
+}
+
+func (m *TypeDuration) FcCppFunctionHeader(paramId int) string {
+	return fmt.Sprintf(", double p%d", paramId)
+}
+
+func (m *TypeDuration) FcGoToCppCallParam(paramId int) string {
+	// 1e6 is time.Millisecond.
+	return fmt.Sprintf("\n                (C.double)(float64(p%d) / 1e6),", paramId)
+}
+
+func (m *TypeDuration) FcGoToCppConvCache(_ int) string {
+	return ""
+}
+
+//endregion
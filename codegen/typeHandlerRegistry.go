@@ -0,0 +1,164 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codegen
+
+import (
+	"errors"
+	"github.com/progpjs/progpAPI/v2"
+	"reflect"
+	"sync"
+)
+
+// IsTypeScriptTypeProvider can be implemented by a type handler
+// in order to give the TypeScript type of the value once in javascript.
+// Without it, the TypeScript declarations use the type "unknown".
+// An empty string means that the value isn't visible to javascript,
+// which is the case for parameters automatically added by the engine.
+type IsTypeScriptTypeProvider interface {
+	TypeScriptType() string
+}
+
+var gCustomTypeHandlers = make(map[string]IsTypeHandler)
+var gCustomTypeHandlersMutex sync.Mutex
+
+// The types given to RegisterTypeHandlerFor. The handlers are found from the name of
+// the type, so it allows detecting the types having the same name in two packages.
+var gCustomTypeHandlerTypes = make(map[string]reflect.Type)
+
+// RegisterTypeHandler allows a module to provide his own handler for a Go type,
+// which avoids the default JSON encoding used for the unknown types.
+// The name is the one given by reflect.Type.String(), for example "*myModule.MyType".
+//
+// It must be called before the code generator is created, typically from an init function.
+// A handler registered for a built-in type replaces the default handler,
+// while registering one for the type of a class is reported as an error by the code generator.
+// If the handler also implements IsFunctionCallerSupportedType, then the type
+// can also be used as a parameter when calling a javascript function.
+func RegisterTypeHandler(goTypeName string, handler IsTypeHandler) {
+	if goTypeName == "" {
+		panic("codegen.RegisterTypeHandler: the type name is empty")
+	}
+
+	if handler == nil {
+		panic("codegen.RegisterTypeHandler: the handler of type " + goTypeName + " is nil")
+	}
+
+	gCustomTypeHandlersMutex.Lock()
+	defer gCustomTypeHandlersMutex.Unlock()
+
+	gCustomTypeHandlers[goTypeName] = handler
+}
+
+// RegisterTypeHandlerFor is like RegisterTypeHandler but takes the type itself,
+// which avoids errors when writing the type name.
+//
+// Since the name of a type doesn't include the path of his package, two packages can have
+// a type with the same name. Registering a handler for both is an error, and the code generator
+// fails if a function uses a type having the name of a registered type, but from another package.
+//
+// Sample: codegen.RegisterTypeHandlerFor(reflect.TypeOf((*MyType)(nil)), &myTypeHandler{})
+func RegisterTypeHandlerFor(goType reflect.Type, handler IsTypeHandler) {
+	if goType == nil {
+		panic("codegen.RegisterTypeHandlerFor: the type is nil")
+	}
+
+	typeName := goType.String()
+
+	gCustomTypeHandlersMutex.Lock()
+	registered := gCustomTypeHandlerTypes[typeName]
+
+	if registered == nil {
+		gCustomTypeHandlerTypes[typeName] = goType
+	}
+
+	gCustomTypeHandlersMutex.Unlock()
+
+	if (registered != nil) && (registered != goType) {
+		panic("codegen.RegisterTypeHandlerFor: the type " + typeName + " of the package " + typePackagePath(goType) +
+			" has the same name as the type of the package " + typePackagePath(registered) + ", which already has a handler")
+	}
+
+	RegisterTypeHandler(typeName, handler)
+}
+
+// GetRegisteredTypeHandler returns the handler registered for this type name, or nil.
+// The built-in handlers aren't returned.
+func GetRegisteredTypeHandler(goTypeName string) IsTypeHandler {
+	gCustomTypeHandlersMutex.Lock()
+	defer gCustomTypeHandlersMutex.Unlock()
+
+	return gCustomTypeHandlers[goTypeName]
+}
+
+func addRegisteredTypeHandlers(typeMap map[string]IsTypeHandler) {
+	gCustomTypeHandlersMutex.Lock()
+	defer gCustomTypeHandlersMutex.Unlock()
+
+	for typeName, handler := range gCustomTypeHandlers {
+		typeMap[typeName] = handler
+	}
+}
+
+// typePackagePath returns the path of the package of a named type,
+// or of the named type used by a pointer, a slice, an array or a map.
+func typePackagePath(goType reflect.Type) string {
+	for goType.Name() == "" {
+		switch goType.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+			goType = goType.Elem()
+		default:
+			return ""
+		}
+	}
+
+	return goType.PkgPath()
+}
+
+// checkTypeHandlerCollisions returns an error if the function uses a type having the name of a
+// type given to RegisterTypeHandlerFor, but from another package. The handler would be used for it.
+func (m *ProgpV8CodeGenerator) checkTypeHandlerCollisions(fct *progpAPI.RegisteredFunction) error {
+	infos := fct.GoFunctionInfos
+	goTypes := append([]reflect.Type{infos.ReturnTypeRef}, infos.ResultTypeRefs...)
+
+	for offset, paramType := range infos.ParamTypeRefs {
+		goTypes = append(goTypes, paramType)
+
+		if valueType := progpAPI.GetNullableParamValueType(paramType); valueType != nil {
+			goTypes = append(goTypes, valueType)
+		}
+
+		if infos.IsVariadic && (offset == len(infos.ParamTypeRefs)-1) {
+			goTypes = append(goTypes, paramType.Elem())
+		}
+	}
+
+	gCustomTypeHandlersMutex.Lock()
+	defer gCustomTypeHandlersMutex.Unlock()
+
+	for _, goType := range goTypes {
+		if goType == nil {
+			continue
+		}
+
+		if registered := gCustomTypeHandlerTypes[goType.String()]; (registered != nil) && (registered != goType) {
+			return errors.New("function " + fct.GoFunctionName + ": the type " + goType.String() + " of the package " + typePackagePath(goType) +
+				" has the same name as the type of the package " + typePackagePath(registered) + ", which has a handler")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,666 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codegen
+
+import (
+	"encoding"
+	"encoding/json"
+	"github.com/progpjs/progpAPI/v2"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TypeScriptGenerator generates the TypeScript declarations (.d.ts)
+// of the functions exposed to javascript, with one file per group.
+// The group "global" is declared as global functions, while the others
+// groups are declared as the module "@progp/groupName".
+type TypeScriptGenerator struct {
+	functionList []*progpAPI.RegisteredFunction
+	isDryRun     bool
+}
+
+func NewTypeScriptGenerator() *TypeScriptGenerator {
+	fctRegistry := progpAPI.GetFunctionRegistry()
+
+	// Function list must be sorted in order to always generate the same output.
+	functionList := fctRegistry.GetAllFunctions(true)
+
+	return &TypeScriptGenerator{functionList: functionList}
+}
+
+// BuildDeclarations returns the content of the declaration file of each group.
+// The key is the file name, for example "fs.d.ts".
+func (m *TypeScriptGenerator) BuildDeclarations() map[string]string {
+	byGroup := make(map[string][]*progpAPI.RegisteredFunction)
+
+	for _, fct := range m.functionList {
+		byGroup[fct.Group] = append(byGroup[fct.Group], fct)
+	}
+
+	res := make(map[string]string)
+
+	for group, functions := range byGroup {
+		builder := newTsGroupBuilder(group)
+
+		for _, fct := range functions {
+			builder.addFunction(fct)
+		}
+
+		res[builder.fileName()] = builder.build()
+	}
+
+	return res
+}
+
+// EnableDryRun allows computing the changes without writing the files.
+func (m *TypeScriptGenerator) EnableDryRun(enabled bool) {
+	m.isDryRun = enabled
+}
+
+// GenerateDeclarations writes the declaration files inside the output dir.
+// Files which content is the same aren't written again.
+func (m *TypeScriptGenerator) GenerateDeclarations(outputDir string) *GenerationResult {
+	result := newGenerationResult(m.isDryRun)
+	declarations := m.BuildDeclarations()
+
+	var fileNames []string
+	for fileName := range declarations {
+		fileNames = append(fileNames, fileName)
+	}
+
+	sort.Strings(fileNames)
+
+	for _, fileName := range fileNames {
+		result.saveFile(path.Join(outputDir, fileName), declarations[fileName], false)
+	}
+
+	return result
+}
+
+//region tsGroupBuilder
+
+type tsGroupBuilder struct {
+	group    string
+	isGlobal bool
+
+	functions     string
+	interfaces    string
+	interfaceMap  map[tsInterfaceKey]string
+	usedNames     map[string]bool
+	needsResource bool
+}
+
+func newTsGroupBuilder(group string) *tsGroupBuilder {
+	return &tsGroupBuilder{
+		group:        group,
+		isGlobal:     group == "global",
+		interfaceMap: make(map[tsInterfaceKey]string),
+		usedNames:    make(map[string]bool),
+	}
+}
+
+func (m *tsGroupBuilder) fileName() string {
+	return strings.ReplaceAll(m.group, "/", "_") + ".d.ts"
+}
+
+func (m *tsGroupBuilder) build() string {
+	res := "// Generated by progpAPI codegen. Don't edit.\n"
+
+	body := ""
+
+	if m.needsResource {
+		body += "\n\n    const progpSharedResourceBrand: unique symbol;"
+		body += "\n    /** Handle to a Go value, which must be released with progpDispose. */"
+		body += "\n    " + m.exportKeyword() + "type SharedResource = number & { readonly [progpSharedResourceBrand]: true };"
+	}
+
+	body += m.interfaces + m.functions
+
+	if m.isGlobal {
+		res += "\nexport {};\n\ndeclare global {" + body + "\n}\n"
+	} else {
+		res += "\ndeclare module " + strconv.Quote("@progp/"+m.group) + " {" + body + "\n}\n"
+	}
+
+	return res
+}
+
+func (m *tsGroupBuilder) exportKeyword() string {
+	if m.isGlobal {
+		return ""
+	}
+
+	return "export "
+}
+
+func (m *tsGroupBuilder) addFunction(fct *progpAPI.RegisteredFunction) {
+	if fct.IsClassMethod() {
+		// Is declared with his class.
+		return
+	}
+
+	if fct.IsClassConstructor {
+		m.addClass(fct)
+		return
+	}
+
+	params, returnType := m.functionSignature(fct)
+
+	m.functions += "\n\n    " + tsDocComment("    ", "Go function: "+fct.GoFunctionName, fct.Capabilities)
+	m.functions += "\n    " + m.exportKeyword() + "function " + fct.JsFunctionName + "(" + params + "): " + returnType + ";"
+}
+
+func (m *tsGroupBuilder) addClass(constructor *progpAPI.RegisteredFunction) {
+	class := progpAPI.GetFunctionRegistry().GetClass(constructor.Group, constructor.ClassName)
+	params, _ := m.functionSignature(constructor)
+
+	m.functions += "\n\n    /** Go type: " + class.GoType.String() + " */"
+	m.functions += "\n    " + m.exportKeyword() + "class " + class.JsClassName + " {"
+
+	if len(constructor.Capabilities) != 0 {
+		m.functions += "\n        " + tsDocComment("        ", "", constructor.Capabilities)
+	}
+
+	m.functions += "\n        constructor(" + params + ");"
+
+	for _, method := range class.Methods {
+		params, returnType := m.functionSignature(method)
+
+		if len(method.Capabilities) != 0 {
+			m.functions += "\n        " + tsDocComment("        ", "", method.Capabilities)
+		}
+
+		m.functions += "\n        " + method.GetJsMethodName() + "(" + params + "): " + returnType + ";"
+	}
+
+	m.functions += "\n        [Symbol.dispose](): void;"
+	m.functions += "\n    }"
+}
+
+// tsDocComment returns a documentation comment with this text, and the capabilities
+// of the function if any. The indent is the one of the line of the comment.
+func tsDocComment(indent string, text string, capabilities []string) string {
+	var lines []string
+
+	if text != "" {
+		lines = append(lines, text)
+	}
+
+	if len(capabilities) != 0 {
+		lines = append(lines, "Capabilities: "+strings.Join(capabilities, ", "))
+	}
+
+	if len(lines) == 1 {
+		return "/** " + lines[0] + " */"
+	}
+
+	return "/**\n" + indent + " * " + strings.Join(lines, "\n"+indent+" * ") + "\n" + indent + " */"
+}
+
+// functionSignature returns the parameters and the returned type of a function.
+func (m *tsGroupBuilder) functionSignature(fct *progpAPI.RegisteredFunction) (string, string) {
+	infos := fct.GoFunctionInfos
+
+	// For async functions, the callback is the last function parameter.
+	callbackOffset := -1
+	if fct.IsAsync {
+		for offset, paramType := range infos.ParamTypes {
+			if paramType == "progpAPI.JsFunction" {
+				callbackOffset = offset
+			}
+		}
+	}
+
+	var params []string
+	var paramsNullable []bool
+
+	for offset, paramType := range infos.ParamTypeRefs {
+		if (offset == 0) && fct.IsClassMethod() {
+			// Is the instance.
+			continue
+		}
+
+		paramName := "p" + strconv.Itoa(offset)
+		var tsType string
+
+		valueType := nullableParamValueType(fct, offset)
+		if valueType != nil {
+			paramType = valueType
+		}
+
+		isVariadic := infos.IsVariadic && (offset == len(infos.ParamTypeRefs)-1)
+
+		if (offset == callbackOffset) && fct.UseAbortSignal {
+			// Is given before the callback, see RegisteredFunction.WithAbortSignal.
+			params = append(params, "signal: "+tsAbortSignalType)
+			paramsNullable = append(paramsNullable, true)
+		}
+
+		if offset == callbackOffset {
+			paramName = "callback"
+			tsType = m.callbackType(fct)
+		} else if isVariadic {
+			// Receives the remaining arguments, each one decoded like a parameter of the element type.
+			paramName = "..." + paramName
+			tsType = m.bindingType(paramType.Elem())
+
+			if fct.UseBigInt && isBigIntType(paramType.Elem()) {
+				tsType = "bigint | number"
+			}
+
+			if tsType != "" {
+				tsType = tsArrayOf(tsType)
+			}
+		} else if arrayType, isTypedArray := gTsTypedArrays[paramType.String()]; isTypedArray {
+			// Plain arrays are also accepted.
+			tsType = arrayType + " | " + tsArrayOf(m.jsonType(paramType.Elem(), false))
+
+			if (arrayType == "BigInt64Array") || (arrayType == "BigUint64Array") {
+				tsType = arrayType + " | (bigint | number)[]"
+			}
+		} else if fct.UseBigInt && isBigIntType(paramType) {
+			// Numbers are also accepted, which is more convenient for small values.
+			tsType = "bigint | number"
+		} else {
+			tsType = m.bindingType(paramType)
+		}
+
+		if tsType == "" {
+			// Is an implicit parameter, like *progpAPI.SharedResourceContainer or context.Context.
+			continue
+		}
+
+		if valueType != nil {
+			tsType += " | null | undefined"
+		}
+
+		params = append(params, paramName+": "+tsType)
+		paramsNullable = append(paramsNullable, valueType != nil)
+	}
+
+	if fct.IsPromise && fct.UseAbortSignal {
+		params = append(params, "signal: "+tsAbortSignalType+" | undefined")
+		paramsNullable = append(paramsNullable, true)
+	}
+
+	// The nullable parameters after the last required one can be omitted.
+	// For a variadic function, it's the ones before the rest parameter.
+	lastParam := len(params) - 1
+	if infos.IsVariadic {
+		lastParam--
+	}
+
+	for i := lastParam; (i >= 0) && paramsNullable[i]; i-- {
+		params[i] = strings.Replace(params[i], ": ", "?: ", 1)
+		params[i] = strings.TrimSuffix(params[i], " | undefined")
+	}
+
+	returnType := "void"
+
+	if len(infos.ResultTypeRefs) != 0 {
+		// Several results, encoded with MessagePack, see TypeResults.
+		var results []string
+
+		for i, resultType := range infos.ResultTypeRefs {
+			if fct.ResultNames != nil {
+				results = append(results, tsPropertyName(fct.ResultNames[i])+": "+m.resultType(fct, resultType))
+			} else {
+				results = append(results, m.resultType(fct, resultType))
+			}
+		}
+
+		if fct.ResultNames != nil {
+			returnType = "{ " + strings.Join(results, "; ") + " }"
+		} else {
+			returnType = "[" + strings.Join(results, ", ") + "]"
+		}
+	} else if infos.ReturnTypeRef != nil {
+		if fct.UseBigInt && isBigIntType(infos.ReturnTypeRef) {
+			returnType = "bigint"
+		} else if _, isTypedArray := gTsTypedArrays[infos.ReturnTypeRef.String()]; isTypedArray && fct.IsPromise {
+			// Is encoded with MessagePack, which gives a plain array.
+			returnType = tsArrayOf(m.jsonType(infos.ReturnTypeRef.Elem(), true))
+		} else {
+			returnType = m.bindingType(infos.ReturnTypeRef)
+		}
+	}
+
+	if fct.IsPromise {
+		returnType = "Promise<" + returnType + ">"
+	}
+
+	return strings.Join(params, ", "), returnType
+}
+
+// tsAbortSignalType is the type of the argument of the functions using RegisteredFunction.WithAbortSignal.
+const tsAbortSignalType = "AbortSignal | { signal?: AbortSignal | null } | null"
+
+// gTsTypedArrays are the slices exchanged as typed arrays, see TypeNumericSlice.
+var gTsTypedArrays = map[string]string{
+	"[]float64": "Float64Array",
+	"[]float32": "Float32Array",
+	"[]int8":    "Int8Array",
+	"[]int16":   "Int16Array",
+	"[]int32":   "Int32Array",
+	"[]int64":   "BigInt64Array",
+	"[]uint16":  "Uint16Array",
+	"[]uint32":  "Uint32Array",
+	"[]uint64":  "BigUint64Array",
+}
+
+// callbackType returns the TypeScript type of the callback of an async function. It receives
+// an error first, then the values declared with progpAPI.RegisteredFunction.WithCallbackCaller.
+func (m *tsGroupBuilder) callbackType(fct *progpAPI.RegisteredFunction) string {
+	if fct.CallbackValueTypes == nil {
+		return "(error: unknown, result?: any) => void"
+	}
+
+	params := []string{"error: unknown"}
+
+	for i, valueType := range fct.CallbackValueTypes {
+		paramName := "result"
+
+		if len(fct.CallbackValueTypes) > 1 {
+			paramName += strconv.Itoa(i + 1)
+		}
+
+		tsType := m.bindingType(valueType)
+
+		if tsType == "" {
+			tsType = "unknown"
+		}
+
+		// Are missing when an error is given.
+		params = append(params, paramName+"?: "+tsType)
+	}
+
+	return "(" + strings.Join(params, ", ") + ") => void"
+}
+
+// resultType returns the TypeScript type of one of the results of a
+// function having several ones, see progpAPI.MarshalMsgPackResults.
+func (m *tsGroupBuilder) resultType(fct *progpAPI.RegisteredFunction, goType reflect.Type) string {
+	if goType.Implements(gTsTextMarshalerType) {
+		return m.jsonType(goType, true)
+	}
+
+	switch goType.Kind() {
+	case reflect.Int64, reflect.Uint64:
+		if fct.UseBigInt {
+			return "bigint"
+		}
+
+		return "number"
+	case reflect.Int, reflect.Uint, reflect.Uintptr:
+		return "number"
+	case reflect.Slice:
+		if goType.Elem().Kind() == reflect.Uint8 {
+			return "ArrayBuffer"
+		}
+	}
+
+	return m.jsonType(goType, true)
+}
+
+// isBigIntType returns true if the type is exchanged as BigInt by the functions using WithBigInt.
+func isBigIntType(goType reflect.Type) bool {
+	typeName := goType.String()
+	return (typeName == "int64") || (typeName == "uint64")
+}
+
+// bindingType returns the TypeScript type of a value directly exchanged
+// with the javascript engine, as a parameter or as a returned value.
+// Returns an empty string if the value isn't visible to javascript.
+func (m *tsGroupBuilder) bindingType(goType reflect.Type) string {
+	if class := progpAPI.GetFunctionRegistry().GetClassOfType(goType); class != nil {
+		return class.JsClassName
+	}
+
+	if handler := GetRegisteredTypeHandler(goType.String()); handler != nil {
+		if provider, ok := handler.(IsTypeScriptTypeProvider); ok {
+			return provider.TypeScriptType()
+		}
+
+		// The encoding is chosen by the handler, we can't known it.
+		return "unknown"
+	}
+
+	if arrayType, isTypedArray := gTsTypedArrays[goType.String()]; isTypedArray {
+		return arrayType
+	}
+
+	switch goType.String() {
+	case "progpAPI.StringBuffer":
+		return "string"
+	case "[]uint8":
+		return "ArrayBuffer"
+	case "progpAPI.JsFunction":
+		return "(...args: any[]) => void"
+	case "*progpAPI.SharedResource":
+		m.needsResource = true
+		return "SharedResource"
+	case "*progpAPI.SharedResourceContainer", "context.Context":
+		return ""
+	case "unsafe.Pointer":
+		return "unknown"
+	case "time.Time":
+		return "Date"
+	case "time.Duration":
+		// Is a number of milliseconds.
+		return "number"
+	}
+
+	// Simple types are encoded the same way as JSON, while the others
+	// are handled by CustomType which uses JSON or MessagePack.
+	isMsgPack := progpAPI.GetFunctionRegistry().GetCustomTypeEncoding(goType.String()) == progpAPI.CustomTypeEncodingMsgPack
+	return m.jsonType(goType, isMsgPack)
+}
+
+var gTsJsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+var gTsTextMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+var gTsTimeType = reflect.TypeOf(time.Time{})
+
+// jsonType returns the TypeScript type of value once encoded
+// as JSON with the rules of the package encoding/json.
+// With isMsgPack, the differences of progpAPI.MarshalMsgPack are applied.
+func (m *tsGroupBuilder) jsonType(goType reflect.Type, isMsgPack bool) string {
+	if goType == gTsTimeType {
+		if isMsgPack {
+			return "Date"
+		}
+
+		// Is encoded as a RFC 3339 string.
+		return "string"
+	}
+
+	if !isMsgPack && (goType.Implements(gTsJsonMarshalerType) || reflect.PointerTo(goType).Implements(gTsJsonMarshalerType)) {
+		// Custom encoding, so we can't know what it is.
+		return "any"
+	}
+
+	if goType.Implements(gTsTextMarshalerType) || reflect.PointerTo(goType).Implements(gTsTextMarshalerType) {
+		return "string"
+	}
+
+	switch goType.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		if isMsgPack {
+			// The values out of the safe integer range are BigInt.
+			return "number | bigint"
+		}
+
+		return "number"
+	case reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Pointer:
+		return m.jsonType(goType.Elem(), isMsgPack) + " | null"
+	case reflect.Slice, reflect.Array:
+		if goType.Elem().Kind() == reflect.Uint8 {
+			if isMsgPack && (goType.Kind() == reflect.Slice) {
+				return "Uint8Array"
+			}
+
+			// Is encoded as a base64 string.
+			return "string"
+		}
+
+		return tsArrayOf(m.jsonType(goType.Elem(), isMsgPack))
+	case reflect.Map:
+		return "Record<string, " + m.jsonType(goType.Elem(), isMsgPack) + ">"
+	case reflect.Struct:
+		return m.declareInterface(goType, isMsgPack)
+	}
+
+	return "any"
+}
+
+func tsArrayOf(tsType string) string {
+	if strings.ContainsAny(tsType, " |") {
+		return "(" + tsType + ")[]"
+	}
+
+	return tsType + "[]"
+}
+
+var gTsIdentifierRegExp = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+func tsPropertyName(name string) string {
+	if gTsIdentifierRegExp.MatchString(name) {
+		return name
+	}
+
+	return strconv.Quote(name)
+}
+
+// tsInterfaceKey identifies an interface. The same struct can have two
+// interfaces when it's used with JSON and with MessagePack.
+type tsInterfaceKey struct {
+	goType    reflect.Type
+	isMsgPack bool
+}
+
+func (m *tsGroupBuilder) declareInterface(goType reflect.Type, isMsgPack bool) string {
+	key := tsInterfaceKey{goType: goType, isMsgPack: isMsgPack}
+
+	if name, ok := m.interfaceMap[key]; ok {
+		return name
+	}
+
+	name := goType.Name()
+
+	if name == "" {
+		// Anonymous struct are inlined.
+		return "{ " + strings.Join(m.structFields(goType, isMsgPack), " ") + " }"
+	}
+
+	// Generic types have names like "Box[int]".
+	name = strings.NewReplacer("[", "_", "]", "", ",", "_", ".", "_", "/", "_", "*", "").Replace(name)
+
+	if m.usedNames[name] {
+		baseName := name
+
+		for i := 2; m.usedNames[name]; i++ {
+			name = baseName + strconv.Itoa(i)
+		}
+	}
+
+	m.usedNames[name] = true
+
+	// Must be set before parsing the fields, which allows recursive types.
+	m.interfaceMap[key] = name
+
+	fields := m.structFields(goType, isMsgPack)
+
+	decl := "\n\n    /** Go type: " + goType.String() + " */"
+	decl += "\n    " + m.exportKeyword() + "interface " + name + " {"
+
+	for _, field := range fields {
+		decl += "\n        " + field
+	}
+
+	decl += "\n    }"
+
+	m.interfaces += decl
+
+	return name
+}
+
+func (m *tsGroupBuilder) structFields(goType reflect.Type, isMsgPack bool) []string {
+	var res []string
+
+	for i := 0; i < goType.NumField(); i++ {
+		field := goType.Field(i)
+		tag := field.Tag.Get("json")
+
+		if tag == "-" {
+			continue
+		}
+
+		tagParts := strings.Split(tag, ",")
+		fieldName := tagParts[0]
+		options := tagParts[1:]
+
+		if field.Anonymous && (fieldName == "") {
+			fieldType := field.Type
+			if fieldType.Kind() == reflect.Pointer {
+				fieldType = fieldType.Elem()
+			}
+
+			// Embedded structs have their fields promoted.
+			if fieldType.Kind() == reflect.Struct {
+				res = append(res, m.structFields(fieldType, isMsgPack)...)
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if fieldName == "" {
+			fieldName = field.Name
+		}
+
+		fieldType := m.jsonType(field.Type, isMsgPack)
+		optional := ""
+
+		for _, option := range options {
+			if option == "omitempty" {
+				optional = "?"
+			} else if (option == "string") && !isMsgPack {
+				fieldType = "string"
+			}
+		}
+
+		res = append(res, tsPropertyName(fieldName)+optional+": "+fieldType+";")
+	}
+
+	return res
+}
+
+//endregion
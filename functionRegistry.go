@@ -18,13 +18,20 @@ package progpAPI
 
 import (
 	"embed"
-	"log"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
 	"path"
 	"reflect"
+	"regexp"
+	"runtime"
 	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unsafe"
 )
 
 var gFunctionRegistry *FunctionRegistry
@@ -33,33 +40,373 @@ var gFunctionRegistry *FunctionRegistry
 
 type RegisteredFunction struct {
 	IsAsync            bool
+	IsPromise          bool
 	Group              string
 	JsFunctionName     string
 	GoFunctionName     string
 	GoFunctionFullName string
 	GoFunctionRef      any
 	GoFunctionInfos    ParsedGoFunction
+
+	// UseBigInt is true if the int64 and uint64 values are exchanged
+	// as javascript BigInt. Otherwise they are numbers, limited to
+	// the range of the integers that a double can safely store.
+	UseBigInt bool
+
+	// ResultNames are the names given to the results of a function returning several
+	// values. When set, javascript receives an object instead of an array.
+	ResultNames []string
+
+	// CallTimeout is the deadline of the context.Context given to the function,
+	// from the start of each call. Zero means no deadline.
+	CallTimeout time.Duration
+
+	// UseAbortSignal is true if javascript can give an AbortSignal,
+	// which cancels the context.Context given to the function.
+	UseAbortSignal bool
+
+	// CallbackValueTypes are the types of the values given to the callback of an async function,
+	// after the error. Set by WithCallbackCaller, they are only used to type the declarations.
+	// Is nil when unknown.
+	CallbackValueTypes []reflect.Type
+
+	// ClassName is the javascript class of a constructor or of a method, see FunctionModule.AddClass.
+	ClassName          string
+	IsClassConstructor bool
+
+	// Capabilities tells what the function can reach, for example "fs.read" or "net.dial".
+	// They are sorted, and allow the security policies to grant capabilities instead of functions.
+	Capabilities []string
+}
+
+// WithBigInt allows exchanging the int64 and uint64 values of this function
+// as javascript BigInt, instead of numbers limited to 53 bits.
+// Can be called on nil, which is returned when the function can't be registered.
+func (m *RegisteredFunction) WithBigInt() *RegisteredFunction {
+	if m != nil {
+		m.UseBigInt = true
+	}
+
+	return m
+}
+
+// WithResultNames gives a name to each result of a function returning several values,
+// without counting the error. Javascript then receives an object with these properties,
+// instead of an array. Can be called on nil, like WithBigInt.
+// A mistake is reported by FunctionRegistry.Validate.
+func (m *RegisteredFunction) WithResultNames(names ...string) *RegisteredFunction {
+	if m == nil {
+		return m
+	}
+
+	resultCount := len(m.GoFunctionInfos.ResultTypes)
+
+	if resultCount == 0 {
+		m.addOptionError("WithResultNames: the function doesn't return several values")
+		return m
+	}
+
+	if len(names) != resultCount {
+		m.addOptionError(fmt.Sprintf("WithResultNames: the function returns %d values but %d names are given", resultCount, len(names)))
+		return m
+	}
+
+	for i, name := range names {
+		if name == "" {
+			m.addOptionError("WithResultNames: a result name is empty")
+			return m
+		}
+
+		if slices.Contains(names[:i], name) {
+			m.addOptionError("WithResultNames: two results are named " + name)
+			return m
+		}
+	}
+
+	m.ResultNames = slices.Clone(names)
+	return m
+}
+
+// WithTimeout sets a deadline to the context.Context received by the function,
+// which is cancelled after this duration from the start of each call.
+// Can be called on nil, like WithBigInt. A mistake is reported by FunctionRegistry.Validate.
+func (m *RegisteredFunction) WithTimeout(timeout time.Duration) *RegisteredFunction {
+	if m == nil {
+		return m
+	}
+
+	if timeout <= 0 {
+		m.addOptionError("WithTimeout: the timeout isn't positive")
+		return m
+	}
+
+	if !slices.Contains(m.GoFunctionInfos.ParamTypeRefs, gGoContextType) {
+		m.addOptionError("WithTimeout: the function doesn't receive a context.Context")
+		return m
+	}
+
+	m.CallTimeout = timeout
+	return m
+}
+
+// WithAbortSignal allows javascript to abort the calls of an async function, or of a function
+// returning a promise, which must receive a context.Context. Javascript gives an AbortSignal,
+// or an object with a signal property, or null. For an async function it's the argument
+// before the callback, otherwise it's an optional argument after the others.
+// Can be called on nil, like WithBigInt. A mistake is reported by FunctionRegistry.Validate.
+func (m *RegisteredFunction) WithAbortSignal() *RegisteredFunction {
+	if m == nil {
+		return m
+	}
+
+	if !m.IsAsync && !m.IsPromise {
+		m.addOptionError("WithAbortSignal: the function isn't async and doesn't return a promise")
+		return m
+	}
+
+	if !slices.Contains(m.GoFunctionInfos.ParamTypeRefs, gGoContextType) {
+		m.addOptionError("WithAbortSignal: the function doesn't receive a context.Context")
+		return m
+	}
+
+	if m.UseAbortSignal {
+		return m
+	}
+
+	m.UseAbortSignal = true
+	m.GoFunctionInfos.MaxArgCount++
+
+	if m.IsAsync {
+		// Is before the callback, which is required.
+		m.GoFunctionInfos.MinArgCount++
+	}
+
+	return m
+}
+
+// WithCallbackCaller tells which values an async function gives to his callback, after the error,
+// with the type of the function caller used to call it. It's the form given to
+// codegen.AddFunctionCallerToGenerate, whose first value is here the error, for example
+// reflect.TypeOf(func(progpAPI.JsFunction, error, string, int) {}) for a callback receiving
+// (error, string, number). The typescript declarations then type the callback with these values.
+// Can be called on nil, like WithBigInt. A mistake is reported by FunctionRegistry.Validate.
+func (m *RegisteredFunction) WithCallbackCaller(callerType reflect.Type) *RegisteredFunction {
+	if m == nil {
+		return m
+	}
+
+	if !m.IsAsync {
+		m.addOptionError("WithCallbackCaller: the function isn't async")
+		return m
+	}
+
+	if (callerType == nil) || (callerType.Kind() != reflect.Func) {
+		m.addOptionError("WithCallbackCaller: the caller type isn't a function type")
+		return m
+	}
+
+	// Can start with the interface receiving the caller, like for codegen.AddFunctionCallerToGenerate.
+	jsFunctionOffset := -1
+
+	for i := 0; (i < callerType.NumIn()) && (i < 2); i++ {
+		if callerType.In(i) == gJsFunctionType {
+			jsFunctionOffset = i
+			break
+		}
+	}
+
+	if jsFunctionOffset == -1 {
+		m.addOptionError("WithCallbackCaller: the caller type doesn't receive a progpAPI.JsFunction")
+		return m
+	}
+
+	if (callerType.NumIn() <= jsFunctionOffset+1) || (callerType.In(jsFunctionOffset+1) != gErrorType) {
+		m.addOptionError("WithCallbackCaller: the first value given to the callback isn't an error")
+		return m
+	}
+
+	if callerType.IsVariadic() {
+		m.addOptionError("WithCallbackCaller: the caller type is variadic")
+		return m
+	}
+
+	// Isn't nil, even when the callback only receives the error.
+	valueTypes := []reflect.Type{}
+
+	for i := jsFunctionOffset + 2; i < callerType.NumIn(); i++ {
+		valueTypes = append(valueTypes, callerType.In(i))
+	}
+
+	m.CallbackValueTypes = valueTypes
+	return m
+}
+
+// WithCapabilities tags the function with capabilities, like "fs.read", "net.dial" or "process.spawn".
+// They are written into the binding manifest and the typescript declarations, and can be used
+// by a SecurityPolicy. Can be called more than once, and on nil like WithBigInt.
+// An invalid capability is ignored and reported by FunctionRegistry.Validate.
+//
+// A method can't have his own capabilities, since he is allowed with his class,
+// see RegisteredClass.WithCapabilities.
+func (m *RegisteredFunction) WithCapabilities(capabilities ...string) *RegisteredFunction {
+	if m == nil {
+		return m
+	}
+
+	if m.IsClassMethod() {
+		m.addOptionError("WithCapabilities: the method " + m.JsFunctionName + " is allowed with his class, use RegisteredClass.WithCapabilities")
+		return m
+	}
+
+	for _, capability := range capabilities {
+		if !isValidCapability(capability) {
+			m.addOptionError("WithCapabilities: the capability \"" + capability + "\" is invalid")
+			continue
+		}
+
+		if !slices.Contains(m.Capabilities, capability) {
+			m.Capabilities = append(m.Capabilities, capability)
+		}
+	}
+
+	sort.Strings(m.Capabilities)
+	return m
+}
+
+// CanBeDelayed returns true if a call can wait before calling the Go function without
+// blocking javascript. It's the case of the functions returning a promise, and of the
+// async functions which only return an error and don't receive an unsafe.Pointer,
+// since they are then called from a goroutine.
+func (m *RegisteredFunction) CanBeDelayed() bool {
+	if m.IsPromise {
+		return true
+	}
+
+	infos := m.GoFunctionInfos
+
+	if !m.IsAsync || (infos.ReturnType != "") || (len(infos.ResultTypes) != 0) {
+		return false
+	}
+
+	return !slices.Contains(infos.ParamTypeRefs, reflect.TypeOf(unsafe.Pointer(nil)))
+}
+
+// HasCapability returns true if the function is tagged with this capability.
+func (m *RegisteredFunction) HasCapability(capability string) bool {
+	return slices.Contains(m.Capabilities, capability)
+}
+
+// isValidCapability returns true if the capability isn't empty and is made
+// of letters, digits and the characters '.', '_' and '-'.
+func isValidCapability(capability string) bool {
+	if capability == "" {
+		return false
+	}
+
+	for _, c := range capability {
+		isLetter := ((c >= 'a') && (c <= 'z')) || ((c >= 'A') && (c <= 'Z'))
+		isDigit := (c >= '0') && (c <= '9')
+
+		if !isLetter && !isDigit && (c != '.') && (c != '_') && (c != '-') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// addOptionError records an option which can't be applied to the function.
+func (m *RegisteredFunction) addOptionError(message string) {
+	GetFunctionRegistry().addRegistrationError(m, RegistrationErrorInvalidOption, message)
+}
+
+//endregion
+
+//region RegistrationError
+
+type RegistrationErrorKind string
+
+const (
+	RegistrationErrorNotAFunction      RegistrationErrorKind = "notAFunction"
+	RegistrationErrorAsyncSuffix       RegistrationErrorKind = "asyncSuffix"
+	RegistrationErrorUnsupportedReturn RegistrationErrorKind = "unsupportedReturn"
+	RegistrationErrorUnsupportedParam  RegistrationErrorKind = "unsupportedParam"
+	RegistrationErrorDuplicateJsName   RegistrationErrorKind = "duplicateJsName"
+
+	// RegistrationErrorInvalidOption is an option, like WithResultNames, which
+	// can't be applied to the function. The option is then ignored.
+	RegistrationErrorInvalidOption RegistrationErrorKind = "invalidOption"
+)
+
+// RegistrationError describes a problem found while registering a function.
+// The registry doesn't stop on theses errors, it collects them in order
+// to allow checking them with FunctionRegistry.Validate.
+type RegistrationError struct {
+	Kind           RegistrationErrorKind
+	Group          string
+	JsFunctionName string
+	GoFunctionName string
+	Message        string
+
+	// ConflictsWith is the Go function already registered
+	// with the same group and javascript name, if any.
+	ConflictsWith string
+}
+
+func (m *RegistrationError) Error() string {
+	if m.JsFunctionName == "" {
+		return fmt.Sprintf("function %s: %s", m.GoFunctionName, m.Message)
+	}
+
+	if m.ConflictsWith != "" {
+		return fmt.Sprintf("function %s.%s (%s): %s (already bound to %s)", m.Group, m.JsFunctionName, m.GoFunctionName, m.Message, m.ConflictsWith)
+	}
+
+	return fmt.Sprintf("function %s.%s (%s): %s", m.Group, m.JsFunctionName, m.GoFunctionName, m.Message)
 }
 
 //endregion
 
 //region FunctionRegistry
 
+// functionKey identifies a function. The same javascript name
+// can be used in more than one group without conflict.
+type functionKey struct {
+	group          string
+	jsFunctionName string
+}
+
 type FunctionRegistry struct {
-	modules           map[string]bool
-	functionsArray    []*RegisteredFunction
-	functionsMap      map[string]*RegisteredFunction
-	extraGoNamespaces []string
-	jsModulesTSX      map[string]EmbeddedFile
-	useDynamicMode    bool
+	modules            map[string]bool
+	functionsArray     []*RegisteredFunction
+	functionsMap       map[functionKey]*RegisteredFunction
+	functionsByJsName  map[string]*RegisteredFunction
+	extraGoNamespaces  []string
+	jsModulesTSX       map[string]EmbeddedFile
+	useDynamicMode     bool
+	registrationErrors []RegistrationError
+	functionCallers    []string
+	uniqNames          map[string]bool
+
+	defaultCustomTypeEncoding CustomTypeEncoding
+	customTypeEncodings       map[string]CustomTypeEncoding
+
+	classesByType map[reflect.Type]*RegisteredClass
 }
 
 func GetFunctionRegistry() *FunctionRegistry {
 	if gFunctionRegistry == nil {
 		gFunctionRegistry = &FunctionRegistry{
-			modules:      make(map[string]bool),
-			jsModulesTSX: make(map[string]EmbeddedFile),
-			functionsMap: make(map[string]*RegisteredFunction),
+			modules:           make(map[string]bool),
+			jsModulesTSX:      make(map[string]EmbeddedFile),
+			functionsMap:      make(map[functionKey]*RegisteredFunction),
+			functionsByJsName: make(map[string]*RegisteredFunction),
+			uniqNames:         make(map[string]bool),
+
+			defaultCustomTypeEncoding: CustomTypeEncodingJson,
+			customTypeEncodings:       make(map[string]CustomTypeEncoding),
+
+			classesByType: make(map[reflect.Type]*RegisteredClass),
 		}
 	}
 
@@ -102,9 +449,12 @@ func (m *FunctionRegistry) declareModuleAsNotEmpty(modName string) {
 	m.modules[modName] = true
 }
 
-func (m *FunctionRegistry) addFunction(isAsync bool, group string, jsFunctionName string, goFunctionName string, goFunctionRef any) {
+func (m *FunctionRegistry) addFunction(kind functionKind, group string, jsFunctionName string, goFunctionName string, goFunctionRef any) *RegisteredFunction {
+	isAsync := kind == functionKindAsync
+
 	fct := &RegisteredFunction{
 		IsAsync:            isAsync,
+		IsPromise:          kind == functionKindPromise,
 		Group:              group,
 		JsFunctionName:     jsFunctionName,
 		GoFunctionName:     goFunctionName,
@@ -112,15 +462,104 @@ func (m *FunctionRegistry) addFunction(isAsync bool, group string, jsFunctionNam
 		GoFunctionRef:      goFunctionRef,
 	}
 
+	hasError := false
+
 	parsed, err := ParseGoFunction(fct)
 
 	if err != nil {
-		log.Fatal("Error when parsing function " + goFunctionName + ".\nMessage: " + err.Error())
+		hasError = true
+		kind := RegistrationErrorUnsupportedReturn
+
+		var regErr *RegistrationError
+		if errors.As(err, &regErr) {
+			kind = regErr.Kind
+			err = errors.New(regErr.Message)
+		}
+
+		m.addRegistrationError(fct, kind, err.Error())
 	}
 
+	// Parameters are parsed even if the return type is invalid,
+	// which allows reporting all the problems at once.
+	//
+	for offset, paramType := range parsed.ParamTypeRefs {
+		if reason := getUnsupportedParamTypeReason(paramType); reason != "" {
+			hasError = true
+			m.addRegistrationError(fct, RegistrationErrorUnsupportedParam,
+				fmt.Sprintf("parameter %d of type %s isn't supported, %s", offset+1, paramType.String(), reason))
+		}
+	}
+
+	if isAsync && parsed.IsVariadic {
+		hasError = true
+		m.addRegistrationError(fct, RegistrationErrorUnsupportedParam,
+			"an async function can't be variadic, since the callback must be the last parameter")
+	}
+
+	if fct.IsPromise && parsed.IsVariadic {
+		hasError = true
+		m.addRegistrationError(fct, RegistrationErrorUnsupportedParam,
+			"a function returning a promise can't be variadic")
+	}
+
+	key := functionKey{group: group, jsFunctionName: jsFunctionName}
+
+	if existing, exists := m.functionsMap[key]; exists {
+		hasError = true
+
+		m.registrationErrors = append(m.registrationErrors, RegistrationError{
+			Kind:           RegistrationErrorDuplicateJsName,
+			Group:          fct.Group,
+			JsFunctionName: fct.JsFunctionName,
+			GoFunctionName: fct.GoFunctionName,
+			Message:        "a function with the same javascript name is already registered in this group (" + getGoFunctionOrigin(fct.GoFunctionRef) + ")",
+			ConflictsWith:  existing.GoFunctionName + " (" + getGoFunctionOrigin(existing.GoFunctionRef) + ")",
+		})
+	}
+
+	if hasError {
+		return nil
+	}
+
+	if m.uniqNames[parsed.GeneratorUniqName] {
+		parsed.GeneratorUniqName = NewGeneratorUniqName(group, jsFunctionName, fct.GoFunctionFullName, true)
+
+		for i := 2; m.uniqNames[parsed.GeneratorUniqName]; i++ {
+			parsed.GeneratorUniqName = NewGeneratorUniqName(group, jsFunctionName, fct.GoFunctionFullName, true) + "_" + strconv.Itoa(i)
+		}
+	}
+
+	m.uniqNames[parsed.GeneratorUniqName] = true
+
 	fct.GoFunctionInfos = parsed
 	m.functionsArray = append(m.functionsArray, fct)
-	m.functionsMap[fct.JsFunctionName] = fct
+	m.functionsMap[key] = fct
+
+	// The global group has priority since his functions are
+	// the ones directly visible to scripts.
+	//
+	if previous, exists := m.functionsByJsName[jsFunctionName]; !exists || ((group == "global") && (previous.Group != "global")) {
+		m.functionsByJsName[jsFunctionName] = fct
+	}
+
+	return fct
+}
+
+func (m *FunctionRegistry) addRegistrationError(fct *RegisteredFunction, kind RegistrationErrorKind, message string) {
+	m.registrationErrors = append(m.registrationErrors, RegistrationError{
+		Kind:           kind,
+		Group:          fct.Group,
+		JsFunctionName: fct.JsFunctionName,
+		GoFunctionName: fct.GoFunctionName,
+		Message:        message,
+	})
+}
+
+// Validate returns all the errors found while registering the functions.
+// Functions having an error aren't registered, so the list is empty
+// if all the functions are correctly exposed to javascript.
+func (m *FunctionRegistry) Validate() []RegistrationError {
+	return slices.Clone(m.registrationErrors)
 }
 
 func (m *FunctionRegistry) GetAllFunctions(sortList bool) []*RegisteredFunction {
@@ -158,14 +597,90 @@ func (m *FunctionRegistry) GetEmbeddedModulesTSX() map[string]EmbeddedFile {
 	return m.jsModulesTSX
 }
 
+// GetRefToFunction returns a function from his javascript name only.
+// If more than one group has a function with this name, then the function
+// of the global group is returned, otherwise the first function registered.
+//
+// Deprecated: use GetFunction which avoids ambiguities between groups.
 func (m *FunctionRegistry) GetRefToFunction(jsFunctionName string) *RegisteredFunction {
-	v, ok := m.functionsMap[jsFunctionName]
+	v, ok := m.functionsByJsName[jsFunctionName]
+	if ok {
+		return v
+	}
+	return nil
+}
+
+// GetFunction returns the function registered in this group with this javascript name,
+// or nil if not found. Use the group "global" for the globally visible functions.
+func (m *FunctionRegistry) GetFunction(group string, jsFunctionName string) *RegisteredFunction {
+	v, ok := m.functionsMap[functionKey{group: group, jsFunctionName: jsFunctionName}]
 	if ok {
 		return v
 	}
 	return nil
 }
 
+// AddFunctionCallerSignature declares a function caller which must be generated.
+// It allows the binding manifest to know which function callers are required.
+func (m *FunctionRegistry) AddFunctionCallerSignature(signature string) {
+	if !slices.Contains(m.functionCallers, signature) {
+		m.functionCallers = append(m.functionCallers, signature)
+	}
+}
+
+func (m *FunctionRegistry) GetFunctionCallerSignatures() []string {
+	return slices.Clone(m.functionCallers)
+}
+
+// BuildBindingManifest returns the manifest of the functions
+// and function callers currently registered.
+func (m *FunctionRegistry) BuildBindingManifest() *BindingManifest {
+	res := NewBindingManifest(m.functionsArray, m.functionCallers)
+
+	// The encodings change the generated code, so they must be part of the hash.
+	if m.defaultCustomTypeEncoding != CustomTypeEncodingJson {
+		res.DefaultCustomTypeEncoding = m.defaultCustomTypeEncoding
+	}
+
+	if len(m.customTypeEncodings) != 0 {
+		res.CustomTypeEncodings = maps.Clone(m.customTypeEncodings)
+	}
+
+	res.Hash = res.computeHash()
+
+	return res
+}
+
+// SetDefaultCustomTypeEncoding selects how the values without a dedicated
+// type handler, like the structs, are exchanged with javascript.
+// It's CustomTypeEncodingJson by default.
+func (m *FunctionRegistry) SetDefaultCustomTypeEncoding(encoding CustomTypeEncoding) {
+	checkCustomTypeEncoding(encoding)
+	m.defaultCustomTypeEncoding = encoding
+}
+
+// SetCustomTypeEncoding selects the encoding of one type, which has priority
+// over the default encoding. A pointer to this type uses the same encoding.
+// It must be called before generating the bindings.
+func (m *FunctionRegistry) SetCustomTypeEncoding(goType reflect.Type, encoding CustomTypeEncoding) {
+	checkCustomTypeEncoding(encoding)
+	m.customTypeEncodings[goType.String()] = encoding
+}
+
+// GetCustomTypeEncoding returns the encoding used for a type,
+// from his name as given by reflect.Type.String().
+func (m *FunctionRegistry) GetCustomTypeEncoding(goTypeName string) CustomTypeEncoding {
+	if encoding, ok := m.customTypeEncodings[goTypeName]; ok {
+		return encoding
+	}
+
+	if encoding, ok := m.customTypeEncodings[strings.TrimPrefix(goTypeName, "*")]; ok {
+		return encoding
+	}
+
+	return m.defaultCustomTypeEncoding
+}
+
 func (m *FunctionRegistry) EnableDynamicMode(enabled bool) {
 	m.useDynamicMode = enabled
 }
@@ -176,6 +691,29 @@ func (m *FunctionRegistry) IsUsingDynamicMode() bool {
 
 //endregion
 
+//region CustomTypeEncoding
+
+// CustomTypeEncoding is the format used to exchange the values
+// which haven't a dedicated type handler, like the structs.
+type CustomTypeEncoding string
+
+const (
+	// CustomTypeEncodingJson uses encoding/json. It's the default.
+	CustomTypeEncodingJson CustomTypeEncoding = "json"
+
+	// CustomTypeEncodingMsgPack uses MessagePack, which is faster and more compact.
+	// See MarshalMsgPack for the differences with JSON.
+	CustomTypeEncodingMsgPack CustomTypeEncoding = "msgpack"
+)
+
+func checkCustomTypeEncoding(encoding CustomTypeEncoding) {
+	if (encoding != CustomTypeEncodingJson) && (encoding != CustomTypeEncodingMsgPack) {
+		panic("progpAPI: unknown custom type encoding " + strconv.Quote(string(encoding)))
+	}
+}
+
+//endregion
+
 //region FunctionGroup
 
 type FunctionGroup struct {
@@ -183,12 +721,17 @@ type FunctionGroup struct {
 	goModule    *FunctionModule
 }
 
-func (m *FunctionGroup) AddFunction(javascriptName string, goFunctionName string, goFunctionRef any) {
-	m.goModule.addFunction(false, m.jsGroupName, javascriptName, goFunctionName, goFunctionRef)
+func (m *FunctionGroup) AddFunction(javascriptName string, goFunctionName string, goFunctionRef any) *RegisteredFunction {
+	return m.goModule.addFunction(functionKindSync, m.jsGroupName, javascriptName, goFunctionName, goFunctionRef)
+}
+
+func (m *FunctionGroup) AddAsyncFunction(jsName string, goFunctionName string, jsFunction any) *RegisteredFunction {
+	return m.goModule.addFunction(functionKindAsync, m.jsGroupName, jsName, goFunctionName, jsFunction)
 }
 
-func (m *FunctionGroup) AddAsyncFunction(jsName string, goFunctionName string, jsFunction any) {
-	m.goModule.addFunction(true, m.jsGroupName, jsName, goFunctionName, jsFunction)
+// AddPromiseFunction is like FunctionModule.AddPromiseFunction but for this group.
+func (m *FunctionGroup) AddPromiseFunction(jsName string, goFunctionName string, goFunctionRef any) *RegisteredFunction {
+	return m.goModule.addFunction(functionKindPromise, m.jsGroupName, jsName, goFunctionName, goFunctionRef)
 }
 
 //endregion
@@ -209,14 +752,23 @@ func (m *FunctionModule) ModName() string {
 
 // AddFunction add a function to a javascript group
 // which name is the name of the go namespace last part.
-func (m *FunctionModule) AddFunction(javascriptName string, goFunctionName string, goFunctionRef any) {
-	m.addFunction(false, m.moduleName, javascriptName, goFunctionName, goFunctionRef)
+// Returns nil if the function can't be registered, see FunctionRegistry.Validate.
+func (m *FunctionModule) AddFunction(javascriptName string, goFunctionName string, goFunctionRef any) *RegisteredFunction {
+	return m.addFunction(functionKindSync, m.moduleName, javascriptName, goFunctionName, goFunctionRef)
 }
 
 // AddAsyncFunction add an async function to a javascript group
 // which name is the name of the go namespace last part.
-func (m *FunctionModule) AddAsyncFunction(jsName string, goFunctionName string, jsFunction any) {
-	m.addFunction(true, m.moduleName, jsName, goFunctionName, jsFunction)
+func (m *FunctionModule) AddAsyncFunction(jsName string, goFunctionName string, jsFunction any) *RegisteredFunction {
+	return m.addFunction(functionKindAsync, m.moduleName, jsName, goFunctionName, jsFunction)
+}
+
+// AddPromiseFunction add a function which javascript sees as returning a Promise.
+// Unlike AddAsyncFunction, the Go function is a plain function, like func(p string) (T, error),
+// which is executed in a goroutine. The promise is resolved with his result, or rejected
+// with his error. Since it's a plain function, his name must not end with 'Async'.
+func (m *FunctionModule) AddPromiseFunction(jsName string, goFunctionName string, goFunctionRef any) *RegisteredFunction {
+	return m.addFunction(functionKindPromise, m.moduleName, jsName, goFunctionName, goFunctionRef)
 }
 
 func (m *FunctionModule) GetFunctionRegistry() *FunctionRegistry {
@@ -233,7 +785,16 @@ func (m *FunctionModule) UseCustomGroup(jsGroupName string) *FunctionGroup {
 // where functionsArray directly accessible to javascript scripts without importing them.
 func (m *FunctionModule) UseGroupGlobal() *FunctionGroup { return m.UseCustomGroup("global") }
 
-func (m *FunctionModule) addFunction(isAsync bool, groupName string, javascriptName string, goFunctionName string, goFunctionRef any) {
+// functionKind tells how a function is called by javascript.
+type functionKind int
+
+const (
+	functionKindSync functionKind = iota
+	functionKindAsync
+	functionKindPromise
+)
+
+func (m *FunctionModule) addFunction(kind functionKind, groupName string, javascriptName string, goFunctionName string, goFunctionRef any) *RegisteredFunction {
 	if groupName == "" {
 		groupName = "global"
 	}
@@ -241,22 +802,36 @@ func (m *FunctionModule) addFunction(isAsync bool, groupName string, javascriptN
 	goFunctionName = m.moduleName + "." + goFunctionName
 	endsWithAsync := strings.HasSuffix(goFunctionName, "Async")
 
-	if isAsync {
+	suffixError := ""
+
+	if kind == functionKindAsync {
 		if !endsWithAsync {
-			log.Fatalf("Go function `%s` is asynchrone and MUST ends with 'Async'", goFunctionName)
+			suffixError = "function is asynchrone and MUST ends with 'Async'"
 		}
 	} else {
 		if endsWithAsync {
-			log.Fatalf("Go function `%s` is NOT asynchrone and MUST NOT ends with 'Async'", goFunctionName)
+			suffixError = "function is NOT asynchrone and MUST NOT ends with 'Async'"
 		}
 	}
 
+	if suffixError != "" {
+		m.functionRegistry.registrationErrors = append(m.functionRegistry.registrationErrors, RegistrationError{
+			Kind:           RegistrationErrorAsyncSuffix,
+			Group:          groupName,
+			JsFunctionName: javascriptName,
+			GoFunctionName: goFunctionName,
+			Message:        suffixError,
+		})
+
+		return nil
+	}
+
 	if !m.isModuleInjected {
 		m.isModuleInjected = true
 		m.functionRegistry.declareModuleAsNotEmpty(m.moduleName)
 	}
 
-	m.functionRegistry.addFunction(isAsync, groupName, javascriptName, goFunctionName, goFunctionRef)
+	return m.functionRegistry.addFunction(kind, groupName, javascriptName, goFunctionName, goFunctionRef)
 }
 
 func (m *FunctionModule) DeclareNodeModule(embedded embed.FS, embeddedDirPath string, modName string) {
@@ -288,9 +863,29 @@ type ParsedGoFunction struct {
 	ParamTypeRefs       []reflect.Type
 	CallParamNamespaces []string
 
+	// ParamNullable tells, for each parameter, if javascript can give null
+	// or undefined. See GetNullableParamValueType.
+	ParamNullable []bool
+
+	// IsVariadic is true if the last parameter is a variadic one, which
+	// receives all the remaining arguments given by javascript.
+	IsVariadic bool
+
+	// MinArgCount and MaxArgCount are the number of arguments javascript can give.
+	// The nullable parameters can be omitted when they are the last ones.
+	// MaxArgCount is -1 for a variadic function.
+	MinArgCount int
+	MaxArgCount int
+
 	ReturnType        string
+	ReturnTypeRef     reflect.Type
 	ReturnErrorOffset int
 
+	// ResultTypes are the types of the results of a function returning
+	// several values, without the error. ReturnType is empty in this case.
+	ResultTypes    []string
+	ResultTypeRefs []reflect.Type
+
 	JsFunctionName string
 	JsGroupName    string
 }
@@ -305,20 +900,69 @@ func (m *ParsedGoFunction) GetGoFunctionName() string {
 
 //endregion
 
-var gNextGoFunctionId = 0
-
 func ParseGoFunction(fct *RegisteredFunction) (ParsedGoFunction, error) {
 	reflectFct := reflect.TypeOf(fct.GoFunctionRef)
-	return ParseGoFunctionReflect(reflectFct, fct.GoFunctionFullName)
+
+	if (reflectFct == nil) || (reflectFct.Kind() != reflect.Func) {
+		return ParsedGoFunction{}, &RegistrationError{
+			Kind:           RegistrationErrorNotAFunction,
+			GoFunctionName: fct.GoFunctionFullName,
+			Message:        "the value given isn't a function",
+		}
+	}
+
+	res, err := ParseGoFunctionReflect(reflectFct, fct.GoFunctionFullName)
+	res.GeneratorUniqName = NewGeneratorUniqName(fct.Group, fct.JsFunctionName, fct.GoFunctionFullName, false)
+
+	return res, err
 }
 
+var gCIdentifierInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// NewGeneratorUniqName returns the name used by the code generator to build
+// the C++ and Go symbols of a function. It only depends on the function itself,
+// which allows adding a function without changing the glue of the others.
+// The name ends with a hash which protects against collisions after sanitizing.
+// Use longHash when the short version is already used by another function.
+func NewGeneratorUniqName(group string, jsFunctionName string, goFunctionFullName string, longHash bool) string {
+	toHash := group + "\x00" + jsFunctionName + "\x00" + goFunctionFullName
+	var hash string
+
+	if longHash {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(toHash))
+		hash = fmt.Sprintf("%016x", h.Sum64())
+	} else {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(toHash))
+		hash = fmt.Sprintf("%08x", h.Sum32())
+	}
+
+	group = gCIdentifierInvalidChars.ReplaceAllString(group, "_")
+	jsFunctionName = gCIdentifierInvalidChars.ReplaceAllString(jsFunctionName, "_")
+
+	return "g_" + group + "_f_" + jsFunctionName + "_" + hash
+}
+
+// ParseGoFunctionReflect extracts the information required by the code generator.
+// The error returned, if any, is a *RegistrationError.
 func ParseGoFunctionReflect(reflectFct reflect.Type, goFunctionFullName string) (ParsedGoFunction, error) {
 	res := ParsedGoFunction{ReturnErrorOffset: -1}
 
+	newReturnError := func(message string) error {
+		return &RegistrationError{
+			Kind:           RegistrationErrorUnsupportedReturn,
+			GoFunctionName: goFunctionFullName,
+			Message:        message,
+		}
+	}
+
 	// > Parse parameters
 
 	inCount := reflectFct.NumIn()
 	res.ParamTypeRefs = make([]reflect.Type, inCount)
+	res.ParamNullable = make([]bool, inCount)
+	res.IsVariadic = reflectFct.IsVariadic()
 
 	for i := 0; i < inCount; i++ {
 		param := reflectFct.In(i)
@@ -326,8 +970,32 @@ func ParseGoFunctionReflect(reflectFct reflect.Type, goFunctionFullName string)
 		res.ParamTypes = append(res.ParamTypes, paramTypeName)
 		res.ParamTypeRefs[i] = param
 
+		if res.IsVariadic && (i == inCount-1) {
+			// Can receive any number of arguments, including none.
+			res.MaxArgCount = -1
+		} else if !isImplicitParamType(param) {
+			res.MaxArgCount++
+			res.ParamNullable[i] = GetNullableParamValueType(param) != nil
+
+			if !res.ParamNullable[i] {
+				// Only the nullable parameters after the last required one can be omitted.
+				res.MinArgCount = res.MaxArgCount
+			}
+		}
+
 		// > Extract namespace
 
+		// Implicit parameters are created by the generated code,
+		// which imports what it needs.
+		if isImplicitParamType(param) {
+			continue
+		}
+
+		// Optional is in progpAPI namespace, which is always imported.
+		if IsOptionalType(param) {
+			param = param.Field(0).Type
+		}
+
 		// If pointer then take the target type.
 		for {
 			kind := param.Kind()
@@ -354,11 +1022,13 @@ func ParseGoFunctionReflect(reflectFct reflect.Type, goFunctionFullName string)
 
 	outCount := reflectFct.NumOut()
 	var returnTypes []string
+	var returnTypeRefs []reflect.Type
 
 	for i := 0; i < outCount; i++ {
 		outParam := reflectFct.Out(i)
 		outType := outParam.String()
 		returnTypes = append(returnTypes, outType)
+		returnTypeRefs = append(returnTypeRefs, outParam)
 	}
 
 	if outCount >= 1 {
@@ -368,34 +1038,94 @@ func ParseGoFunctionReflect(reflectFct reflect.Type, goFunctionFullName string)
 				returnTypes = nil
 			} else {
 				res.ReturnType = returnTypes[0]
+				res.ReturnTypeRef = returnTypeRefs[0]
 			}
 		} else if outCount == 2 {
 			if returnTypes[0] == "error" {
 				res.ReturnErrorOffset = 0
 				returnTypes = returnTypes[1:]
+				returnTypeRefs = returnTypeRefs[1:]
 
 				if returnTypes[0] == "error" {
-					log.Fatalf("Function %s can return (error, error)", goFunctionFullName)
+					return res, newReturnError("can't return (error, error)")
 				}
 			} else if returnTypes[1] == "error" {
 				res.ReturnErrorOffset = 1
 				returnTypes = returnTypes[0:1]
+				returnTypeRefs = returnTypeRefs[0:1]
 			} else {
-				log.Fatalf("Function %s has more than 1 return type", goFunctionFullName)
+				res.ResultTypes = returnTypes
+				res.ResultTypeRefs = returnTypeRefs
+				return res, nil
 			}
 
 			res.ReturnType = returnTypes[0]
+			res.ReturnTypeRef = returnTypeRefs[0]
 		} else {
-			log.Fatalf("Function %s has more than 1 return type", goFunctionFullName)
+			// Several values, with an optional error which must be the last one.
+			if returnTypes[outCount-1] == "error" {
+				res.ReturnErrorOffset = outCount - 1
+				returnTypes = returnTypes[:outCount-1]
+				returnTypeRefs = returnTypeRefs[:outCount-1]
+			}
+
+			if slices.Contains(returnTypes, "error") {
+				return res, newReturnError("when returning several values, the error must be the last one")
+			}
+
+			res.ResultTypes = returnTypes
+			res.ResultTypeRefs = returnTypeRefs
 		}
 	}
 
-	gNextGoFunctionId++
-	res.GeneratorUniqName = strconv.Itoa(gNextGoFunctionId)
-
 	return res, nil
 }
 
+// getGoFunctionOrigin returns the full name of a Go function, including his package path.
+// It allows knowing where a function comes from when two modules are in conflict.
+func getGoFunctionOrigin(goFunctionRef any) string {
+	value := reflect.ValueOf(goFunctionRef)
+
+	if value.Kind() == reflect.Func {
+		if rtFct := runtime.FuncForPC(value.Pointer()); rtFct != nil {
+			return rtFct.Name()
+		}
+	}
+
+	return "unknown origin"
+}
+
+var gJsFunctionType = reflect.TypeOf((*JsFunction)(nil)).Elem()
+
+// getUnsupportedParamTypeReason returns why a parameter type can't be
+// received from javascript, or an empty string if the type is supported.
+func getUnsupportedParamTypeReason(paramType reflect.Type) string {
+	if IsOptionalType(paramType) {
+		paramType = paramType.Field(0).Type
+	}
+
+	for {
+		switch paramType.Kind() {
+		case reflect.Chan:
+			return "channels can't be received from javascript"
+		case reflect.Func:
+			return "functions must be received as progpAPI.JsFunction"
+		case reflect.Complex64, reflect.Complex128:
+			return "complex numbers can't be received from javascript"
+		case reflect.Interface:
+			if (paramType == gJsFunctionType) || (paramType == gGoContextType) || (paramType.NumMethod() == 0) {
+				return ""
+			}
+
+			return "only progpAPI.JsFunction, context.Context and empty interfaces are allowed"
+		case reflect.Pointer, reflect.Array, reflect.Slice, reflect.Map:
+			paramType = paramType.Elem()
+		default:
+			return ""
+		}
+	}
+}
+
 // StringBuffer allows the code generator to known that we want this bytes
 // to be send as if it was a string. Allows to avoid the cost of converting
 // []byte to string before calling javascript.
@@ -0,0 +1,171 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package progpAPI
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// The Go functions called by javascript can receive a context.Context, which is
+// given by the generated code without consuming a javascript argument.
+// This context is cancelled when the script context is disposed or when the engine
+// shuts down, which allows long-running functions to stop when the script goes away.
+//
+// Since the engines are implemented outside of this module, they must call
+// OnScriptContextDisposed from JsContext.TryDispose and OnScriptEngineShutdown
+// from ScriptEngine.Shutdown.
+
+type cancellableGoContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var gEngineGoContexts = make(map[ScriptEngine]*cancellableGoContext)
+var gScriptGoContexts = make(map[JsContext]*cancellableGoContext)
+var gShutdownEngines = make(map[ScriptEngine]bool)
+var gGoContextsMutex sync.Mutex
+
+// gCancelledGoContext is given to the scripts of an engine which is shut down.
+var gCancelledGoContext = newCancelledGoContext()
+
+func newCancelledGoContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+// GetScriptGoContext returns the context.Context associated to a script context.
+// It's cancelled when the script context is disposed or when his engine shuts down.
+// Once the engine is shut down, the returned context is already cancelled.
+func GetScriptGoContext(jsCtx JsContext) context.Context {
+	if jsCtx == nil {
+		return context.Background()
+	}
+
+	gGoContextsMutex.Lock()
+	defer gGoContextsMutex.Unlock()
+
+	if entry := gScriptGoContexts[jsCtx]; entry != nil {
+		return entry.ctx
+	}
+
+	parent := context.Background()
+
+	if engine := jsCtx.GetScriptEngine(); engine != nil {
+		if gShutdownEngines[engine] {
+			return gCancelledGoContext
+		}
+
+		parent = getEngineGoContext(engine)
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	gScriptGoContexts[jsCtx] = &cancellableGoContext{ctx: ctx, cancel: cancel}
+
+	return ctx
+}
+
+// lookupScriptGoContext is like GetScriptGoContext, but doesn't create the context
+// of the script, since it's only removed once the script context is disposed.
+// Without one, the context of the engine is returned if it already exists.
+func lookupScriptGoContext(jsCtx JsContext) context.Context {
+	if jsCtx == nil {
+		return context.Background()
+	}
+
+	gGoContextsMutex.Lock()
+	defer gGoContextsMutex.Unlock()
+
+	if entry := gScriptGoContexts[jsCtx]; entry != nil {
+		return entry.ctx
+	}
+
+	if engine := jsCtx.GetScriptEngine(); engine != nil {
+		if gShutdownEngines[engine] {
+			return gCancelledGoContext
+		}
+
+		if entry := gEngineGoContexts[engine]; entry != nil {
+			return entry.ctx
+		}
+	}
+
+	return context.Background()
+}
+
+// getEngineGoContext returns the parent of the contexts of the scripts executed by this engine.
+// The caller must lock gGoContextsMutex.
+func getEngineGoContext(engine ScriptEngine) context.Context {
+	if entry := gEngineGoContexts[engine]; entry != nil {
+		return entry.ctx
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	gEngineGoContexts[engine] = &cancellableGoContext{ctx: ctx, cancel: cancel}
+
+	return ctx
+}
+
+// NewCallContext returns the context.Context given to a Go function called by javascript.
+// It's the context of the script, with a deadline if timeout isn't zero.
+// The cancel function releases the resources of the deadline once the call ends.
+func NewCallContext(jsCtx JsContext, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx := GetScriptGoContext(jsCtx)
+
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// OnScriptContextDisposed cancels the context.Context of a script context.
+// Must be called by the engine when the script context is disposed.
+func OnScriptContextDisposed(jsCtx JsContext) {
+	gGoContextsMutex.Lock()
+	entry := gScriptGoContexts[jsCtx]
+	delete(gScriptGoContexts, jsCtx)
+	gGoContextsMutex.Unlock()
+
+	if entry != nil {
+		entry.cancel()
+	}
+}
+
+// OnScriptEngineShutdown cancels the context.Context of all the scripts executed by this engine.
+// Must be called by the engine when he shuts down.
+func OnScriptEngineShutdown(engine ScriptEngine) {
+	gGoContextsMutex.Lock()
+	entry := gEngineGoContexts[engine]
+	delete(gEngineGoContexts, engine)
+	gShutdownEngines[engine] = true
+
+	for jsCtx := range gScriptGoContexts {
+		if jsCtx.GetScriptEngine() == engine {
+			delete(gScriptGoContexts, jsCtx)
+		}
+	}
+
+	gGoContextsMutex.Unlock()
+
+	// Cancelling the parent also cancels the script contexts.
+	if entry != nil {
+		entry.cancel()
+	}
+}
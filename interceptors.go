@@ -0,0 +1,249 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package progpAPI
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FunctionCall describes a call of a Go function by javascript.
+// It's what the interceptors receive before the function is called.
+type FunctionCall struct {
+	Function  *RegisteredFunction
+	JsContext JsContext
+
+	// SecurityGroup is the security group of the calling script context.
+	SecurityGroup string
+
+	// Args are the decoded arguments given to the Go function, including the
+	// implicit ones and the instance for the methods of a class.
+	// Modifying them has no effect on the call.
+	Args []any
+
+	StartTime time.Time
+
+	// Context is the context.Context given to the Go function, or the context
+	// of the script context if the function doesn't receive one and it has
+	// already been created, see GetScriptGoContext.
+	// It's cancelled when javascript aborts the call.
+	Context context.Context
+
+	// Duration is set once the call is done.
+	Duration time.Duration
+
+	// Err is the error returned by the Go function, or the error of the interceptor
+	// which has short-circuited the call. Is set once the call is done.
+	Err error
+
+	// IsRejected is true if an interceptor has short-circuited the call.
+	IsRejected bool
+
+	// Panic is the value of the panic of the Go function, if any.
+	Panic any
+
+	// Delay is how long the call waits before calling the Go function, see DelayBy.
+	Delay time.Duration
+
+	onDone []func(call *FunctionCall)
+}
+
+// OnDone adds a function which is called once the Go function returns.
+// For a function returning a promise, it's once the promise is settled,
+// and for an async function it's once his callback is called.
+// It's also called when an interceptor short-circuits the call.
+func (m *FunctionCall) OnDone(f func(call *FunctionCall)) {
+	m.onDone = append(m.onDone, f)
+}
+
+// CanBeDelayed returns true if the call can wait before calling the Go function
+// without blocking javascript, see RegisteredFunction.CanBeDelayed.
+func (m *FunctionCall) CanBeDelayed() bool {
+	return (m.Function != nil) && m.Function.CanBeDelayed()
+}
+
+// DelayBy makes the call wait before calling the Go function.
+// Returns false if the call can't be delayed, see CanBeDelayed.
+func (m *FunctionCall) DelayBy(delay time.Duration) bool {
+	if !m.CanBeDelayed() {
+		return false
+	}
+
+	m.Delay += delay
+	return true
+}
+
+// WaitDelay is called by the generated code before calling the Go function.
+// The wait stops if the context of the call is cancelled, for example when javascript
+// aborts the call, and the error then tells why. The Go function must not be called.
+func (m *FunctionCall) WaitDelay() error {
+	if m.Delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(m.Delay)
+	defer timer.Stop()
+
+	if m.Context == nil {
+		<-timer.C
+		return nil
+	}
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-m.Context.Done():
+		return context.Cause(m.Context)
+	}
+}
+
+// SetError is called by the generated code when the Go function returns an error.
+// Can be called on nil, which is the case when there is no interceptor.
+func (m *FunctionCall) SetError(err error) {
+	if m != nil {
+		m.Err = err
+	}
+}
+
+// Done is deferred by the generated code, and called once the Go function returns.
+// The OnDone functions are called in the reverse order of their adding.
+// A panic of the Go function is recorded then continues.
+func (m *FunctionCall) Done() {
+	if err := recover(); err != nil {
+		m.Panic = err
+		m.Err = fmt.Errorf("%v", err)
+		m.done()
+		panic(err)
+	}
+
+	m.done()
+}
+
+// DoneWhenSettled is deferred by the generated code of the async functions, in place of Done.
+// The call is done once his callback is called, with the error given to the callback,
+// or now if the Go function has returned an error or has panicked.
+func (m *FunctionCall) DoneWhenSettled(callback *SettlingJsFunction) {
+	if err := recover(); err != nil {
+		m.Panic = err
+		m.Err = fmt.Errorf("%v", err)
+		m.done()
+		panic(err)
+	}
+
+	if m.Err != nil {
+		m.done()
+		return
+	}
+
+	callback.OnSettled(func(err error) {
+		m.Err = err
+		m.done()
+	})
+}
+
+func (m *FunctionCall) done() {
+	m.Duration = time.Since(m.StartTime)
+
+	for i := len(m.onDone) - 1; i >= 0; i-- {
+		m.onDone[i](m)
+	}
+}
+
+// FunctionInterceptor is called before each call of a Go function by javascript.
+// Returning an error prevents the call, the error is then thrown in javascript
+// or used to reject the promise.
+type FunctionInterceptor func(call *FunctionCall) error
+
+var gInterceptors atomic.Pointer[[]FunctionInterceptor]
+var gInterceptorsMutex sync.Mutex
+
+// Use adds an interceptor which is called before each call of a Go function by javascript.
+// The interceptors are called in the order of their adding.
+//
+// Without interceptor the generated code directly calls the Go function,
+// so installing one has a cost on all the calls.
+func (m *FunctionRegistry) Use(interceptor FunctionInterceptor) {
+	if interceptor == nil {
+		panic("the interceptor can't be nil")
+	}
+
+	gInterceptorsMutex.Lock()
+	defer gInterceptorsMutex.Unlock()
+
+	var list []FunctionInterceptor
+
+	if current := gInterceptors.Load(); current != nil {
+		list = append(list, *current...)
+	}
+
+	list = append(list, interceptor)
+	gInterceptors.Store(&list)
+}
+
+// HasCallInterceptors returns true if at least one interceptor is installed.
+// It's used by the generated code to keep a fast path when there is none.
+func HasCallInterceptors() bool {
+	return gInterceptors.Load() != nil
+}
+
+// InterceptCall calls the interceptors for a call of a Go function by javascript.
+// If an interceptor returns an error, the call must not be done and the
+// OnDone functions added by the previous interceptors are already called.
+//
+// It's used by the generated code, which must call FunctionCall.Done once
+// the Go function returns.
+func InterceptCall(group string, jsFunctionName string, jsCtx JsContext, args ...any) (*FunctionCall, error) {
+	call := &FunctionCall{
+		Function:  GetFunctionRegistry().GetFunction(group, jsFunctionName),
+		JsContext: jsCtx,
+		Args:      args,
+		StartTime: time.Now(),
+	}
+
+	if jsCtx != nil {
+		call.SecurityGroup = jsCtx.GetSecurityGroup()
+	}
+
+	for _, arg := range args {
+		if ctx, ok := arg.(context.Context); ok {
+			call.Context = ctx
+			break
+		}
+	}
+
+	if call.Context == nil {
+		// The context of the script isn't created only for the interceptors.
+		call.Context = lookupScriptGoContext(jsCtx)
+	}
+
+	if interceptors := gInterceptors.Load(); interceptors != nil {
+		for _, interceptor := range *interceptors {
+			if err := interceptor(call); err != nil {
+				call.Err = err
+				call.IsRejected = true
+				call.done()
+
+				return nil, err
+			}
+		}
+	}
+
+	return call, nil
+}
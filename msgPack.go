@@ -0,0 +1,1248 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package progpAPI
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MessagePack is the binary alternative to JSON for the values exchanged with
+// javascript, see CustomTypeEncodingMsgPack. The structs are encoded as maps
+// following the json tags, which allows javascript to see the same objects
+// whatever the encoding. The differences with JSON are:
+//   - []byte is a Uint8Array, instead of a base64 string.
+//   - time.Time is a Date, using the MessagePack timestamp extension.
+//   - Numbers decoded into an interface are int64, uint64 or float64.
+//   - json.Marshaler and the json tag option "string" aren't supported.
+
+//region Encoding
+
+// MarshalMsgPack returns the MessagePack encoding of value.
+func MarshalMsgPack(value any) ([]byte, error) {
+	e := &msgPackEncoder{buffer: make([]byte, 0, 128)}
+
+	if err := e.encode(reflect.ValueOf(value), 0); err != nil {
+		return nil, err
+	}
+
+	return e.buffer, nil
+}
+
+// MarshalMsgPackResults returns the encoding of the values returned by a function having
+// several results. They are encoded as an array, or as a map if their names are given,
+// in which case the keys keep the order of the results.
+//
+// Unlike MarshalMsgPack, each result has a javascript type only depending on his Go type:
+//   - []byte is an ArrayBuffer, like when it's the only result of a function.
+//   - int64 and uint64 are a BigInt if useBigInt is true, see RegisteredFunction.WithBigInt.
+//   - The other integers are a number, and an error is returned if they are too big for it.
+func MarshalMsgPackResults(names []string, useBigInt bool, values ...any) ([]byte, error) {
+	if (names != nil) && (len(names) != len(values)) {
+		return nil, errors.New("msgpack: " + strconv.Itoa(len(values)) + " results but " + strconv.Itoa(len(names)) + " names")
+	}
+
+	e := &msgPackEncoder{buffer: make([]byte, 0, 128)}
+
+	if names == nil {
+		e.writeHeader(len(values), 0x90, 15, 0xdc, 0xdd)
+	} else {
+		e.writeHeader(len(values), 0x80, 15, 0xde, 0xdf)
+	}
+
+	for i, value := range values {
+		if names != nil {
+			e.writeString(names[i])
+		}
+
+		if err := e.encodeResult(reflect.ValueOf(value), useBigInt); err != nil {
+			return nil, err
+		}
+	}
+
+	return e.buffer, nil
+}
+
+// The extension types used by MarshalMsgPackResults, which
+// the C++ decoder converts to a BigInt or to an ArrayBuffer.
+const (
+	msgPackExtBigInt      = 1
+	msgPackExtBigUint     = 2
+	msgPackExtArrayBuffer = 3
+)
+
+// jsMaxSafeInteger is the biggest integer a javascript number can safely store.
+const jsMaxSafeInteger = 1<<53 - 1
+
+// The max depth avoids an infinite recursion with cyclic pointers.
+const msgPackMaxDepth = 1000
+
+var gMsgPackTimeType = reflect.TypeOf(time.Time{})
+var gMsgPackTextMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+var gMsgPackTextUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+type msgPackEncoder struct {
+	buffer []byte
+}
+
+func (m *msgPackEncoder) encode(v reflect.Value, depth int) error {
+	if depth > msgPackMaxDepth {
+		return errors.New("msgpack: the value is too deep, is it cyclic?")
+	}
+
+	if !v.IsValid() {
+		m.buffer = append(m.buffer, 0xc0)
+		return nil
+	}
+
+	kind := v.Kind()
+
+	if ((kind == reflect.Pointer) || (kind == reflect.Interface)) && v.IsNil() {
+		m.buffer = append(m.buffer, 0xc0)
+		return nil
+	}
+
+	if v.Type() == gMsgPackTimeType {
+		m.writeTime(v.Interface().(time.Time))
+		return nil
+	}
+
+	if (kind != reflect.Interface) && v.Type().Implements(gMsgPackTextMarshalerType) {
+		asText, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+
+		m.writeString(string(asText))
+		return nil
+	}
+
+	switch kind {
+	case reflect.Bool:
+		if v.Bool() {
+			m.buffer = append(m.buffer, 0xc3)
+		} else {
+			m.buffer = append(m.buffer, 0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		m.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		m.writeUint(v.Uint())
+	case reflect.Float32:
+		m.buffer = append(m.buffer, 0xca)
+		m.buffer = binary.BigEndian.AppendUint32(m.buffer, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		m.buffer = append(m.buffer, 0xcb)
+		m.buffer = binary.BigEndian.AppendUint64(m.buffer, math.Float64bits(v.Float()))
+	case reflect.String:
+		m.writeString(v.String())
+	case reflect.Pointer, reflect.Interface:
+		return m.encode(v.Elem(), depth+1)
+	case reflect.Slice:
+		if v.IsNil() {
+			m.buffer = append(m.buffer, 0xc0)
+			return nil
+		}
+
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			m.writeBinary(v.Bytes())
+			return nil
+		}
+
+		return m.encodeArray(v, depth)
+	case reflect.Array:
+		return m.encodeArray(v, depth)
+	case reflect.Map:
+		if v.IsNil() {
+			m.buffer = append(m.buffer, 0xc0)
+			return nil
+		}
+
+		return m.encodeMap(v, depth)
+	case reflect.Struct:
+		return m.encodeStruct(v, depth)
+	default:
+		return errors.New("msgpack: unsupported type " + v.Type().String())
+	}
+
+	return nil
+}
+
+func (m *msgPackEncoder) encodeArray(v reflect.Value, depth int) error {
+	length := v.Len()
+	m.writeHeader(length, 0x90, 15, 0xdc, 0xdd)
+
+	for i := 0; i < length; i++ {
+		if err := m.encode(v.Index(i), depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *msgPackEncoder) encodeMap(v reflect.Value, depth int) error {
+	type mapEntry struct {
+		key   string
+		value reflect.Value
+	}
+
+	entries := make([]mapEntry, 0, v.Len())
+	iter := v.MapRange()
+
+	for iter.Next() {
+		key, err := msgPackMapKeyToString(iter.Key())
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, mapEntry{key: key, value: iter.Value()})
+	}
+
+	// Allows always having the same result.
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	m.writeHeader(len(entries), 0x80, 15, 0xde, 0xdf)
+
+	for _, entry := range entries {
+		m.writeString(entry.key)
+
+		if err := m.encode(entry.value, depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *msgPackEncoder) encodeStruct(v reflect.Value, depth int) error {
+	fields := getMsgPackFields(v.Type())
+	values := make([]reflect.Value, 0, len(fields))
+	var names []string
+
+	for _, field := range fields {
+		fieldValue, err := v.FieldByIndexErr(field.index)
+
+		if err != nil {
+			// Is inside a nil embedded pointer.
+			continue
+		}
+
+		if field.omitEmpty && fieldValue.IsZero() {
+			continue
+		}
+
+		names = append(names, field.name)
+		values = append(values, fieldValue)
+	}
+
+	m.writeHeader(len(values), 0x80, 15, 0xde, 0xdf)
+
+	for i, fieldValue := range values {
+		m.writeString(names[i])
+
+		if err := m.encode(fieldValue, depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeResult encodes one of the results of a function, see MarshalMsgPackResults.
+func (m *msgPackEncoder) encodeResult(v reflect.Value, useBigInt bool) error {
+	if !v.IsValid() || v.Type().Implements(gMsgPackTextMarshalerType) {
+		return m.encode(v, 0)
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if useBigInt && (v.Kind() == reflect.Int64) {
+			m.writeExt(msgPackExtBigInt, binary.BigEndian.AppendUint64(nil, uint64(v.Int())))
+			return nil
+		}
+
+		if (v.Int() > jsMaxSafeInteger) || (v.Int() < -jsMaxSafeInteger) {
+			return errors.New("the returned value " + strconv.FormatInt(v.Int(), 10) + " can't be safely converted to a javascript number")
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if useBigInt && (v.Kind() == reflect.Uint64) {
+			m.writeExt(msgPackExtBigUint, binary.BigEndian.AppendUint64(nil, v.Uint()))
+			return nil
+		}
+
+		if v.Uint() > jsMaxSafeInteger {
+			return errors.New("the returned value " + strconv.FormatUint(v.Uint(), 10) + " can't be safely converted to a javascript number")
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			// A nil slice is an empty ArrayBuffer, since the type must not change.
+			m.writeExt(msgPackExtArrayBuffer, v.Bytes())
+			return nil
+		}
+	}
+
+	return m.encode(v, 0)
+}
+
+// writeHeader writes the header of a string, an array or a map.
+func (m *msgPackEncoder) writeHeader(length int, fixCode byte, fixMax int, code16 byte, code32 byte) {
+	if length <= fixMax {
+		m.buffer = append(m.buffer, fixCode|byte(length))
+	} else if length <= math.MaxUint16 {
+		m.buffer = append(m.buffer, code16)
+		m.buffer = binary.BigEndian.AppendUint16(m.buffer, uint16(length))
+	} else {
+		m.buffer = append(m.buffer, code32)
+		m.buffer = binary.BigEndian.AppendUint32(m.buffer, uint32(length))
+	}
+}
+
+func (m *msgPackEncoder) writeString(value string) {
+	length := len(value)
+
+	if (length > 31) && (length <= math.MaxUint8) {
+		m.buffer = append(m.buffer, 0xd9, byte(length))
+	} else {
+		m.writeHeader(length, 0xa0, 31, 0xda, 0xdb)
+	}
+
+	m.buffer = append(m.buffer, value...)
+}
+
+func (m *msgPackEncoder) writeBinary(value []byte) {
+	length := len(value)
+
+	if length <= math.MaxUint8 {
+		m.buffer = append(m.buffer, 0xc4, byte(length))
+	} else if length <= math.MaxUint16 {
+		m.buffer = append(m.buffer, 0xc5)
+		m.buffer = binary.BigEndian.AppendUint16(m.buffer, uint16(length))
+	} else {
+		m.buffer = append(m.buffer, 0xc6)
+		m.buffer = binary.BigEndian.AppendUint32(m.buffer, uint32(length))
+	}
+
+	m.buffer = append(m.buffer, value...)
+}
+
+func (m *msgPackEncoder) writeInt(value int64) {
+	if value >= 0 {
+		m.writeUint(uint64(value))
+	} else if value >= -32 {
+		m.buffer = append(m.buffer, byte(value))
+	} else if value >= math.MinInt8 {
+		m.buffer = append(m.buffer, 0xd0, byte(value))
+	} else if value >= math.MinInt16 {
+		m.buffer = append(m.buffer, 0xd1)
+		m.buffer = binary.BigEndian.AppendUint16(m.buffer, uint16(value))
+	} else if value >= math.MinInt32 {
+		m.buffer = append(m.buffer, 0xd2)
+		m.buffer = binary.BigEndian.AppendUint32(m.buffer, uint32(value))
+	} else {
+		m.buffer = append(m.buffer, 0xd3)
+		m.buffer = binary.BigEndian.AppendUint64(m.buffer, uint64(value))
+	}
+}
+
+func (m *msgPackEncoder) writeUint(value uint64) {
+	if value <= 127 {
+		m.buffer = append(m.buffer, byte(value))
+	} else if value <= math.MaxUint8 {
+		m.buffer = append(m.buffer, 0xcc, byte(value))
+	} else if value <= math.MaxUint16 {
+		m.buffer = append(m.buffer, 0xcd)
+		m.buffer = binary.BigEndian.AppendUint16(m.buffer, uint16(value))
+	} else if value <= math.MaxUint32 {
+		m.buffer = append(m.buffer, 0xce)
+		m.buffer = binary.BigEndian.AppendUint32(m.buffer, uint32(value))
+	} else {
+		m.buffer = append(m.buffer, 0xcf)
+		m.buffer = binary.BigEndian.AppendUint64(m.buffer, value)
+	}
+}
+
+// writeExt writes an extension, which content is data.
+func (m *msgPackEncoder) writeExt(extType int8, data []byte) {
+	length := len(data)
+
+	switch length {
+	case 1:
+		m.buffer = append(m.buffer, 0xd4)
+	case 2:
+		m.buffer = append(m.buffer, 0xd5)
+	case 4:
+		m.buffer = append(m.buffer, 0xd6)
+	case 8:
+		m.buffer = append(m.buffer, 0xd7)
+	case 16:
+		m.buffer = append(m.buffer, 0xd8)
+	default:
+		if length <= math.MaxUint8 {
+			m.buffer = append(m.buffer, 0xc7, byte(length))
+		} else if length <= math.MaxUint16 {
+			m.buffer = append(m.buffer, 0xc8)
+			m.buffer = binary.BigEndian.AppendUint16(m.buffer, uint16(length))
+		} else {
+			m.buffer = append(m.buffer, 0xc9)
+			m.buffer = binary.BigEndian.AppendUint32(m.buffer, uint32(length))
+		}
+	}
+
+	m.buffer = append(m.buffer, byte(extType))
+	m.buffer = append(m.buffer, data...)
+}
+
+// writeTime uses the timestamp extension, which type is -1.
+func (m *msgPackEncoder) writeTime(value time.Time) {
+	sec := value.Unix()
+	nsec := uint64(value.Nanosecond())
+
+	if uint64(sec)>>34 == 0 {
+		if (nsec == 0) && (sec <= math.MaxUint32) {
+			m.buffer = append(m.buffer, 0xd6, 0xff)
+			m.buffer = binary.BigEndian.AppendUint32(m.buffer, uint32(sec))
+		} else {
+			m.buffer = append(m.buffer, 0xd7, 0xff)
+			m.buffer = binary.BigEndian.AppendUint64(m.buffer, nsec<<34|uint64(sec))
+		}
+
+		return
+	}
+
+	m.buffer = append(m.buffer, 0xc7, 12, 0xff)
+	m.buffer = binary.BigEndian.AppendUint32(m.buffer, uint32(nsec))
+	m.buffer = binary.BigEndian.AppendUint64(m.buffer, uint64(sec))
+}
+
+// msgPackMapKeyToString converts a map key, with the same rules as encoding/json.
+func msgPackMapKeyToString(key reflect.Value) (string, error) {
+	if key.Kind() == reflect.String {
+		return key.String(), nil
+	}
+
+	if key.Type().Implements(gMsgPackTextMarshalerType) {
+		if (key.Kind() == reflect.Pointer) && key.IsNil() {
+			return "", nil
+		}
+
+		asText, err := key.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(asText), err
+	}
+
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), nil
+	}
+
+	return "", errors.New("msgpack: unsupported map key type " + key.Type().String())
+}
+
+//endregion
+
+//region Struct fields
+
+type msgPackField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var gMsgPackFieldsCache sync.Map
+
+// getMsgPackFields returns the fields of a struct, named as with encoding/json.
+// The fields of the embedded structs are promoted, unless hidden by a shallower field.
+func getMsgPackFields(structType reflect.Type) []msgPackField {
+	if cached, ok := gMsgPackFieldsCache.Load(structType); ok {
+		return cached.([]msgPackField)
+	}
+
+	var res []msgPackField
+	usedNames := make(map[string]bool)
+
+	var addFields func(t reflect.Type, parentIndex []int, depth int)
+
+	addFields = func(t reflect.Type, parentIndex []int, depth int) {
+		var embedded []reflect.StructField
+
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+
+			if tag == "-" {
+				continue
+			}
+
+			name, options, _ := strings.Cut(tag, ",")
+
+			if field.Anonymous && (name == "") {
+				fieldType := field.Type
+				if fieldType.Kind() == reflect.Pointer {
+					fieldType = fieldType.Elem()
+				}
+
+				if fieldType.Kind() == reflect.Struct {
+					// Added after the fields of this level, which have priority.
+					embedded = append(embedded, field)
+					continue
+				}
+			}
+
+			if !field.IsExported() {
+				continue
+			}
+
+			if name == "" {
+				name = field.Name
+			}
+
+			if usedNames[name] {
+				continue
+			}
+
+			usedNames[name] = true
+
+			res = append(res, msgPackField{
+				name:      name,
+				index:     append(slicesCloneInt(parentIndex), i),
+				omitEmpty: strings.Contains(","+options+",", ",omitempty,"),
+			})
+		}
+
+		if depth < 10 {
+			for _, field := range embedded {
+				fieldType := field.Type
+				if fieldType.Kind() == reflect.Pointer {
+					fieldType = fieldType.Elem()
+				}
+
+				addFields(fieldType, append(slicesCloneInt(parentIndex), field.Index[0]), depth+1)
+			}
+		}
+	}
+
+	addFields(structType, nil, 0)
+
+	gMsgPackFieldsCache.Store(structType, res)
+	return res
+}
+
+func slicesCloneInt(values []int) []int {
+	return append(make([]int, 0, len(values)+1), values...)
+}
+
+//endregion
+
+//region Decoding
+
+// UnmarshalMsgPack decodes a MessagePack value into the value pointed by target.
+// The rules are the same as json.Unmarshal, the unknown fields are ignored.
+func UnmarshalMsgPack(data []byte, target any) error {
+	v := reflect.ValueOf(target)
+
+	if (v.Kind() != reflect.Pointer) || v.IsNil() {
+		return errors.New("msgpack: the target must be a non-nil pointer")
+	}
+
+	d := &msgPackDecoder{data: data}
+
+	if err := d.decode(v.Elem(), 0); err != nil {
+		return err
+	}
+
+	if d.offset != len(d.data) {
+		return errors.New("msgpack: unexpected data after the value")
+	}
+
+	return nil
+}
+
+type msgPackTokenKind int
+
+const (
+	msgPackNil msgPackTokenKind = iota
+	msgPackBool
+	msgPackInt
+	msgPackUint
+	msgPackFloat
+	msgPackString
+	msgPackBinary
+	msgPackArray
+	msgPackMap
+	msgPackExt
+)
+
+type msgPackToken struct {
+	kind msgPackTokenKind
+
+	boolValue  bool
+	intValue   int64
+	uintValue  uint64
+	floatValue float64
+
+	// bytes is the content of a string, a binary or an extension.
+	bytes   []byte
+	extType int8
+
+	// length is the item count of an array or a map.
+	length int
+}
+
+var errMsgPackTruncated = errors.New("msgpack: unexpected end of data")
+
+type msgPackDecoder struct {
+	data   []byte
+	offset int
+}
+
+func (m *msgPackDecoder) read(size int) ([]byte, error) {
+	if (size < 0) || (m.offset+size > len(m.data)) {
+		return nil, errMsgPackTruncated
+	}
+
+	res := m.data[m.offset : m.offset+size]
+	m.offset += size
+	return res, nil
+}
+
+func (m *msgPackDecoder) readUint(size int) (uint64, error) {
+	b, err := m.read(size)
+	if err != nil {
+		return 0, err
+	}
+
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+func (m *msgPackDecoder) readSized(kind msgPackTokenKind, sizeBytes int) (msgPackToken, error) {
+	size, err := m.readUint(sizeBytes)
+	if err != nil {
+		return msgPackToken{}, err
+	}
+
+	b, err := m.read(int(size))
+	return msgPackToken{kind: kind, bytes: b}, err
+}
+
+func (m *msgPackDecoder) readExt(size int) (msgPackToken, error) {
+	extType, err := m.readUint(1)
+	if err != nil {
+		return msgPackToken{}, err
+	}
+
+	b, err := m.read(size)
+	return msgPackToken{kind: msgPackExt, extType: int8(extType), bytes: b}, err
+}
+
+func (m *msgPackDecoder) readLength(kind msgPackTokenKind, sizeBytes int) (msgPackToken, error) {
+	length, err := m.readUint(sizeBytes)
+	return msgPackToken{kind: kind, length: int(length)}, err
+}
+
+func (m *msgPackDecoder) next() (msgPackToken, error) {
+	b, err := m.read(1)
+	if err != nil {
+		return msgPackToken{}, err
+	}
+
+	code := b[0]
+
+	switch {
+	case code <= 0x7f:
+		return msgPackToken{kind: msgPackUint, uintValue: uint64(code)}, nil
+	case code >= 0xe0:
+		return msgPackToken{kind: msgPackInt, intValue: int64(int8(code))}, nil
+	case code&0xf0 == 0x80:
+		return msgPackToken{kind: msgPackMap, length: int(code & 0x0f)}, nil
+	case code&0xf0 == 0x90:
+		return msgPackToken{kind: msgPackArray, length: int(code & 0x0f)}, nil
+	case code&0xe0 == 0xa0:
+		b, err = m.read(int(code & 0x1f))
+		return msgPackToken{kind: msgPackString, bytes: b}, err
+	}
+
+	switch code {
+	case 0xc0:
+		return msgPackToken{kind: msgPackNil}, nil
+	case 0xc2, 0xc3:
+		return msgPackToken{kind: msgPackBool, boolValue: code == 0xc3}, nil
+	case 0xc4, 0xc5, 0xc6:
+		return m.readSized(msgPackBinary, 1<<(code-0xc4))
+	case 0xc7, 0xc8, 0xc9:
+		size, err := m.readUint(1 << (code - 0xc7))
+		if err != nil {
+			return msgPackToken{}, err
+		}
+
+		return m.readExt(int(size))
+	case 0xca:
+		v, err := m.readUint(4)
+		return msgPackToken{kind: msgPackFloat, floatValue: float64(math.Float32frombits(uint32(v)))}, err
+	case 0xcb:
+		v, err := m.readUint(8)
+		return msgPackToken{kind: msgPackFloat, floatValue: math.Float64frombits(v)}, err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := m.readUint(1 << (code - 0xcc))
+		return msgPackToken{kind: msgPackUint, uintValue: v}, err
+	case 0xd0:
+		v, err := m.readUint(1)
+		return msgPackToken{kind: msgPackInt, intValue: int64(int8(v))}, err
+	case 0xd1:
+		v, err := m.readUint(2)
+		return msgPackToken{kind: msgPackInt, intValue: int64(int16(v))}, err
+	case 0xd2:
+		v, err := m.readUint(4)
+		return msgPackToken{kind: msgPackInt, intValue: int64(int32(v))}, err
+	case 0xd3:
+		v, err := m.readUint(8)
+		return msgPackToken{kind: msgPackInt, intValue: int64(v)}, err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return m.readExt(1 << (code - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		return m.readSized(msgPackString, 1<<(code-0xd9))
+	case 0xdc, 0xdd:
+		return m.readLength(msgPackArray, 2<<(code-0xdc))
+	case 0xde, 0xdf:
+		return m.readLength(msgPackMap, 2<<(code-0xde))
+	}
+
+	return msgPackToken{}, errors.New("msgpack: invalid code 0x" + strconv.FormatUint(uint64(code), 16))
+}
+
+// skip ignores the items of an array or a map.
+func (m *msgPackDecoder) skip(count int) error {
+	for i := 0; i < count; i++ {
+		token, err := m.next()
+		if err != nil {
+			return err
+		}
+
+		switch token.kind {
+		case msgPackArray:
+			err = m.skip(token.length)
+		case msgPackMap:
+			err = m.skip(token.length * 2)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *msgPackDecoder) decode(v reflect.Value, depth int) error {
+	if depth > msgPackMaxDepth {
+		return errors.New("msgpack: the value is too deep")
+	}
+
+	token, err := m.next()
+	if err != nil {
+		return err
+	}
+
+	return m.decodeToken(token, v, depth)
+}
+
+func (m *msgPackDecoder) decodeToken(token msgPackToken, v reflect.Value, depth int) error {
+	if token.kind == msgPackNil {
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+
+		return nil
+	}
+
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+
+		return m.decodeToken(token, v.Elem(), depth)
+	}
+
+	if (v.Kind() == reflect.Interface) && (v.NumMethod() == 0) {
+		generic, err := m.decodeGeneric(token, depth)
+		if err != nil {
+			return err
+		}
+
+		if generic == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(generic))
+		}
+
+		return nil
+	}
+
+	if v.Type() == gMsgPackTimeType {
+		asTime, err := msgPackTokenToTime(token)
+		if err != nil {
+			return err
+		}
+
+		v.Set(reflect.ValueOf(asTime))
+		return nil
+	}
+
+	if (token.kind == msgPackString) && reflect.PointerTo(v.Type()).Implements(gMsgPackTextUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(token.bytes)
+	}
+
+	newTypeError := func() error {
+		return errors.New("msgpack: can't decode " + token.describe() + " into a value of type " + v.Type().String())
+	}
+
+	switch token.kind {
+	case msgPackBool:
+		if v.Kind() != reflect.Bool {
+			return newTypeError()
+		}
+
+		v.SetBool(token.boolValue)
+	case msgPackInt, msgPackUint, msgPackFloat:
+		return m.decodeNumber(token, v, newTypeError)
+	case msgPackString:
+		if v.Kind() != reflect.String {
+			return newTypeError()
+		}
+
+		v.SetString(string(token.bytes))
+	case msgPackBinary:
+		if (v.Kind() == reflect.Slice) && (v.Type().Elem().Kind() == reflect.Uint8) {
+			v.SetBytes(append(make([]byte, 0, len(token.bytes)), token.bytes...))
+		} else if (v.Kind() == reflect.Array) && (v.Type().Elem().Kind() == reflect.Uint8) {
+			reflect.Copy(v, reflect.ValueOf(token.bytes))
+		} else {
+			return newTypeError()
+		}
+	case msgPackArray:
+		return m.decodeArray(token, v, depth, newTypeError)
+	case msgPackMap:
+		return m.decodeMap(token, v, depth, newTypeError)
+	default:
+		return newTypeError()
+	}
+
+	return nil
+}
+
+func (m *msgPackDecoder) decodeNumber(token msgPackToken, v reflect.Value, newTypeError func() error) error {
+	newOverflowError := func() error {
+		return errors.New("msgpack: the number " + token.describe() + " overflows the type " + v.Type().String())
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var asInt int64
+
+		switch token.kind {
+		case msgPackInt:
+			asInt = token.intValue
+		case msgPackUint:
+			if token.uintValue > math.MaxInt64 {
+				return newOverflowError()
+			}
+
+			asInt = int64(token.uintValue)
+		default:
+			// Javascript numbers can be encoded as a float.
+			if (math.Trunc(token.floatValue) != token.floatValue) || (token.floatValue < math.MinInt64) || (token.floatValue >= math.MaxInt64) {
+				return newOverflowError()
+			}
+
+			asInt = int64(token.floatValue)
+		}
+
+		if v.OverflowInt(asInt) {
+			return newOverflowError()
+		}
+
+		v.SetInt(asInt)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var asUint uint64
+
+		switch token.kind {
+		case msgPackUint:
+			asUint = token.uintValue
+		case msgPackInt:
+			if token.intValue < 0 {
+				return newOverflowError()
+			}
+
+			asUint = uint64(token.intValue)
+		default:
+			if (math.Trunc(token.floatValue) != token.floatValue) || (token.floatValue < 0) || (token.floatValue >= math.MaxUint64) {
+				return newOverflowError()
+			}
+
+			asUint = uint64(token.floatValue)
+		}
+
+		if v.OverflowUint(asUint) {
+			return newOverflowError()
+		}
+
+		v.SetUint(asUint)
+	case reflect.Float32, reflect.Float64:
+		switch token.kind {
+		case msgPackInt:
+			v.SetFloat(float64(token.intValue))
+		case msgPackUint:
+			v.SetFloat(float64(token.uintValue))
+		default:
+			v.SetFloat(token.floatValue)
+		}
+	default:
+		return newTypeError()
+	}
+
+	return nil
+}
+
+func (m *msgPackDecoder) decodeArray(token msgPackToken, v reflect.Value, depth int, newTypeError func() error) error {
+	switch v.Kind() {
+	case reflect.Slice:
+		if token.length > len(m.data)-m.offset {
+			// Each item takes at least one byte.
+			return errMsgPackTruncated
+		}
+
+		slice := reflect.MakeSlice(v.Type(), token.length, token.length)
+
+		for i := 0; i < token.length; i++ {
+			if err := m.decode(slice.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+
+		v.Set(slice)
+	case reflect.Array:
+		for i := 0; i < token.length; i++ {
+			if i >= v.Len() {
+				// As with JSON, the extra items are ignored.
+				return m.skip(token.length - i)
+			}
+
+			if err := m.decode(v.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return newTypeError()
+	}
+
+	return nil
+}
+
+func (m *msgPackDecoder) decodeMap(token msgPackToken, v reflect.Value, depth int, newTypeError func() error) error {
+	switch v.Kind() {
+	case reflect.Map:
+		if token.length > (len(m.data)-m.offset)/2 {
+			// Each entry takes at least two bytes.
+			return errMsgPackTruncated
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), token.length))
+		}
+
+		keyType := v.Type().Key()
+		valueType := v.Type().Elem()
+
+		for i := 0; i < token.length; i++ {
+			keyToken, err := m.next()
+			if err != nil {
+				return err
+			}
+
+			key := reflect.New(keyType).Elem()
+
+			if err = m.decodeMapKey(keyToken, key); err != nil {
+				return err
+			}
+
+			value := reflect.New(valueType).Elem()
+
+			if err = m.decode(value, depth+1); err != nil {
+				return err
+			}
+
+			v.SetMapIndex(key, value)
+		}
+	case reflect.Struct:
+		fields := getMsgPackFields(v.Type())
+
+		for i := 0; i < token.length; i++ {
+			keyToken, err := m.next()
+			if err != nil {
+				return err
+			}
+
+			if keyToken.kind != msgPackString {
+				return errors.New("msgpack: the keys of an object must be strings")
+			}
+
+			field := findMsgPackField(fields, string(keyToken.bytes))
+
+			if field == nil {
+				if err = m.skip(1); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			if err = m.decode(msgPackFieldByIndex(v, field.index), depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return newTypeError()
+	}
+
+	return nil
+}
+
+func (m *msgPackDecoder) decodeMapKey(token msgPackToken, key reflect.Value) error {
+	if token.kind != msgPackString {
+		return m.decodeToken(token, key, 0)
+	}
+
+	if reflect.PointerTo(key.Type()).Implements(gMsgPackTextUnmarshalerType) {
+		return key.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(token.bytes)
+	}
+
+	asString := string(token.bytes)
+
+	switch key.Kind() {
+	case reflect.String:
+		key.SetString(asString)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		asInt, err := strconv.ParseInt(asString, 10, 64)
+		if (err != nil) || key.OverflowInt(asInt) {
+			return errors.New("msgpack: invalid map key " + strconv.Quote(asString) + " for type " + key.Type().String())
+		}
+
+		key.SetInt(asInt)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		asUint, err := strconv.ParseUint(asString, 10, 64)
+		if (err != nil) || key.OverflowUint(asUint) {
+			return errors.New("msgpack: invalid map key " + strconv.Quote(asString) + " for type " + key.Type().String())
+		}
+
+		key.SetUint(asUint)
+	default:
+		return errors.New("msgpack: unsupported map key type " + key.Type().String())
+	}
+
+	return nil
+}
+
+// decodeGeneric decodes a value for an interface{}.
+func (m *msgPackDecoder) decodeGeneric(token msgPackToken, depth int) (any, error) {
+	if depth > msgPackMaxDepth {
+		return nil, errors.New("msgpack: the value is too deep")
+	}
+
+	switch token.kind {
+	case msgPackNil:
+		return nil, nil
+	case msgPackBool:
+		return token.boolValue, nil
+	case msgPackInt:
+		return token.intValue, nil
+	case msgPackUint:
+		if token.uintValue <= math.MaxInt64 {
+			return int64(token.uintValue), nil
+		}
+
+		return token.uintValue, nil
+	case msgPackFloat:
+		return token.floatValue, nil
+	case msgPackString:
+		return string(token.bytes), nil
+	case msgPackBinary:
+		return append(make([]byte, 0, len(token.bytes)), token.bytes...), nil
+	case msgPackExt:
+		return msgPackTokenToTime(token)
+	case msgPackArray:
+		if token.length > len(m.data)-m.offset {
+			return nil, errMsgPackTruncated
+		}
+
+		res := make([]any, token.length)
+
+		for i := range res {
+			item, err := m.next()
+			if err != nil {
+				return nil, err
+			}
+
+			if res[i], err = m.decodeGeneric(item, depth+1); err != nil {
+				return nil, err
+			}
+		}
+
+		return res, nil
+	default:
+		if token.length > (len(m.data)-m.offset)/2 {
+			return nil, errMsgPackTruncated
+		}
+
+		res := make(map[string]any, token.length)
+
+		for i := 0; i < token.length; i++ {
+			keyToken, err := m.next()
+			if err != nil {
+				return nil, err
+			}
+
+			key, err := m.decodeGeneric(keyToken, depth+1)
+			if err != nil {
+				return nil, err
+			}
+
+			asString, isString := key.(string)
+			if !isString {
+				return nil, errors.New("msgpack: the keys of an object must be strings")
+			}
+
+			valueToken, err := m.next()
+			if err != nil {
+				return nil, err
+			}
+
+			if res[asString], err = m.decodeGeneric(valueToken, depth+1); err != nil {
+				return nil, err
+			}
+		}
+
+		return res, nil
+	}
+}
+
+func (m *msgPackToken) describe() string {
+	switch m.kind {
+	case msgPackBool:
+		return "a boolean"
+	case msgPackInt:
+		return strconv.FormatInt(m.intValue, 10)
+	case msgPackUint:
+		return strconv.FormatUint(m.uintValue, 10)
+	case msgPackFloat:
+		return strconv.FormatFloat(m.floatValue, 'g', -1, 64)
+	case msgPackString:
+		return "a string"
+	case msgPackBinary:
+		return "a binary"
+	case msgPackArray:
+		return "an array"
+	case msgPackMap:
+		return "an object"
+	case msgPackExt:
+		return "an extension"
+	default:
+		return "null"
+	}
+}
+
+func msgPackTokenToTime(token msgPackToken) (time.Time, error) {
+	if (token.kind != msgPackExt) || (token.extType != -1) {
+		return time.Time{}, errors.New("msgpack: can't decode " + token.describe() + " into a time")
+	}
+
+	b := token.bytes
+	var sec, nsec int64
+
+	switch len(b) {
+	case 4:
+		sec = int64(binary.BigEndian.Uint32(b))
+	case 8:
+		v := binary.BigEndian.Uint64(b)
+		sec, nsec = int64(v&(1<<34-1)), int64(v>>34)
+	case 12:
+		sec, nsec = int64(binary.BigEndian.Uint64(b[4:])), int64(binary.BigEndian.Uint32(b))
+	default:
+		return time.Time{}, errors.New("msgpack: invalid timestamp")
+	}
+
+	if nsec > 999999999 {
+		return time.Time{}, errors.New("msgpack: invalid timestamp")
+	}
+
+	return time.Unix(sec, nsec), nil
+}
+
+func findMsgPackField(fields []msgPackField, name string) *msgPackField {
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+	}
+
+	// As with JSON, the case is ignored if there is no exact match.
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+
+	return nil
+}
+
+// msgPackFieldByIndex is like reflect.Value.FieldByIndex
+// but allocates the nil embedded pointers.
+func msgPackFieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, fieldIndex := range index {
+		if i > 0 && (v.Kind() == reflect.Pointer) {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+
+			v = v.Elem()
+		}
+
+		v = v.Field(fieldIndex)
+	}
+
+	return v
+}
+
+//endregion
@@ -0,0 +1,480 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package progpAPI
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+//region Round trips
+
+type msgPackTestItem struct {
+	Name  string            `json:"name"`
+	Count int               `json:"count,omitempty"`
+	Tags  []string          `json:"tags"`
+	Attrs map[string]uint16 `json:"attrs"`
+	Data  []byte            `json:"data"`
+	When  time.Time         `json:"when"`
+	Next  *msgPackTestItem  `json:"next"`
+	Skip  string            `json:"-"`
+}
+
+func TestMsgPackRoundTrip(t *testing.T) {
+	value := msgPackTestItem{
+		Name:  "first",
+		Count: 3,
+		Tags:  []string{"a", "", strings.Repeat("long", 100)},
+		Attrs: map[string]uint16{"x": 1, "y": math.MaxUint16},
+		Data:  []byte{0, 1, 2, 255},
+		When:  time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC),
+		Next:  &msgPackTestItem{Name: "second", Tags: []string{}, When: time.Unix(1, 0)},
+	}
+
+	encoded, err := MarshalMsgPack(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded msgPackTestItem
+
+	if err := UnmarshalMsgPack(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	// The times are decoded in the local time zone.
+	if !decoded.When.Equal(value.When) || !decoded.Next.When.Equal(value.Next.When) {
+		t.Fatalf("the times %v are decoded as %v", value.When, decoded.When)
+	}
+
+	decoded.When = value.When
+	decoded.Next.When = value.Next.When
+
+	if !reflect.DeepEqual(decoded, value) {
+		t.Fatalf("%+v is decoded as %+v", value, decoded)
+	}
+}
+
+func TestMsgPackDecodesIntoInterface(t *testing.T) {
+	encoded, err := MarshalMsgPack(map[string]any{
+		"int":    -5,
+		"uint":   uint64(math.MaxUint64),
+		"float":  1.5,
+		"string": "text",
+		"bytes":  []byte{1},
+		"list":   []any{true, nil},
+		"time":   time.Unix(10, 0),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded any
+
+	if err := UnmarshalMsgPack(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]any{
+		"int":    int64(-5),
+		"uint":   uint64(math.MaxUint64),
+		"float":  1.5,
+		"string": "text",
+		"bytes":  []byte{1},
+		"list":   []any{true, nil},
+		"time":   time.Unix(10, 0),
+	}
+
+	asMap := decoded.(map[string]any)
+
+	if !asMap["time"].(time.Time).Equal(expected["time"].(time.Time)) {
+		t.Fatalf("unexpected time %v", asMap["time"])
+	}
+
+	asMap["time"] = expected["time"]
+
+	if !reflect.DeepEqual(asMap, expected) {
+		t.Fatalf("unexpected value %#v", decoded)
+	}
+}
+
+func TestMsgPackIgnoresUnknownFields(t *testing.T) {
+	encoded, err := MarshalMsgPack(map[string]any{"name": "value", "unknown": []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded msgPackTestItem
+
+	if err := UnmarshalMsgPack(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Name != "value" {
+		t.Fatalf("unexpected value %+v", decoded)
+	}
+}
+
+//endregion
+
+//region Numbers
+
+func TestMsgPackIntegerBoundaries(t *testing.T) {
+	tests := []struct {
+		value int64
+		code  byte
+		size  int
+	}{
+		{0, 0x00, 1},
+		{127, 0x7f, 1},
+		{128, 0xcc, 2},
+		{math.MaxUint8, 0xcc, 2},
+		{math.MaxUint8 + 1, 0xcd, 3},
+		{math.MaxUint16, 0xcd, 3},
+		{math.MaxUint16 + 1, 0xce, 5},
+		{math.MaxUint32, 0xce, 5},
+		{math.MaxUint32 + 1, 0xcf, 9},
+		{math.MaxInt64, 0xcf, 9},
+		{-1, 0xff, 1},
+		{-32, 0xe0, 1},
+		{-33, 0xd0, 2},
+		{math.MinInt8, 0xd0, 2},
+		{math.MinInt8 - 1, 0xd1, 3},
+		{math.MinInt16, 0xd1, 3},
+		{math.MinInt16 - 1, 0xd2, 5},
+		{math.MinInt32, 0xd2, 5},
+		{math.MinInt32 - 1, 0xd3, 9},
+		{math.MinInt64, 0xd3, 9},
+	}
+
+	for _, test := range tests {
+		encoded, err := MarshalMsgPack(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (encoded[0] != test.code) || (len(encoded) != test.size) {
+			t.Fatalf("%d is encoded as % x", test.value, encoded)
+		}
+
+		var decoded int64
+
+		if err := UnmarshalMsgPack(encoded, &decoded); (err != nil) || (decoded != test.value) {
+			t.Fatalf("%d is decoded as %d (%v)", test.value, decoded, err)
+		}
+	}
+}
+
+func TestMsgPackUintBoundaries(t *testing.T) {
+	for _, value := range []uint64{0, math.MaxUint8, math.MaxUint16, math.MaxUint32, math.MaxInt64 + 1, math.MaxUint64} {
+		encoded, err := MarshalMsgPack(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded uint64
+
+		if err := UnmarshalMsgPack(encoded, &decoded); (err != nil) || (decoded != value) {
+			t.Fatalf("%d is decoded as %d (%v)", value, decoded, err)
+		}
+	}
+}
+
+func TestMsgPackNumberOverflows(t *testing.T) {
+	mustOverflow := func(value any, target any) {
+		t.Helper()
+
+		encoded, err := MarshalMsgPack(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = UnmarshalMsgPack(encoded, target)
+
+		if (err == nil) || !strings.Contains(err.Error(), "overflows") {
+			t.Fatalf("%v decoded into %T doesn't overflow (%v)", value, target, err)
+		}
+	}
+
+	mustOverflow(uint64(math.MaxUint64), new(int64))
+	mustOverflow(math.MaxInt8+1, new(int8))
+	mustOverflow(math.MinInt8-1, new(int8))
+	mustOverflow(math.MaxUint8+1, new(uint8))
+	mustOverflow(-1, new(uint))
+	mustOverflow(1.5, new(int))
+	mustOverflow(-1.0, new(uint32))
+	mustOverflow(math.Inf(1), new(int64))
+	mustOverflow(float64(math.MaxInt64), new(int64))
+
+	// Javascript numbers can be encoded as floats.
+	encoded, _ := MarshalMsgPack(42.0)
+	var asInt int
+
+	if err := UnmarshalMsgPack(encoded, &asInt); (err != nil) || (asInt != 42) {
+		t.Fatalf("42.0 is decoded as %d (%v)", asInt, err)
+	}
+}
+
+func TestMsgPackFloats(t *testing.T) {
+	encoded, _ := MarshalMsgPack(float32(1.5))
+
+	if (encoded[0] != 0xca) || (len(encoded) != 5) {
+		t.Fatalf("a float32 is encoded as % x", encoded)
+	}
+
+	for _, value := range []float64{0, -1.25, math.MaxFloat64, math.SmallestNonzeroFloat64, math.Inf(-1), math.NaN()} {
+		encoded, err := MarshalMsgPack(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (encoded[0] != 0xcb) || (len(encoded) != 9) {
+			t.Fatalf("%v is encoded as % x", value, encoded)
+		}
+
+		var decoded float64
+
+		if err := UnmarshalMsgPack(encoded, &decoded); err != nil {
+			t.Fatal(err)
+		}
+
+		if (decoded != value) && !(math.IsNaN(value) && math.IsNaN(decoded)) {
+			t.Fatalf("%v is decoded as %v", value, decoded)
+		}
+	}
+
+	// The integers can be decoded into a float.
+	encoded, _ = MarshalMsgPack(-7)
+	var asFloat float32
+
+	if err := UnmarshalMsgPack(encoded, &asFloat); (err != nil) || (asFloat != -7) {
+		t.Fatalf("-7 is decoded as %v (%v)", asFloat, err)
+	}
+}
+
+//endregion
+
+//region Timestamp extension
+
+func TestMsgPackTimestamps(t *testing.T) {
+	tests := []struct {
+		value  time.Time
+		header []byte
+		size   int
+	}{
+		// 32 bits: seconds only, which fit in 32 bits.
+		{time.Unix(0, 0), []byte{0xd6, 0xff}, 6},
+		{time.Unix(math.MaxUint32, 0), []byte{0xd6, 0xff}, 6},
+
+		// 64 bits: nanoseconds, or seconds which fit in 34 bits.
+		{time.Unix(1, 1), []byte{0xd7, 0xff}, 10},
+		{time.Unix(math.MaxUint32+1, 0), []byte{0xd7, 0xff}, 10},
+		{time.Unix(1<<34-1, 999999999), []byte{0xd7, 0xff}, 10},
+
+		// 96 bits: before 1970, or seconds which need more than 34 bits.
+		{time.Unix(-1, 0), []byte{0xc7, 12, 0xff}, 15},
+		{time.Unix(-1, 500), []byte{0xc7, 12, 0xff}, 15},
+		{time.Unix(1<<34, 0), []byte{0xc7, 12, 0xff}, 15},
+	}
+
+	for _, test := range tests {
+		encoded, err := MarshalMsgPack(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.HasPrefix(encoded, test.header) || (len(encoded) != test.size) {
+			t.Fatalf("%v is encoded as % x", test.value, encoded)
+		}
+
+		var decoded time.Time
+
+		if err := UnmarshalMsgPack(encoded, &decoded); (err != nil) || !decoded.Equal(test.value) {
+			t.Fatalf("%v is decoded as %v (%v)", test.value, decoded, err)
+		}
+	}
+}
+
+func TestMsgPackRejectsInvalidTimestamps(t *testing.T) {
+	invalid := [][]byte{
+		// Has a size which isn't 4, 8 or 12.
+		{0xd5, 0xff, 0, 0},
+
+		// Has more than 999999999 nanoseconds.
+		{0xc7, 12, 0xff, 0x3b, 0x9a, 0xca, 0x00, 0, 0, 0, 0, 0, 0, 0, 0},
+
+		// Isn't a timestamp.
+		{0xd6, 0x05, 0, 0, 0, 0},
+	}
+
+	for _, data := range invalid {
+		var decoded time.Time
+
+		if err := UnmarshalMsgPack(data, &decoded); err == nil {
+			t.Fatalf("% x is decoded as %v", data, decoded)
+		}
+	}
+}
+
+//endregion
+
+//region Malformed input
+
+func TestMsgPackRejectsTruncatedInput(t *testing.T) {
+	encoded, err := MarshalMsgPack(msgPackTestItem{
+		Name:  "name",
+		Count: 70000,
+		Tags:  []string{"tag"},
+		Attrs: map[string]uint16{"attr": 300},
+		Data:  []byte("data"),
+		When:  time.Unix(1, 1),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for size := 0; size < len(encoded); size++ {
+		var decoded msgPackTestItem
+
+		if err := UnmarshalMsgPack(encoded[:size], &decoded); err == nil {
+			t.Fatalf("the first %d bytes of % x are decoded", size, encoded)
+		}
+
+		var generic any
+
+		if err := UnmarshalMsgPack(encoded[:size], &generic); err == nil {
+			t.Fatalf("the first %d bytes of % x are decoded into an interface", size, encoded)
+		}
+	}
+}
+
+func TestMsgPackRejectsMalformedInput(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"invalid code", []byte{0xc1}},
+		{"trailing data", []byte{0x01, 0x02}},
+		{"huge string length", []byte{0xdb, 0xff, 0xff, 0xff, 0xff}},
+		{"huge array length", []byte{0xdd, 0xff, 0xff, 0xff, 0xff}},
+		{"huge map length", []byte{0xdf, 0xff, 0xff, 0xff, 0xff}},
+		{"non-string key", []byte{0x81, 0x01, 0x02}},
+		{"missing map value", []byte{0x81, 0xa1, 'a'}},
+	}
+
+	for _, test := range tests {
+		var decoded any
+
+		if err := UnmarshalMsgPack(test.data, &decoded); err == nil {
+			t.Fatalf("%s: % x is decoded as %v", test.name, test.data, decoded)
+		}
+	}
+}
+
+func TestMsgPackRejectsMismatchedTypes(t *testing.T) {
+	encoded, _ := MarshalMsgPack("text")
+	var asInt int
+
+	if err := UnmarshalMsgPack(encoded, &asInt); (err == nil) || !strings.Contains(err.Error(), "can't decode") {
+		t.Fatalf("a string is decoded into an int (%v)", err)
+	}
+
+	var nilTarget *int
+
+	if err := UnmarshalMsgPack(encoded, nilTarget); err == nil {
+		t.Fatal("a nil target is accepted")
+	}
+
+	if err := UnmarshalMsgPack(encoded, asInt); err == nil {
+		t.Fatal("a target which isn't a pointer is accepted")
+	}
+}
+
+func TestMsgPackRejectsUnsupportedValues(t *testing.T) {
+	if _, err := MarshalMsgPack(make(chan int)); err == nil {
+		t.Fatal("a channel is encoded")
+	}
+
+	type cyclic struct {
+		Next *cyclic
+	}
+
+	value := &cyclic{}
+	value.Next = value
+
+	if _, err := MarshalMsgPack(value); (err == nil) || !strings.Contains(err.Error(), "too deep") {
+		t.Fatalf("a cyclic value is encoded (%v)", err)
+	}
+}
+
+//endregion
+
+//region Results
+
+func TestMsgPackResults(t *testing.T) {
+	encoded, err := MarshalMsgPackResults(nil, false, "text", int64(jsMaxSafeInteger), []byte{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An array of 3 values, the bytes being an ArrayBuffer.
+	expected := []byte{0x93, 0xa4, 't', 'e', 'x', 't', 0xcf, 0, 0x1f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xd5, msgPackExtArrayBuffer, 1, 2}
+
+	if !bytes.Equal(encoded, expected) {
+		t.Fatalf("the results are encoded as % x", encoded)
+	}
+
+	encoded, err = MarshalMsgPackResults([]string{"a", "b"}, true, int64(-1), uint64(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A map, the 64-bit integers being BigInt.
+	expected = []byte{0x82,
+		0xa1, 'a', 0xd7, msgPackExtBigInt, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xa1, 'b', 0xd7, msgPackExtBigUint, 0, 0, 0, 0, 0, 0, 0, 1}
+
+	if !bytes.Equal(encoded, expected) {
+		t.Fatalf("the named results are encoded as % x", encoded)
+	}
+}
+
+func TestMsgPackResultsErrors(t *testing.T) {
+	if _, err := MarshalMsgPackResults([]string{"a"}, false, 1, 2); err == nil {
+		t.Fatal("the names aren't checked")
+	}
+
+	if _, err := MarshalMsgPackResults(nil, false, int64(jsMaxSafeInteger+1)); (err == nil) || !strings.Contains(err.Error(), "javascript number") {
+		t.Fatalf("an int64 too big for a number is accepted (%v)", err)
+	}
+
+	if _, err := MarshalMsgPackResults(nil, false, uint(jsMaxSafeInteger+1)); err == nil {
+		t.Fatal("an uint too big for a number is accepted")
+	}
+
+	if _, err := MarshalMsgPackResults(nil, true, int64(math.MinInt64)); err != nil {
+		t.Fatalf("a BigInt is rejected (%v)", err)
+	}
+}
+
+//endregion
@@ -0,0 +1,89 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package progpAPI
+
+import (
+	"context"
+	"reflect"
+	"strings"
+)
+
+// Optional is a parameter which javascript can omit, or set to null or undefined.
+// Unlike a pointer, it allows knowing if a value like 0 or "" has been given.
+// When the optional parameters are the last ones, they can be omitted by javascript.
+type Optional[T any] struct {
+	Value T
+	IsSet bool
+}
+
+// Some returns an Optional which value is set.
+func Some[T any](value T) Optional[T] {
+	return Optional[T]{Value: value, IsSet: true}
+}
+
+// Get returns the value and true if the value is set.
+func (m Optional[T]) Get() (T, bool) {
+	return m.Value, m.IsSet
+}
+
+// OrDefault returns the value if set, otherwise defaultValue.
+func (m Optional[T]) OrDefault(defaultValue T) T {
+	if m.IsSet {
+		return m.Value
+	}
+
+	return defaultValue
+}
+
+var gOptionalPkgPath = reflect.TypeOf(Optional[int]{}).PkgPath()
+
+// IsOptionalType returns true if the type is an instance of progpAPI.Optional.
+func IsOptionalType(goType reflect.Type) bool {
+	return (goType.Kind() == reflect.Struct) && (goType.PkgPath() == gOptionalPkgPath) && strings.HasPrefix(goType.Name(), "Optional[")
+}
+
+// GetNullableParamValueType returns the type of the value of a parameter which can
+// be null or undefined in javascript, or nil if the parameter can't be.
+// It's the case for the pointers and progpAPI.Optional, except the resources
+// handled by the engine like *progpAPI.SharedResource, and the instances of classes.
+func GetNullableParamValueType(paramType reflect.Type) reflect.Type {
+	if IsOptionalType(paramType) {
+		return paramType.Field(0).Type
+	}
+
+	if isClassType(paramType) {
+		return nil
+	}
+
+	if (paramType.Kind() == reflect.Pointer) && (paramType != gSharedResourceType) && (paramType != gSharedResourceContainerType) {
+		return paramType.Elem()
+	}
+
+	return nil
+}
+
+var gSharedResourceType = reflect.TypeOf((*SharedResource)(nil))
+var gSharedResourceContainerType = reflect.TypeOf((*SharedResourceContainer)(nil))
+
+var gGoContextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// isImplicitParamType returns true if the parameter is given by the engine
+// and not by javascript, which is the case of *progpAPI.SharedResourceContainer
+// and context.Context.
+func isImplicitParamType(paramType reflect.Type) bool {
+	return (paramType == gSharedResourceContainerType) || (paramType == gGoContextType)
+}
@@ -0,0 +1,122 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package progpAPI
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// The packed format is used by the generated code in order to exchange []string and
+// map[string]string with the C++ part in one buffer. Each string is stored as his
+// size, an uint32 little endian, followed by his UTF-8 bytes. For a map, keys and
+// values are alternating.
+
+// PackStringList encodes a list of strings with the packed format.
+func PackStringList(values []string) []byte {
+	size := 0
+	for _, value := range values {
+		size += 4 + len(value)
+	}
+
+	res := make([]byte, 0, size)
+
+	for _, value := range values {
+		res = appendPackedString(res, value)
+	}
+
+	return res
+}
+
+// UnpackStringList decodes a list of strings encoded with the packed format.
+// An incomplete item at the end of the buffer is ignored.
+func UnpackStringList(data []byte) []string {
+	var res []string
+
+	for {
+		value, rest, ok := readPackedString(data)
+		if !ok {
+			return res
+		}
+
+		res = append(res, value)
+		data = rest
+	}
+}
+
+// PackStringMap encodes a map with the packed format.
+// Keys are sorted, which allows always having the same result.
+func PackStringMap(values map[string]string) []byte {
+	keys := make([]string, 0, len(values))
+	size := 0
+
+	for key, value := range values {
+		keys = append(keys, key)
+		size += 8 + len(key) + len(value)
+	}
+
+	sort.Strings(keys)
+
+	res := make([]byte, 0, size)
+
+	for _, key := range keys {
+		res = appendPackedString(res, key)
+		res = appendPackedString(res, values[key])
+	}
+
+	return res
+}
+
+// UnpackStringMap decodes a map encoded with the packed format.
+func UnpackStringMap(data []byte) map[string]string {
+	res := make(map[string]string)
+
+	for {
+		key, rest, ok := readPackedString(data)
+		if !ok {
+			return res
+		}
+
+		value, rest, ok := readPackedString(rest)
+		if !ok {
+			return res
+		}
+
+		res[key] = value
+		data = rest
+	}
+}
+
+func appendPackedString(buffer []byte, value string) []byte {
+	buffer = binary.LittleEndian.AppendUint32(buffer, uint32(len(value)))
+	return append(buffer, value...)
+}
+
+func readPackedString(data []byte) (string, []byte, bool) {
+	if len(data) < 4 {
+		return "", nil, false
+	}
+
+	size := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+
+	if uint64(size) > uint64(len(data)) {
+		return "", nil, false
+	}
+
+	return string(data[:size]), data[size:], true
+}
@@ -44,6 +44,7 @@ type ScriptEngine interface {
 
 	// Shutdown stop the engine. He can't be used anymore after that.
 	// It mainly occurs after a fatal error or at script ends.
+	// The implementation must call OnScriptEngineShutdown.
 	Shutdown()
 
 	// CreateNewScriptContext creates a new context which can be used
@@ -141,6 +142,7 @@ type JsContext interface {
 	// TryDispose destroy the context and free his resources.
 	// It's do nothing if this context can't be disposed, for
 	// example if the engine only support one context.
+	// The implementation must call OnScriptContextDisposed when disposing.
 	//
 	TryDispose() bool
 
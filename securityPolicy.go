@@ -0,0 +1,671 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package progpAPI
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// A SecurityPolicy tells which functions the scripts of a security group can use.
+// It's loaded from a JSON file and compiled into a CheckAllowedFunctionsF,
+// which is given to ScriptEngine.SetAllowedFunctionsChecker.
+//
+// Example:
+//
+//	{
+//	    "securityGroups": {
+//	        "restricted": {
+//	            "rules": [
+//	                { "effect": "deny", "functionGroup": "fs", "function": "write*" },
+//	                { "effect": "allow", "functionGroup": "fs" }
+//	            ]
+//	        },
+//	        "tenant": {
+//	            "extends": ["restricted"],
+//	            "rules": [
+//	                { "effect": "allow", "functionGroup": "http", "function": "fetch" },
+//	                { "effect": "allow", "capability": "net.*" }
+//	            ]
+//	        }
+//	    }
+//	}
+//
+// The rules of a security group are checked in order and the first matching rule wins.
+// If none matches, the rules of the security groups it extends are checked, in order.
+// If still none matches, the default effect is used, which is "deny" if not set.
+//
+// The policy can also be written with a YAML-like format, which is the subset of YAML
+// required by the policies: maps, lists of maps, lists of strings, comments, and strings
+// which can be quoted. Lists can also be written inline, like an empty map with {}.
+// It avoids depending on a YAML library.
+//
+//	securityGroups:
+//	  restricted:
+//	    rules:
+//	      - effect: deny
+//	        functionGroup: fs
+//	        function: "write*"
+//	      - effect: allow
+//	        functionGroup: fs
+//	  tenant:
+//	    extends: [restricted]
+//	    rules:
+//	      - effect: allow
+//	        capability: "net.*"
+
+//region SecurityPolicy
+
+type SecurityEffect string
+
+const (
+	SecurityEffectAllow SecurityEffect = "allow"
+	SecurityEffectDeny  SecurityEffect = "deny"
+)
+
+// SecurityRule allows or denies the functions matching his patterns.
+// The patterns use the syntax of path.Match, and an empty pattern matches everything.
+//
+// The capability pattern applies to the capabilities of the function, see
+// RegisteredFunction.WithCapabilities. A rule allowing a capability only matches if
+// all the capabilities of the function match, while a rule denying a capability matches
+// if one of them does. A function without capability never matches this pattern.
+type SecurityRule struct {
+	Effect        SecurityEffect `json:"effect"`
+	FunctionGroup string         `json:"functionGroup,omitempty"`
+	Function      string         `json:"function,omitempty"`
+	Capability    string         `json:"capability,omitempty"`
+}
+
+type SecurityGroupPolicy struct {
+	Extends []string       `json:"extends,omitempty"`
+	Rules   []SecurityRule `json:"rules,omitempty"`
+}
+
+type SecurityPolicy struct {
+	// DefaultEffect is used when no rule matches. It's "deny" if not set.
+	DefaultEffect  SecurityEffect                  `json:"defaultEffect,omitempty"`
+	SecurityGroups map[string]*SecurityGroupPolicy `json:"securityGroups"`
+}
+
+// ParseSecurityPolicy decodes a policy from his JSON or YAML-like content and checks it.
+// The content is JSON if it starts with '{'. The unknown fields are errors,
+// which avoids ignoring a misspelled rule.
+func ParseSecurityPolicy(content []byte) (*SecurityPolicy, error) {
+	trimmed := bytes.TrimSpace(content)
+
+	if len(trimmed) == 0 {
+		return nil, errors.New("security policy: the content is empty")
+	}
+
+	if trimmed[0] != '{' {
+		asJson, err := yamlLikeToJson(string(content))
+		if err != nil {
+			return nil, errors.New("security policy: " + err.Error())
+		}
+
+		content = asJson
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+
+	policy := &SecurityPolicy{}
+
+	if err := decoder.Decode(policy); err != nil {
+		return nil, errors.New("security policy: " + err.Error())
+	}
+
+	if err := policy.Check(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// LoadSecurityPolicy is like ParseSecurityPolicy but reads the policy from a file.
+func LoadSecurityPolicy(filePath string) (*SecurityPolicy, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSecurityPolicy(content)
+}
+
+// Check returns an error if the policy has an invalid effect or pattern, has a rule
+// targeting a method, extends an unknown security group, or has an inheritance loop.
+func (m *SecurityPolicy) Check() error {
+	if (m.DefaultEffect != "") && (m.DefaultEffect != SecurityEffectAllow) && (m.DefaultEffect != SecurityEffectDeny) {
+		return errors.New("security policy: invalid default effect \"" + string(m.DefaultEffect) + "\"")
+	}
+
+	for name, group := range m.SecurityGroups {
+		if group == nil {
+			return errors.New("security policy: security group " + name + " is null")
+		}
+
+		for _, parent := range group.Extends {
+			if m.SecurityGroups[parent] == nil {
+				return errors.New("security policy: security group " + name + " extends the unknown security group " + parent)
+			}
+		}
+
+		for i, rule := range group.Rules {
+			ruleName := "security policy: rule " + strconv.Itoa(i+1) + " of security group " + name
+
+			if (rule.Effect != SecurityEffectAllow) && (rule.Effect != SecurityEffectDeny) {
+				return errors.New(ruleName + ": invalid effect \"" + string(rule.Effect) + "\"")
+			}
+
+			if _, err := path.Match(rule.FunctionGroup, ""); err != nil {
+				return errors.New(ruleName + ": invalid function group pattern \"" + rule.FunctionGroup + "\"")
+			}
+
+			if _, err := path.Match(rule.Function, ""); err != nil {
+				return errors.New(ruleName + ": invalid function pattern \"" + rule.Function + "\"")
+			}
+
+			if strings.Contains(rule.Function, ".") {
+				// Is "MyClass.myMethod", see FunctionModule.AddClass.
+				return errors.New(ruleName + ": the function pattern \"" + rule.Function + "\" targets a method, but the methods are allowed with their class")
+			}
+
+			if _, err := path.Match(rule.Capability, ""); err != nil {
+				return errors.New(ruleName + ": invalid capability pattern \"" + rule.Capability + "\"")
+			}
+		}
+
+		if err := m.checkInheritanceLoop(name, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *SecurityPolicy) checkInheritanceLoop(name string, chain []string) error {
+	for _, e := range chain {
+		if e == name {
+			return errors.New("security policy: inheritance loop " + strings.Join(append(chain, name), " -> "))
+		}
+	}
+
+	chain = append(chain, name)
+
+	for _, parent := range m.SecurityGroups[name].Extends {
+		if err := m.checkInheritanceLoop(parent, chain); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Matches returns true if the rule applies to this function.
+func (m *SecurityRule) Matches(functionGroup string, functionName string) bool {
+	if !matchSecurityPattern(m.FunctionGroup, functionGroup) || !matchSecurityPattern(m.Function, functionName) {
+		return false
+	}
+
+	if m.Capability == "" {
+		return true
+	}
+
+	fct := GetFunctionRegistry().GetFunction(functionGroup, functionName)
+
+	if (fct == nil) || (len(fct.Capabilities) == 0) {
+		return false
+	}
+
+	for _, capability := range fct.Capabilities {
+		matched := matchSecurityPattern(m.Capability, capability)
+
+		if matched && (m.Effect == SecurityEffectDeny) {
+			return true
+		} else if !matched && (m.Effect == SecurityEffectAllow) {
+			return false
+		}
+	}
+
+	return m.Effect == SecurityEffectAllow
+}
+
+func matchSecurityPattern(pattern string, value string) bool {
+	if pattern == "" {
+		return true
+	}
+
+	matched, _ := path.Match(pattern, value)
+	return matched
+}
+
+// ToChecker compiles the policy into a function which can be given
+// to ScriptEngine.SetAllowedFunctionsChecker.
+func (m *SecurityPolicy) ToChecker() CheckAllowedFunctionsF {
+	return func(securityGroup string, functionGroup string, functionName string) bool {
+		return m.IsAllowed(securityGroup, functionGroup, functionName)
+	}
+}
+
+// IsAllowed returns true if the scripts of this security group can use this function.
+func (m *SecurityPolicy) IsAllowed(securityGroup string, functionGroup string, functionName string) bool {
+	return m.Explain(securityGroup, functionGroup, functionName).Allowed
+}
+
+//endregion
+
+//region SecurityDecision
+
+// SecurityDecision tells why a function is allowed or denied.
+type SecurityDecision struct {
+	Allowed bool
+
+	// SecurityGroup is the security group declaring the matching rule.
+	// It's a parent of the security group checked when the rule is inherited.
+	SecurityGroup string
+
+	// RuleIndex is the index of the matching rule, or -1 if the default effect is used.
+	RuleIndex int
+	Rule      *SecurityRule
+}
+
+// String returns a description of the decision, for example:
+// "allowed by rule 2 of security group restricted (allow fs.*)".
+func (m SecurityDecision) String() string {
+	res := "denied"
+
+	if m.Allowed {
+		res = "allowed"
+	}
+
+	if m.Rule == nil {
+		return res + " by the default effect"
+	}
+
+	functionGroup := m.Rule.FunctionGroup
+	if functionGroup == "" {
+		functionGroup = "*"
+	}
+
+	function := m.Rule.Function
+	if function == "" {
+		function = "*"
+	}
+
+	res += " by rule " + strconv.Itoa(m.RuleIndex+1) + " of security group " + m.SecurityGroup +
+		" (" + string(m.Rule.Effect) + " " + functionGroup + "." + function
+
+	if m.Rule.Capability != "" {
+		res += " capability " + m.Rule.Capability
+	}
+
+	return res + ")"
+}
+
+// Explain returns the decision for this function and the rule which has been used.
+func (m *SecurityPolicy) Explain(securityGroup string, functionGroup string, functionName string) SecurityDecision {
+	if decision, found := m.explainGroup(securityGroup, functionGroup, functionName, nil); found {
+		return decision
+	}
+
+	return SecurityDecision{Allowed: m.DefaultEffect == SecurityEffectAllow, RuleIndex: -1}
+}
+
+func (m *SecurityPolicy) explainGroup(securityGroup string, functionGroup string, functionName string, visited map[string]bool) (SecurityDecision, bool) {
+	group := m.SecurityGroups[securityGroup]
+
+	if (group == nil) || visited[securityGroup] {
+		return SecurityDecision{}, false
+	}
+
+	if visited == nil {
+		visited = make(map[string]bool)
+	}
+
+	// Avoids looping if the policy has been modified after being checked.
+	visited[securityGroup] = true
+
+	for i := range group.Rules {
+		rule := &group.Rules[i]
+
+		if rule.Matches(functionGroup, functionName) {
+			return SecurityDecision{
+				Allowed:       rule.Effect == SecurityEffectAllow,
+				SecurityGroup: securityGroup,
+				RuleIndex:     i,
+				Rule:          rule,
+			}, true
+		}
+	}
+
+	for _, parent := range group.Extends {
+		if decision, found := m.explainGroup(parent, functionGroup, functionName, visited); found {
+			return decision, true
+		}
+	}
+
+	return SecurityDecision{}, false
+}
+
+//endregion
+
+//region YAML-like format
+
+type yamlLikeLine struct {
+	number  int
+	indent  int
+	content string
+}
+
+type yamlLikeParser struct {
+	lines  []yamlLikeLine
+	offset int
+}
+
+// yamlLikeToJson converts the YAML-like content of a policy into JSON.
+// The values are always strings, the fields being checked by the JSON decoder.
+func yamlLikeToJson(content string) ([]byte, error) {
+	parser := &yamlLikeParser{}
+
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(stripYamlLikeComment(line), " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+
+		if trimmed == "" {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, errors.New("line " + strconv.Itoa(i+1) + ": tabs can't be used for the indentation")
+		}
+
+		parser.lines = append(parser.lines, yamlLikeLine{number: i + 1, indent: len(line) - len(trimmed), content: trimmed})
+	}
+
+	if len(parser.lines) == 0 {
+		return []byte("{}"), nil
+	}
+
+	value, err := parser.parseBlock(parser.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+
+	if parser.offset < len(parser.lines) {
+		return nil, parser.errorAt("unexpected indentation")
+	}
+
+	return json.Marshal(value)
+}
+
+// stripYamlLikeComment removes the comment, which starts with a '#'
+// at the beginning of the line or after a space, outside a string.
+func stripYamlLikeComment(line string) string {
+	var quote rune
+
+	for i, c := range line {
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+		} else if (c == '"') || (c == '\'') {
+			quote = c
+		} else if (c == '#') && ((i == 0) || (line[i-1] == ' ') || (line[i-1] == '\t')) {
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+func (m *yamlLikeParser) errorAt(message string) error {
+	return errors.New("line " + strconv.Itoa(m.lines[m.offset].number) + ": " + message)
+}
+
+// parseBlock parses the map or the list starting at the current line.
+func (m *yamlLikeParser) parseBlock(indent int) (any, error) {
+	if isYamlLikeListItem(m.lines[m.offset].content) {
+		return m.parseList(indent)
+	}
+
+	return m.parseMap(indent)
+}
+
+func isYamlLikeListItem(content string) bool {
+	return (content == "-") || strings.HasPrefix(content, "- ")
+}
+
+func (m *yamlLikeParser) parseMap(indent int) (any, error) {
+	res := make(map[string]any)
+
+	for m.offset < len(m.lines) {
+		line := m.lines[m.offset]
+
+		if line.indent < indent {
+			break
+		} else if line.indent > indent {
+			return nil, m.errorAt("unexpected indentation")
+		} else if isYamlLikeListItem(line.content) {
+			return nil, m.errorAt("a list item is not expected here")
+		}
+
+		key, value, isKeyValue := splitYamlLikeKeyValue(line.content)
+		if !isKeyValue {
+			return nil, m.errorAt("\"key: value\" is expected")
+		}
+
+		if key == "" {
+			return nil, m.errorAt("the key is empty")
+		}
+
+		key, err := parseYamlLikeScalar(key)
+		if err != nil {
+			return nil, m.errorAt(err.Error())
+		}
+
+		if _, exists := res[key]; exists {
+			return nil, m.errorAt("the key " + key + " is duplicated")
+		}
+
+		if value != "" {
+			if res[key], err = parseYamlLikeValue(value); err != nil {
+				return nil, m.errorAt(err.Error())
+			}
+
+			m.offset++
+			continue
+		}
+
+		m.offset++
+
+		if m.offset == len(m.lines) {
+			res[key] = nil
+			continue
+		}
+
+		next := m.lines[m.offset]
+
+		if (next.indent > indent) || ((next.indent == indent) && isYamlLikeListItem(next.content)) {
+			// A list can have the indentation of his key.
+			if res[key], err = m.parseBlock(next.indent); err != nil {
+				return nil, err
+			}
+		} else {
+			res[key] = nil
+		}
+	}
+
+	return res, nil
+}
+
+func (m *yamlLikeParser) parseList(indent int) (any, error) {
+	res := make([]any, 0)
+
+	for m.offset < len(m.lines) {
+		line := m.lines[m.offset]
+
+		if (line.indent < indent) || ((line.indent == indent) && !isYamlLikeListItem(line.content)) {
+			break
+		} else if line.indent > indent {
+			return nil, m.errorAt("unexpected indentation")
+		}
+
+		item := strings.TrimLeft(strings.TrimPrefix(line.content, "-"), " ")
+
+		if item == "" {
+			m.offset++
+
+			if (m.offset == len(m.lines)) || (m.lines[m.offset].indent <= indent) {
+				res = append(res, nil)
+				continue
+			}
+
+			value, err := m.parseBlock(m.lines[m.offset].indent)
+			if err != nil {
+				return nil, err
+			}
+
+			res = append(res, value)
+		} else if _, _, isKeyValue := splitYamlLikeKeyValue(item); isKeyValue {
+			// Is a map starting on the line of the item, which
+			// other keys are aligned with the first one.
+			itemIndent := line.indent + len(line.content) - len(item)
+			m.lines[m.offset] = yamlLikeLine{number: line.number, indent: itemIndent, content: item}
+
+			value, err := m.parseMap(itemIndent)
+			if err != nil {
+				return nil, err
+			}
+
+			res = append(res, value)
+		} else {
+			value, err := parseYamlLikeValue(item)
+			if err != nil {
+				return nil, m.errorAt(err.Error())
+			}
+
+			res = append(res, value)
+			m.offset++
+		}
+	}
+
+	return res, nil
+}
+
+// splitYamlLikeKeyValue splits "key: value", where the key can be quoted.
+func splitYamlLikeKeyValue(content string) (string, string, bool) {
+	start := 0
+
+	if (content[0] == '"') || (content[0] == '\'') {
+		end := strings.IndexByte(content[1:], content[0])
+		if end == -1 {
+			return "", "", false
+		}
+
+		start = end + 2
+	}
+
+	separator := strings.Index(content[start:], ":")
+
+	for separator != -1 {
+		end := start + separator + 1
+
+		if (end == len(content)) || (content[end] == ' ') {
+			return content[:start+separator], strings.TrimSpace(content[end:]), true
+		}
+
+		next := strings.Index(content[end:], ":")
+		if next == -1 {
+			break
+		}
+
+		separator += next + 1
+	}
+
+	return "", "", false
+}
+
+// parseYamlLikeValue parses a string, an inline list of strings like "[a, b]", or an empty map "{}".
+func parseYamlLikeValue(value string) (any, error) {
+	if strings.HasPrefix(value, "{") && strings.HasSuffix(value, "}") && (strings.TrimSpace(value[1:len(value)-1]) == "") {
+		return make(map[string]any), nil
+	}
+
+	if !strings.HasPrefix(value, "[") {
+		return parseYamlLikeScalar(value)
+	}
+
+	if !strings.HasSuffix(value, "]") {
+		return nil, errors.New("the list " + value + " isn't closed")
+	}
+
+	res := make([]any, 0)
+	content := strings.TrimSpace(value[1 : len(value)-1])
+
+	if content == "" {
+		return res, nil
+	}
+
+	for _, item := range strings.Split(content, ",") {
+		asString, err := parseYamlLikeScalar(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, asString)
+	}
+
+	return res, nil
+}
+
+func parseYamlLikeScalar(value string) (string, error) {
+	if value == "" {
+		// Is an item of an inline list like "[a, ]".
+		return "", errors.New("a value is empty")
+	}
+
+	if strings.HasPrefix(value, "\"") {
+		res, err := strconv.Unquote(value)
+		if err != nil {
+			return "", errors.New("the string " + value + " is invalid")
+		}
+
+		return res, nil
+	}
+
+	if strings.HasPrefix(value, "'") {
+		if (len(value) < 2) || !strings.HasSuffix(value, "'") {
+			return "", errors.New("the string " + value + " isn't closed")
+		}
+
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	}
+
+	if strings.ContainsAny(value[:1], "{&*!|>%@`") {
+		return "", errors.New("the value " + value + " uses an unsupported YAML syntax")
+	}
+
+	return value, nil
+}
+
+//endregion
@@ -0,0 +1,363 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package progpAPI
+
+import (
+	"strings"
+	"testing"
+)
+
+func PolicyTestRead()  {}
+func PolicyTestWrite() {}
+func PolicyTestFetch() {}
+func PolicyTestPlain() {}
+
+func init() {
+	group := GetFunctionRegistry().UseGoNamespace("github.com/progpjs/progpAPI/v2/policyTest").UseCustomGroup("policyTest")
+
+	group.AddFunction("read", "PolicyTestRead", PolicyTestRead).WithCapabilities("fs.read")
+	group.AddFunction("write", "PolicyTestWrite", PolicyTestWrite).WithCapabilities("fs.read", "fs.write")
+	group.AddFunction("fetch", "PolicyTestFetch", PolicyTestFetch).WithCapabilities("net.dial")
+	group.AddFunction("plain", "PolicyTestPlain", PolicyTestPlain)
+}
+
+//region Parsing
+
+const gPolicyTestJson = `{
+	"securityGroups": {
+		"restricted": {
+			"rules": [
+				{ "effect": "deny", "functionGroup": "fs", "function": "write*" },
+				{ "effect": "allow", "functionGroup": "fs" }
+			]
+		},
+		"tenant": {
+			"extends": ["restricted"],
+			"rules": [
+				{ "effect": "allow", "functionGroup": "http", "function": "fetch" }
+			]
+		}
+	}
+}`
+
+const gPolicyTestYaml = `
+# The same policy as gPolicyTestJson.
+securityGroups:
+  restricted:
+    rules:
+      - effect: deny
+        functionGroup: fs
+        function: "write*"   # A comment after a value.
+      - effect: allow
+        functionGroup: 'fs'
+  tenant:
+    extends: [restricted]
+    rules:
+    - effect: allow
+      functionGroup: http
+      function: fetch
+`
+
+func TestParseSecurityPolicyFormats(t *testing.T) {
+	fromJson, err := ParseSecurityPolicy([]byte(gPolicyTestJson))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromYaml, err := ParseSecurityPolicy([]byte(gPolicyTestYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, policy := range []*SecurityPolicy{fromJson, fromYaml} {
+		tenant := policy.SecurityGroups["tenant"]
+
+		if (len(tenant.Extends) != 1) || (tenant.Extends[0] != "restricted") || (len(tenant.Rules) != 1) {
+			t.Fatalf("unexpected security group %+v", tenant)
+		}
+
+		restricted := policy.SecurityGroups["restricted"]
+
+		if (len(restricted.Rules) != 2) || (restricted.Rules[0] != SecurityRule{Effect: SecurityEffectDeny, FunctionGroup: "fs", Function: "write*"}) {
+			t.Fatalf("unexpected security group %+v", restricted)
+		}
+	}
+}
+
+func TestParseValidSecurityPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		groups  int
+	}{
+		{"empty JSON", `{}`, 0},
+		{"comments only", "# nothing\n", 0},
+		{"empty map", "securityGroups:\n  restricted: {}\n", 1},
+		{"empty inline map", "securityGroups:\n  restricted: { }\n", 1},
+		{"empty inline list", "securityGroups:\n  restricted:\n    extends: []\n", 1},
+		{"default effect", "defaultEffect: allow\nsecurityGroups: {}\n", 0},
+		{"quoted key", "securityGroups:\n  \"my group\":\n    rules:\n      - effect: allow\n", 1},
+		{"colon in a value", "securityGroups:\n  a:\n    rules:\n      - effect: allow\n        function: \"x:y\"\n", 1},
+		{"windows line endings", "securityGroups:\r\n  a:\r\n    rules:\r\n      - effect: deny\r\n", 1},
+	}
+
+	for _, test := range tests {
+		policy, err := ParseSecurityPolicy([]byte(test.content))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		if len(policy.SecurityGroups) != test.groups {
+			t.Fatalf("%s: %d security groups instead of %d", test.name, len(policy.SecurityGroups), test.groups)
+		}
+	}
+}
+
+func TestParseInvalidSecurityPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"empty", "", "the content is empty"},
+		{"blank", " \n\t\n", "the content is empty"},
+		{"invalid JSON", `{ "securityGroups": `, "security policy:"},
+		{"unknown field", `{ "securityGroup": {} }`, "unknown field"},
+		{"unknown YAML field", "securityGroups:\n  a:\n    rule: []\n", "unknown field"},
+		{"empty list item", "securityGroups:\n  a:\n    extends: [restricted, ]\n", "line 3: a value is empty"},
+		{"only a comma", "securityGroups:\n  a:\n    extends: [,]\n", "line 3: a value is empty"},
+		{"empty key", "securityGroups:\n  : x\n", "line 2: the key is empty"},
+		{"unclosed list", "securityGroups:\n  a:\n    extends: [b\n", "line 3: the list [b isn't closed"},
+		{"unclosed string", "defaultEffect: 'allow\n", "line 1: the string 'allow isn't closed"},
+		{"invalid string", "defaultEffect: \"al\\low\"\n", "line 1: the string"},
+		{"flow map", "securityGroups: {a: {}}\n", "line 1: the value {a: {}} uses an unsupported YAML syntax"},
+		{"anchor", "defaultEffect: &x allow\n", "unsupported YAML syntax"},
+		{"tab", "securityGroups:\n\ta: {}\n", "line 2: tabs can't be used"},
+		{"missing colon", "securityGroups:\n  a\n", "line 2: \"key: value\" is expected"},
+		{"duplicated key", "securityGroups:\n  a: {}\n  a: {}\n", "line 3: the key a is duplicated"},
+		{"bad indentation", "securityGroups:\n  a: {}\n    b: {}\n", "line 3: unexpected indentation"},
+		{"unexpected list item", "securityGroups:\n  a: {}\n  - b\n", "line 3: a list item is not expected here"},
+		{"invalid default effect", `{ "defaultEffect": "maybe" }`, "invalid default effect \"maybe\""},
+		{"invalid effect", "securityGroups:\n  a:\n    rules:\n      - effect: maybe\n", "rule 1 of security group a: invalid effect \"maybe\""},
+		{"invalid pattern", "securityGroups:\n  a:\n    rules:\n      - effect: allow\n        functionGroup: \"[\"\n", "invalid function group pattern"},
+		{"method", "securityGroups:\n  a:\n    rules:\n      - effect: allow\n        function: Counter.inc\n", "targets a method"},
+		{"null group", `{ "securityGroups": { "a": null } }`, "security group a is null"},
+		{"group without value", "securityGroups:\n  a:\n", "security group a is null"},
+		{"unknown parent", "securityGroups:\n  a:\n    extends: [b]\n", "security group a extends the unknown security group b"},
+		{"self inheritance", "securityGroups:\n  a:\n    extends: [a]\n", "inheritance loop a -> a"},
+	}
+
+	for _, test := range tests {
+		_, err := ParseSecurityPolicy([]byte(test.content))
+
+		if err == nil {
+			t.Fatalf("%s: no error", test.name)
+		}
+
+		if !strings.Contains(err.Error(), test.expected) {
+			t.Fatalf("%s: the error %q doesn't contain %q", test.name, err.Error(), test.expected)
+		}
+	}
+}
+
+func TestSecurityPolicyInheritanceLoop(t *testing.T) {
+	_, err := ParseSecurityPolicy([]byte("securityGroups:\n  a:\n    extends: [b]\n  b:\n    extends: [c]\n  c:\n    extends: [a]\n"))
+
+	if (err == nil) || !strings.Contains(err.Error(), "inheritance loop") {
+		t.Fatalf("the loop isn't detected (%v)", err)
+	}
+
+	// The loop is described from the group checked first, which depends on the map order.
+	for _, loop := range []string{"a -> b -> c -> a", "b -> c -> a -> b", "c -> a -> b -> c"} {
+		if strings.Contains(err.Error(), loop) {
+			return
+		}
+	}
+
+	t.Fatalf("unexpected loop description %q", err.Error())
+}
+
+//endregion
+
+//region Decisions
+
+func TestSecurityPolicyExtendsChain(t *testing.T) {
+	policy, err := ParseSecurityPolicy([]byte(`
+securityGroups:
+  base:
+    rules:
+      - effect: allow
+        functionGroup: log
+  restricted:
+    extends: [base]
+    rules:
+      - effect: deny
+        functionGroup: fs
+        function: "write*"
+      - effect: allow
+        functionGroup: fs
+  other:
+    rules:
+      - effect: allow
+        functionGroup: http
+  tenant:
+    extends: [restricted, other]
+    rules:
+      - effect: deny
+        functionGroup: log
+        function: debug
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		securityGroup string
+		functionGroup string
+		function      string
+		allowed       bool
+		decidedBy     string
+		ruleIndex     int
+	}{
+		{"tenant", "log", "debug", false, "tenant", 0},
+		{"tenant", "log", "info", true, "base", 0},
+		{"tenant", "fs", "writeFile", false, "restricted", 0},
+		{"tenant", "fs", "readFile", true, "restricted", 1},
+		{"tenant", "http", "fetch", true, "other", 0},
+		{"tenant", "process", "spawn", false, "", -1},
+		{"restricted", "log", "debug", true, "base", 0},
+		{"restricted", "http", "fetch", false, "", -1},
+		{"unknown", "log", "info", false, "", -1},
+	}
+
+	for _, test := range tests {
+		decision := policy.Explain(test.securityGroup, test.functionGroup, test.function)
+
+		if (decision.Allowed != test.allowed) || (decision.SecurityGroup != test.decidedBy) || (decision.RuleIndex != test.ruleIndex) {
+			t.Fatalf("%s calling %s.%s: unexpected decision %+v", test.securityGroup, test.functionGroup, test.function, decision)
+		}
+
+		if policy.IsAllowed(test.securityGroup, test.functionGroup, test.function) != test.allowed {
+			t.Fatalf("%s calling %s.%s: IsAllowed doesn't follow Explain", test.securityGroup, test.functionGroup, test.function)
+		}
+
+		if policy.ToChecker()(test.securityGroup, test.functionGroup, test.function) != test.allowed {
+			t.Fatalf("%s calling %s.%s: the checker doesn't follow Explain", test.securityGroup, test.functionGroup, test.function)
+		}
+	}
+}
+
+func TestSecurityPolicyDefaultEffect(t *testing.T) {
+	policy, err := ParseSecurityPolicy([]byte("defaultEffect: allow\nsecurityGroups:\n  a:\n    rules:\n      - effect: deny\n        functionGroup: fs\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !policy.IsAllowed("a", "http", "fetch") || !policy.IsAllowed("unknown", "fs", "read") {
+		t.Fatal("the default effect isn't used")
+	}
+
+	if policy.IsAllowed("a", "fs", "read") {
+		t.Fatal("the rule isn't used before the default effect")
+	}
+}
+
+func TestSecurityPolicyCapabilities(t *testing.T) {
+	policy, err := ParseSecurityPolicy([]byte(`
+securityGroups:
+  reader:
+    rules:
+      - effect: allow
+        capability: "fs.read"
+  noWrite:
+    rules:
+      - effect: deny
+        capability: "fs.write"
+      - effect: allow
+        functionGroup: policyTest
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		securityGroup string
+		function      string
+		allowed       bool
+	}{
+		// All the capabilities of the function must be allowed.
+		{"reader", "read", true},
+		{"reader", "write", false},
+		{"reader", "fetch", false},
+
+		// A function without capability never matches a capability rule.
+		{"reader", "plain", false},
+
+		// One denied capability is enough.
+		{"noWrite", "write", false},
+		{"noWrite", "read", true},
+		{"noWrite", "plain", true},
+	}
+
+	for _, test := range tests {
+		if policy.IsAllowed(test.securityGroup, "policyTest", test.function) != test.allowed {
+			t.Fatalf("%s calling %s: allowed isn't %v", test.securityGroup, test.function, test.allowed)
+		}
+	}
+}
+
+func TestSecurityDecisionString(t *testing.T) {
+	policy, err := ParseSecurityPolicy([]byte(`
+securityGroups:
+  restricted:
+    rules:
+      - effect: deny
+        functionGroup: fs
+        function: "write*"
+      - effect: allow
+        functionGroup: fs
+      - effect: allow
+        capability: "net.*"
+  tenant:
+    extends: [restricted]
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		functionGroup string
+		function      string
+		expected      string
+	}{
+		{"fs", "writeFile", "denied by rule 1 of security group restricted (deny fs.write*)"},
+		{"fs", "readFile", "allowed by rule 2 of security group restricted (allow fs.*)"},
+		{"policyTest", "fetch", "allowed by rule 3 of security group restricted (allow *.* capability net.*)"},
+		{"http", "fetch", "denied by the default effect"},
+	}
+
+	for _, test := range tests {
+		if description := policy.Explain("tenant", test.functionGroup, test.function).String(); description != test.expected {
+			t.Fatalf("%s.%s: %q instead of %q", test.functionGroup, test.function, description, test.expected)
+		}
+	}
+}
+
+//endregion
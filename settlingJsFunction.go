@@ -0,0 +1,168 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package progpAPI
+
+import (
+	"context"
+	"sync"
+)
+
+// The async functions return before their work is done, and call their callback once
+// it's done. SettlingJsFunction wraps this callback, which allows the generated code
+// to release the resources of the call, like his context.Context, once it settles.
+
+// SettlingJsFunction is a callback calling hooks after the first time it's called,
+// which is when the async call settles.
+type SettlingJsFunction struct {
+	inner       JsFunction
+	mutex       sync.Mutex
+	isSettled   bool
+	isKeptAlive bool
+	onSettled   []func(err error)
+}
+
+// WatchJsFunctionSettling wraps the callback of an async call.
+// It's used by the generated code.
+func WatchJsFunctionSettling(callback JsFunction) *SettlingJsFunction {
+	return &SettlingJsFunction{inner: callback}
+}
+
+// OnSettled adds a function which is called once the callback is called for the first time.
+// It receives the error given to the callback, or nil.
+// If the callback is already called, the function is called now.
+func (m *SettlingJsFunction) OnSettled(f func(err error)) {
+	m.mutex.Lock()
+
+	if !m.isSettled {
+		m.onSettled = append(m.onSettled, f)
+		m.mutex.Unlock()
+		return
+	}
+
+	m.mutex.Unlock()
+	f(nil)
+}
+
+// CancelOnSettled calls cancel once the call settles, which releases the context.Context
+// of the call. If the callback is kept alive, the function continues to use his context
+// after the first call, then it's only released by his deadline.
+func (m *SettlingJsFunction) CancelOnSettled(cancel context.CancelFunc) {
+	m.OnSettled(func(err error) {
+		m.mutex.Lock()
+		isKeptAlive := m.isKeptAlive
+		m.mutex.Unlock()
+
+		if !isKeptAlive {
+			cancel()
+		}
+	})
+}
+
+func (m *SettlingJsFunction) settle(err error) {
+	m.mutex.Lock()
+
+	if m.isSettled {
+		m.mutex.Unlock()
+		return
+	}
+
+	m.isSettled = true
+	hooks := m.onSettled
+	m.onSettled = nil
+	m.mutex.Unlock()
+
+	for _, hook := range hooks {
+		hook(err)
+	}
+}
+
+func (m *SettlingJsFunction) unwrapJsFunction() JsFunction {
+	return m.inner
+}
+
+func (m *SettlingJsFunction) CallWithUndefined() {
+	m.inner.CallWithUndefined()
+	m.settle(nil)
+}
+
+func (m *SettlingJsFunction) CallWithError(err error) {
+	m.inner.CallWithError(err)
+	m.settle(err)
+}
+
+func (m *SettlingJsFunction) KeepAlive() {
+	m.mutex.Lock()
+	m.isKeptAlive = true
+	m.mutex.Unlock()
+
+	m.inner.KeepAlive()
+}
+
+func (m *SettlingJsFunction) DynamicFunctionCaller(values ...any) {
+	var err error
+
+	if len(values) != 0 {
+		err, _ = values[0].(error)
+	}
+
+	m.inner.DynamicFunctionCaller(values...)
+	m.settle(err)
+}
+
+func (m *SettlingJsFunction) EnabledResourcesAutoDisposing(currentResourceContainer *SharedResourceContainer) {
+	m.inner.EnabledResourcesAutoDisposing(currentResourceContainer)
+}
+
+func (m *SettlingJsFunction) CallWithArrayBuffer2(buffer []byte) {
+	m.inner.CallWithArrayBuffer2(buffer)
+	m.settle(nil)
+}
+
+func (m *SettlingJsFunction) CallWithString2(value string) {
+	m.inner.CallWithString2(value)
+	m.settle(nil)
+}
+
+func (m *SettlingJsFunction) CallWithStringBuffer2(value []byte) {
+	m.inner.CallWithStringBuffer2(value)
+	m.settle(nil)
+}
+
+func (m *SettlingJsFunction) CallWithDouble1(value float64) {
+	m.inner.CallWithDouble1(value)
+	m.settle(nil)
+}
+
+func (m *SettlingJsFunction) CallWithDouble2(value float64) {
+	m.inner.CallWithDouble2(value)
+	m.settle(nil)
+}
+
+func (m *SettlingJsFunction) CallWithBool2(value bool) {
+	m.inner.CallWithBool2(value)
+	m.settle(nil)
+}
+
+func (m *SettlingJsFunction) CallWithResource1(value *SharedResource) {
+	m.inner.CallWithResource1(value)
+	m.settle(nil)
+}
+
+func (m *SettlingJsFunction) CallWithResource2(value *SharedResource) {
+	m.inner.CallWithResource2(value)
+	m.settle(nil)
+}
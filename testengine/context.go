@@ -0,0 +1,541 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/progpjs/progpAPI/v2"
+)
+
+//region Context
+
+// Context is a script context of the test engine. The script is terminated once it's
+// executed and his ref count is zero, which simulates the end of the async functions:
+// calling an async function increases the ref count, and calling his callback decreases it.
+type Context struct {
+	engine        *Engine
+	securityGroup string
+	resources     *progpAPI.SharedResourceContainer
+
+	refCount     int
+	isExecuted   bool
+	isTerminated bool
+	isDisposed   bool
+
+	scriptPath    string
+	scriptError   *progpAPI.JsErrorMessage
+	runtimeErrors []*progpAPI.JsErrorMessage
+	terminated    chan struct{}
+
+	mutex sync.Mutex
+}
+
+func newContext(engine *Engine, securityGroup string) *Context {
+	res := &Context{
+		engine:        engine,
+		securityGroup: securityGroup,
+		terminated:    make(chan struct{}),
+	}
+
+	res.resources = progpAPI.NewSharedResourceContainer(nil, res)
+	return res
+}
+
+func (m *Context) GetScriptEngine() progpAPI.ScriptEngine {
+	return m.engine
+}
+
+func (m *Context) GetSecurityGroup() string {
+	return m.securityGroup
+}
+
+// GetResourceContainer returns the container given to the functions
+// receiving a *progpAPI.SharedResourceContainer.
+func (m *Context) GetResourceContainer() *progpAPI.SharedResourceContainer {
+	return m.resources
+}
+
+// ExecuteScript calls the runner set with Engine.SetScriptRunner.
+// The script content isn't executed, since there is no javascript interpreter.
+func (m *Context) ExecuteScript(scriptContent string, compiledFilePath string, sourceScriptPath string, sourceMap string) *progpAPI.JsErrorMessage {
+	m.mutex.Lock()
+
+	if m.isExecuted {
+		m.mutex.Unlock()
+		return m.newErrorMessage("the script context has already executed a script")
+	}
+
+	m.isExecuted = true
+	m.scriptPath = sourceScriptPath
+	m.mutex.Unlock()
+
+	var res *progpAPI.JsErrorMessage
+
+	if runner := m.engine.getScriptRunner(); runner != nil {
+		if err := runner(m, scriptContent, sourceScriptPath); err != nil {
+			res = m.ThrowRuntimeError(err.Error())
+		}
+	}
+
+	m.checkTerminated()
+	return res
+}
+
+func (m *Context) ExecuteScriptFile(scriptPath string, onCompiledSuccess func()) *progpAPI.JsErrorMessage {
+	content, err := readScriptFile(scriptPath)
+	if err != nil {
+		return m.newErrorMessage(err.Error())
+	}
+
+	if onCompiledSuccess != nil {
+		onCompiledSuccess()
+	}
+
+	return m.ExecuteScript(content, scriptPath, scriptPath, "")
+}
+
+// ExecuteChildScriptFile calls the runner set with Engine.SetScriptRunner, within this context.
+func (m *Context) ExecuteChildScriptFile(scriptPath string) error {
+	content, err := readScriptFile(scriptPath)
+	if err != nil {
+		return err
+	}
+
+	if runner := m.engine.getScriptRunner(); runner != nil {
+		return runner(m, content, scriptPath)
+	}
+
+	return nil
+}
+
+func (m *Context) TryDispose() bool {
+	m.mutex.Lock()
+
+	if m.isDisposed {
+		m.mutex.Unlock()
+		return true
+	}
+
+	m.isDisposed = true
+	m.mutex.Unlock()
+
+	m.resources.Dispose()
+	m.engine.removeContext(m)
+	progpAPI.OnScriptContextDisposed(m)
+
+	return true
+}
+
+func (m *Context) IsDisposed() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.isDisposed
+}
+
+func (m *Context) DisarmError(error *progpAPI.JsErrorMessage) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.scriptError == error {
+		m.scriptError = nil
+	}
+}
+
+func (m *Context) IncreaseRefCount() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.refCount++
+}
+
+func (m *Context) DecreaseRefCount() {
+	m.mutex.Lock()
+
+	if m.refCount > 0 {
+		m.refCount--
+	}
+
+	m.mutex.Unlock()
+	m.checkTerminated()
+}
+
+func (m *Context) GetRefCount() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.refCount
+}
+
+//endregion
+
+//region Errors and termination
+
+// ThrowRuntimeError simulates an uncaught javascript error. The error is given to the
+// handler set with Engine.SetRuntimeErrorHandler. If it isn't handled, the script
+// is terminated with this error, where the real engines print it and exit.
+func (m *Context) ThrowRuntimeError(message string) *progpAPI.JsErrorMessage {
+	jsError := m.newErrorMessage(message)
+
+	m.mutex.Lock()
+	m.runtimeErrors = append(m.runtimeErrors, jsError)
+	m.mutex.Unlock()
+
+	if handler := m.engine.getRuntimeErrorHandler(); (handler != nil) && handler(m, jsError) {
+		return jsError
+	}
+
+	m.mutex.Lock()
+	m.scriptError = jsError
+	m.isExecuted = true
+	m.refCount = 0
+	m.mutex.Unlock()
+
+	m.checkTerminated()
+	return jsError
+}
+
+// GetRuntimeErrors returns all the errors thrown with ThrowRuntimeError, handled or not.
+func (m *Context) GetRuntimeErrors() []*progpAPI.JsErrorMessage {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]*progpAPI.JsErrorMessage(nil), m.runtimeErrors...)
+}
+
+// IsTerminated returns true once the script is executed and his ref count is zero.
+func (m *Context) IsTerminated() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.isTerminated
+}
+
+// WaitTerminated waits until the script is terminated, and returns his error,
+// which is the one returned by the handler set with Engine.SetScriptTerminatedHandler.
+// Returns false if the timeout is reached.
+func (m *Context) WaitTerminated(timeout time.Duration) (*progpAPI.JsErrorMessage, bool) {
+	select {
+	case <-m.terminated:
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		return m.scriptError, true
+	case <-time.After(timeout):
+		return nil, false
+	}
+}
+
+func (m *Context) checkTerminated() {
+	m.mutex.Lock()
+
+	if !m.isExecuted || m.isTerminated || (m.refCount != 0) {
+		m.mutex.Unlock()
+		return
+	}
+
+	m.isTerminated = true
+	scriptError := m.scriptError
+	m.mutex.Unlock()
+
+	if handler := m.engine.getScriptTerminatedHandler(); handler != nil {
+		scriptError = handler(m, m.scriptPath, scriptError)
+
+		m.mutex.Lock()
+		m.scriptError = scriptError
+		m.mutex.Unlock()
+	}
+
+	close(m.terminated)
+}
+
+func (m *Context) newErrorMessage(message string) *progpAPI.JsErrorMessage {
+	res := progpAPI.NewScriptErrorMessage(m)
+	res.Error = message
+	res.ScriptPath = m.scriptPath
+
+	return res
+}
+
+//endregion
+
+//region Calling functions
+
+// Call calls a registered function from his javascript name, with the arguments of
+// the Go function, without the implicit ones which are given by the engine.
+// The values are converted like javascript would do for the numbers, a nil value
+// is allowed for the nullable parameters, and the trailing ones can be omitted.
+// Where the Go function expects a progpAPI.JsFunction, a *Function can be given,
+// which is required for the callback of an async function.
+//
+// The function is called synchronously, even if it returns a promise. The results
+// are returned without the error, which is returned apart. A panic is returned as an error.
+// The interceptors and the allowed functions checker are used like by the real engines.
+func (m *Context) Call(functionGroup string, jsFunctionName string, args ...any) (results []any, err error) {
+	fct := progpAPI.GetFunctionRegistry().GetFunction(functionGroup, jsFunctionName)
+
+	if fct == nil {
+		return nil, errors.New("function " + functionGroup + "." + jsFunctionName + " isn't registered")
+	}
+
+	checkedName := jsFunctionName
+
+	if fct.IsClassMethod() {
+		// Like with the real engines, the methods are allowed with their class.
+		checkedName = fct.ClassName
+	}
+
+	if !m.engine.IsFunctionAllowed(m.securityGroup, functionGroup, checkedName) {
+		return nil, errors.New("function " + functionGroup + "." + jsFunctionName + " isn't allowed for the security group " + m.securityGroup)
+	}
+
+	callArgs, cancel, err := m.buildCallArgs(fct, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var settling *progpAPI.SettlingJsFunction
+
+	if fct.IsAsync {
+		var callback *Function
+
+		if len(args) != 0 {
+			callback, _ = args[len(args)-1].(*Function)
+		}
+
+		if callback == nil {
+			cancel()
+			return nil, errors.New("function " + functionGroup + "." + jsFunctionName + " is async, his last argument must be a *Function")
+		}
+
+		// The reference is kept until the callback is called, and the context
+		// is released like the generated code does.
+		m.IncreaseRefCount()
+		releaseRef := sync.OnceFunc(m.DecreaseRefCount)
+
+		settling = progpAPI.WatchJsFunctionSettling(callback)
+		settling.CancelOnSettled(cancel)
+		callArgs[len(callArgs)-1] = reflect.ValueOf(settling)
+
+		defer func() {
+			// The callback won't be called if the call has failed. Otherwise the reference
+			// is released after the other hooks, like the one of the interceptors.
+			if err != nil {
+				releaseRef()
+				cancel()
+			} else {
+				settling.OnSettled(func(error) { releaseRef() })
+			}
+		}()
+	} else {
+		if fct.IsPromise {
+			m.IncreaseRefCount()
+			defer m.DecreaseRefCount()
+		}
+
+		defer cancel()
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			results = nil
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+
+	var interceptedCall *progpAPI.FunctionCall
+
+	if progpAPI.HasCallInterceptors() {
+		anyArgs := make([]any, len(callArgs))
+
+		for i, callArg := range callArgs {
+			anyArgs[i] = callArg.Interface()
+		}
+
+		if interceptedCall, err = progpAPI.InterceptCall(functionGroup, jsFunctionName, m, anyArgs...); err != nil {
+			return nil, err
+		}
+
+		if settling != nil {
+			defer interceptedCall.DoneWhenSettled(settling)
+		} else {
+			defer interceptedCall.Done()
+		}
+
+		// Is waited synchronously, like the rest of the call.
+		if err = interceptedCall.WaitDelay(); err != nil {
+			interceptedCall.SetError(err)
+			return nil, err
+		}
+	}
+
+	resValues, errorMsg := progpAPI.DynamicCallFunction(fct.GoFunctionRef, callArgs)
+
+	if errorMsg != "" {
+		err = errors.New(errorMsg)
+		interceptedCall.SetError(err)
+		return nil, err
+	}
+
+	for i, resValue := range resValues {
+		if i == fct.GoFunctionInfos.ReturnErrorOffset {
+			if !resValue.IsNil() {
+				err = resValue.Interface().(error)
+				interceptedCall.SetError(err)
+			}
+		} else {
+			results = append(results, resValue.Interface())
+		}
+	}
+
+	return results, err
+}
+
+// buildCallArgs converts the arguments of a call, and adds the implicit ones.
+// The cancel function releases the context.Context given to the function, if any.
+func (m *Context) buildCallArgs(fct *progpAPI.RegisteredFunction, args []any) ([]reflect.Value, context.CancelFunc, error) {
+	infos := fct.GoFunctionInfos
+	cancel := func() {}
+
+	var res []reflect.Value
+	argIndex := 0
+	lastOffset := len(infos.ParamTypeRefs) - 1
+
+	for offset, paramType := range infos.ParamTypeRefs {
+		if paramType == reflect.TypeOf(m.resources) {
+			res = append(res, reflect.ValueOf(m.resources))
+			continue
+		}
+
+		if paramType == reflect.TypeOf((*context.Context)(nil)).Elem() {
+			var ctx context.Context
+			ctx, cancel = progpAPI.NewCallContext(m, fct.CallTimeout)
+			res = append(res, reflect.ValueOf(&ctx).Elem())
+			continue
+		}
+
+		if infos.IsVariadic && (offset == lastOffset) {
+			for ; argIndex < len(args); argIndex++ {
+				value, err := m.toParamValue(fct, argIndex, args[argIndex], paramType.Elem())
+				if err != nil {
+					return nil, nil, err
+				}
+
+				res = append(res, value)
+			}
+
+			break
+		}
+
+		if argIndex >= len(args) {
+			if infos.ParamNullable[offset] {
+				res = append(res, reflect.Zero(paramType))
+				continue
+			}
+
+			return nil, nil, errors.New("function " + fct.JsFunctionName + " expects at least " + strconv.Itoa(infos.MinArgCount) + " arguments")
+		}
+
+		value, err := m.toParamValue(fct, argIndex, args[argIndex], paramType)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		res = append(res, value)
+		argIndex++
+	}
+
+	if argIndex < len(args) {
+		return nil, nil, errors.New("function " + fct.JsFunctionName + " expects at most " + strconv.Itoa(argIndex) + " arguments")
+	}
+
+	return res, cancel, nil
+}
+
+// toParamValue converts an argument to the type of the parameter.
+func (m *Context) toParamValue(fct *progpAPI.RegisteredFunction, argIndex int, arg any, paramType reflect.Type) (reflect.Value, error) {
+	newError := func(reason string) error {
+		return errors.New("function " + fct.JsFunctionName + ": argument " + strconv.Itoa(argIndex+1) + " " + reason)
+	}
+
+	if arg == nil {
+		switch paramType.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map, reflect.Func:
+			return reflect.Zero(paramType), nil
+		}
+
+		if progpAPI.IsOptionalType(paramType) {
+			return reflect.Zero(paramType), nil
+		}
+
+		return reflect.Value{}, newError("can't be nil")
+	}
+
+	value := reflect.ValueOf(arg)
+
+	if value.Type().AssignableTo(paramType) {
+		return value, nil
+	}
+
+	if progpAPI.IsOptionalType(paramType) {
+		inner, err := m.toParamValue(fct, argIndex, arg, paramType.Field(0).Type)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		res := reflect.New(paramType).Elem()
+		res.Field(0).Set(inner)
+		res.Field(1).SetBool(true)
+
+		return res, nil
+	}
+
+	if (paramType.Kind() == reflect.Pointer) && (value.Kind() != reflect.Pointer) {
+		inner, err := m.toParamValue(fct, argIndex, arg, paramType.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		res := reflect.New(paramType.Elem())
+		res.Elem().Set(inner)
+
+		return res, nil
+	}
+
+	if isNumberKind(value.Kind()) && isNumberKind(paramType.Kind()) {
+		return value.Convert(paramType), nil
+	}
+
+	if (value.Kind() == reflect.String) && (paramType.Kind() == reflect.String) {
+		return value.Convert(paramType), nil
+	}
+
+	return reflect.Value{}, newError("of type " + value.Type().String() + " can't be given as " + paramType.String())
+}
+
+func isNumberKind(kind reflect.Kind) bool {
+	return (kind >= reflect.Int) && (kind <= reflect.Float64)
+}
+
+//endregion
@@ -0,0 +1,235 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package testengine is a script engine implemented in pure Go, which allows testing
+// the Go functions exposed to javascript without building the V8 engine.
+//
+// It doesn't execute javascript. The tests call the registered functions by their
+// javascript name with Go values, see Context.Call, and give Function objects where
+// a javascript function is expected, which records how it's called.
+//
+//	engine := progpAPI.UseScriptEngine(testengine.EngineName).(*testengine.Engine)
+//	ctx := engine.CreateNewScriptContext("tenant", false).(*testengine.Context)
+//	results, err := ctx.Call("fs", "readFile", "data.txt")
+package testengine
+
+import (
+	"os"
+	"sync"
+
+	"github.com/progpjs/progpAPI/v2"
+)
+
+// EngineName is the name of the engine for progpAPI.UseScriptEngine.
+const EngineName = "progpTestEngine"
+
+func init() {
+	progpAPI.ConfigRegisterScriptEngineBuilder(EngineName, func() progpAPI.ScriptEngine {
+		return NewEngine()
+	})
+}
+
+// ScriptRunnerF simulates the execution of a script. Returning an error is like
+// javascript throwing an uncaught error.
+type ScriptRunnerF func(ctx *Context, scriptContent string, scriptPath string) error
+
+//region Engine
+
+type Engine struct {
+	isStarted  bool
+	isShutdown bool
+
+	contexts        []*Context
+	scriptRunner    ScriptRunnerF
+	functionCallers map[string]any
+
+	runtimeErrorHandler     progpAPI.RuntimeErrorHandlerF
+	scriptTerminatedHandler progpAPI.ScriptTerminatedHandlerF
+	allowedFunctionsChecker progpAPI.CheckAllowedFunctionsF
+
+	mutex sync.Mutex
+}
+
+func NewEngine() *Engine {
+	return &Engine{functionCallers: make(map[string]any)}
+}
+
+func (m *Engine) Start() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.isStarted = true
+}
+
+func (m *Engine) IsStarted() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.isStarted
+}
+
+func (m *Engine) GetEngineLanguage() string {
+	return "javascript"
+}
+
+func (m *Engine) GetEngineName() string {
+	return EngineName
+}
+
+func (m *Engine) WaitDebuggerReady() {
+}
+
+func (m *Engine) GetInternalEngineVersion() string {
+	return "1.0.0"
+}
+
+// Shutdown disposes all the script contexts. The engine can't be used anymore after that.
+func (m *Engine) Shutdown() {
+	m.mutex.Lock()
+
+	if m.isShutdown {
+		m.mutex.Unlock()
+		return
+	}
+
+	m.isShutdown = true
+	contexts := m.contexts
+	m.contexts = nil
+	m.mutex.Unlock()
+
+	for _, ctx := range contexts {
+		ctx.TryDispose()
+	}
+
+	progpAPI.OnScriptEngineShutdown(m)
+}
+
+func (m *Engine) IsShutdown() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.isShutdown
+}
+
+func (m *Engine) CreateNewScriptContext(securityGroup string, mustDebug bool) progpAPI.JsContext {
+	ctx := newContext(m, securityGroup)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.contexts = append(m.contexts, ctx)
+	return ctx
+}
+
+func (m *Engine) SetRuntimeErrorHandler(handler progpAPI.RuntimeErrorHandlerF) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.runtimeErrorHandler = handler
+}
+
+func (m *Engine) SetScriptTerminatedHandler(handler progpAPI.ScriptTerminatedHandlerF) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.scriptTerminatedHandler = handler
+}
+
+func (m *Engine) SetAllowedFunctionsChecker(handler progpAPI.CheckAllowedFunctionsF) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.allowedFunctionsChecker = handler
+}
+
+// SetScriptRunner sets the function simulating the execution of the scripts.
+// Without runner, executing a script does nothing.
+func (m *Engine) SetScriptRunner(runner ScriptRunnerF) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.scriptRunner = runner
+}
+
+// SetFunctionCaller sets the function returned by GetFunctionCaller for this signature.
+func (m *Engine) SetFunctionCaller(functionSignature string, caller any) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.functionCallers[functionSignature] = caller
+}
+
+// GetFunctionCaller returns the function set with SetFunctionCaller, or nil.
+func (m *Engine) GetFunctionCaller(functionSignature string) any {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.functionCallers[functionSignature]
+}
+
+// IsFunctionAllowed returns true if the scripts of this security group can call
+// this function, according to the checker set with SetAllowedFunctionsChecker.
+func (m *Engine) IsFunctionAllowed(securityGroup string, functionGroup string, functionName string) bool {
+	m.mutex.Lock()
+	checker := m.allowedFunctionsChecker
+	m.mutex.Unlock()
+
+	return (checker == nil) || checker(securityGroup, functionGroup, functionName)
+}
+
+func (m *Engine) getScriptRunner() ScriptRunnerF {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.scriptRunner
+}
+
+func (m *Engine) getRuntimeErrorHandler() progpAPI.RuntimeErrorHandlerF {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.runtimeErrorHandler
+}
+
+func (m *Engine) getScriptTerminatedHandler() progpAPI.ScriptTerminatedHandlerF {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.scriptTerminatedHandler
+}
+
+func (m *Engine) removeContext(ctx *Context) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i, e := range m.contexts {
+		if e == ctx {
+			m.contexts = append(m.contexts[:i], m.contexts[i+1:]...)
+			return
+		}
+	}
+}
+
+func readScriptFile(scriptPath string) (string, error) {
+	content, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+//endregion
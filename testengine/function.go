@@ -0,0 +1,201 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testengine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/progpjs/progpAPI/v2"
+)
+
+//region Function
+
+// Invocation is a call of a Function by Go.
+type Invocation struct {
+	// Method is the name of the method of progpAPI.JsFunction, for example "CallWithString2".
+	Method string
+	Args   []any
+}
+
+// GetError returns the error given to CallWithError, or nil.
+func (m Invocation) GetError() error {
+	if (m.Method == "CallWithError") && (len(m.Args) == 1) {
+		err, _ := m.Args[0].(error)
+		return err
+	}
+
+	return nil
+}
+
+// Function is given where a Go function expects a javascript function.
+// It records how it's called, which allows checking the results of the async functions.
+//
+// Like a real javascript function, it's disposed after the first call unless KeepAlive is called.
+// The calls done once disposed are still recorded.
+type Function struct {
+	isKeptAlive   bool
+	isDisposed    bool
+	autoDisposing *progpAPI.SharedResourceContainer
+
+	invocations []Invocation
+	mutex       sync.Mutex
+	cond        *sync.Cond
+}
+
+// NewFunction creates a function which can be given to Context.Call.
+func (m *Context) NewFunction() *Function {
+	res := &Function{}
+	res.cond = sync.NewCond(&res.mutex)
+
+	return res
+}
+
+func (m *Function) record(method string, args ...any) {
+	m.mutex.Lock()
+
+	m.invocations = append(m.invocations, Invocation{Method: method, Args: args})
+
+	if !m.isKeptAlive {
+		m.isDisposed = true
+	}
+
+	m.cond.Broadcast()
+	m.mutex.Unlock()
+}
+
+// GetInvocations returns the calls done, in their order.
+func (m *Function) GetInvocations() []Invocation {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]Invocation(nil), m.invocations...)
+}
+
+// WaitInvocations waits until the function has been called at least this number of times,
+// which is useful when the call is done from a goroutine. Returns false if the timeout is reached.
+func (m *Function) WaitInvocations(count int, timeout time.Duration) bool {
+	timer := time.AfterFunc(timeout, func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		m.cond.Broadcast()
+	})
+
+	defer timer.Stop()
+
+	deadline := time.Now().Add(timeout)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for len(m.invocations) < count {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+
+		m.cond.Wait()
+	}
+
+	return true
+}
+
+func (m *Function) IsDisposed() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.isDisposed
+}
+
+func (m *Function) IsKeptAlive() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.isKeptAlive
+}
+
+// GetAutoDisposingContainer returns the container given to EnabledResourcesAutoDisposing, or nil.
+func (m *Function) GetAutoDisposingContainer() *progpAPI.SharedResourceContainer {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.autoDisposing
+}
+
+//endregion
+
+//region progpAPI.JsFunction
+
+func (m *Function) CallWithUndefined() {
+	m.record("CallWithUndefined")
+}
+
+func (m *Function) CallWithError(err error) {
+	m.record("CallWithError", err)
+}
+
+func (m *Function) KeepAlive() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.isKeptAlive = true
+}
+
+func (m *Function) DynamicFunctionCaller(values ...any) {
+	m.record("DynamicFunctionCaller", values...)
+}
+
+func (m *Function) EnabledResourcesAutoDisposing(currentResourceContainer *progpAPI.SharedResourceContainer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.autoDisposing = currentResourceContainer
+}
+
+func (m *Function) CallWithArrayBuffer2(buffer []byte) {
+	m.record("CallWithArrayBuffer2", buffer)
+}
+
+func (m *Function) CallWithString2(value string) {
+	m.record("CallWithString2", value)
+}
+
+func (m *Function) CallWithStringBuffer2(value []byte) {
+	m.record("CallWithStringBuffer2", value)
+}
+
+func (m *Function) CallWithDouble1(value float64) {
+	m.record("CallWithDouble1", value)
+}
+
+func (m *Function) CallWithDouble2(value float64) {
+	m.record("CallWithDouble2", value)
+}
+
+func (m *Function) CallWithBool2(value bool) {
+	m.record("CallWithBool2", value)
+}
+
+func (m *Function) CallWithResource1(value *progpAPI.SharedResource) {
+	m.record("CallWithResource1", value)
+}
+
+func (m *Function) CallWithResource2(value *progpAPI.SharedResource) {
+	m.record("CallWithResource2", value)
+}
+
+//endregion
@@ -0,0 +1,792 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testengine_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/progpjs/progpAPI/v2"
+	"github.com/progpjs/progpAPI/v2/testengine"
+)
+
+//region Test functions
+
+func SampleAdd(a int, b int) int {
+	return a + b
+}
+
+func SampleParseValue(value string) (string, error) {
+	if value == "" {
+		return "", errors.New("the value is empty")
+	}
+
+	return strings.ToUpper(value), nil
+}
+
+func SamplePanic() {
+	panic("something went wrong")
+}
+
+// gAsyncRelease allows the tests to choose when SampleWaitAsync calls his callback.
+var gAsyncRelease = make(chan string)
+
+func SampleWaitAsync(callback progpAPI.JsFunction) {
+	go func() {
+		callback.CallWithString2(<-gAsyncRelease)
+	}()
+}
+
+func SampleFailAsync(callback progpAPI.JsFunction) error {
+	return errors.New("can't start")
+}
+
+func SampleKeepCallback(count int, callback progpAPI.JsFunction) {
+	callback.KeepAlive()
+
+	for i := 0; i < count; i++ {
+		callback.CallWithDouble2(float64(i))
+	}
+}
+
+func SampleOptionalArgs(name string, age *int, title progpAPI.Optional[string]) string {
+	res := name
+
+	if age != nil {
+		res += " " + strings.Repeat("*", *age)
+	}
+
+	if value, isSet := title.Get(); isSet {
+		res = value + " " + res
+	}
+
+	return res
+}
+
+func SampleJoin(separator string, parts ...string) string {
+	return strings.Join(parts, separator)
+}
+
+func SampleSum(values ...int64) int64 {
+	var res int64
+
+	for _, value := range values {
+		res += value
+	}
+
+	return res
+}
+
+func SampleHasDeadline(ctx context.Context) bool {
+	_, hasDeadline := ctx.Deadline()
+	return hasDeadline
+}
+
+func SampleWaitContextAsync(ctx context.Context, callback progpAPI.JsFunction) {
+	go func() {
+		<-ctx.Done()
+		callback.CallWithError(ctx.Err())
+	}()
+}
+
+func SampleIntercepted(value string) string {
+	gInterceptedCalls++
+	return value
+}
+
+var gInterceptedCalls int
+
+func SampleAudited(secret string, value int) error {
+	if value < 0 {
+		return errors.New("the value is negative")
+	}
+
+	return nil
+}
+
+func SampleAuditedAsync(callback progpAPI.JsFunction) {
+	go callback.CallWithError(errors.New("failed later"))
+}
+
+func SamplePing() string {
+	return "pong"
+}
+
+func SamplePromisePing() (string, error) {
+	return "pong", nil
+}
+
+func init() {
+	module := progpAPI.GetFunctionRegistry().UseGoNamespace("github.com/progpjs/progpAPI/v2/testengine_test")
+
+	calls := module.UseCustomGroup("testCalls")
+	calls.AddFunction("add", "SampleAdd", SampleAdd)
+	calls.AddFunction("parseValue", "SampleParseValue", SampleParseValue)
+	calls.AddFunction("panic", "SamplePanic", SamplePanic)
+	calls.AddAsyncFunction("wait", "SampleWaitAsync", SampleWaitAsync)
+	calls.AddAsyncFunction("fail", "SampleFailAsync", SampleFailAsync)
+	calls.AddFunction("keepCallback", "SampleKeepCallback", SampleKeepCallback)
+
+	args := module.UseCustomGroup("testArgs")
+	args.AddFunction("optionalArgs", "SampleOptionalArgs", SampleOptionalArgs)
+	args.AddFunction("join", "SampleJoin", SampleJoin)
+	args.AddFunction("sum", "SampleSum", SampleSum).WithBigInt()
+
+	contexts := module.UseCustomGroup("testContexts")
+	contexts.AddFunction("hasDeadline", "SampleHasDeadline", SampleHasDeadline)
+	contexts.AddFunction("hasDeadlineWithTimeout", "SampleHasDeadline", SampleHasDeadline).WithTimeout(time.Minute)
+	contexts.AddAsyncFunction("waitContext", "SampleWaitContextAsync", SampleWaitContextAsync)
+
+	module.UseCustomGroup("testInterceptors").AddFunction("intercepted", "SampleIntercepted", SampleIntercepted)
+
+	audited := module.UseCustomGroup("testAudit")
+	audited.AddFunction("audited", "SampleAudited", SampleAudited)
+	audited.AddAsyncFunction("auditedAsync", "SampleAuditedAsync", SampleAuditedAsync)
+
+	limits := module.UseCustomGroup("testLimits")
+	limits.AddFunction("ping", "SamplePing", SamplePing)
+	limits.AddPromiseFunction("promisePing", "SamplePromisePing", SamplePromisePing)
+
+	if err := progpAPI.GetFunctionRegistry().Validate(); err != nil {
+		panic(err)
+	}
+}
+
+//endregion
+
+//region Helpers
+
+func newTestContext(securityGroup string) (*testengine.Engine, *testengine.Context) {
+	engine := testengine.NewEngine()
+	ctx := engine.CreateNewScriptContext(securityGroup, false).(*testengine.Context)
+
+	return engine, ctx
+}
+
+var gSecurityGroupCounter atomic.Int32
+
+// uniqueSecurityGroup returns a security group which isn't used by the other tests, nor by the
+// previous runs of a test, since the interceptors and the call limits are global.
+func uniqueSecurityGroup(name string) string {
+	return name + strconv.Itoa(int(gSecurityGroupCounter.Add(1)))
+}
+
+func mustCall(t *testing.T, ctx *testengine.Context, functionGroup string, jsFunctionName string, args ...any) []any {
+	t.Helper()
+
+	results, err := ctx.Call(functionGroup, jsFunctionName, args...)
+	if err != nil {
+		t.Fatalf("%s.%s: unexpected error: %s", functionGroup, jsFunctionName, err)
+	}
+
+	return results
+}
+
+func expectCallError(t *testing.T, ctx *testengine.Context, expected string, functionGroup string, jsFunctionName string, args ...any) error {
+	t.Helper()
+
+	_, err := ctx.Call(functionGroup, jsFunctionName, args...)
+
+	if err == nil {
+		t.Fatalf("%s.%s: an error was expected", functionGroup, jsFunctionName)
+	}
+
+	if !strings.Contains(err.Error(), expected) {
+		t.Fatalf("%s.%s: the error %q doesn't contain %q", functionGroup, jsFunctionName, err.Error(), expected)
+	}
+
+	return err
+}
+
+//endregion
+
+//region Engine
+
+func TestEngineIsRegistered(t *testing.T) {
+	engine := progpAPI.UseScriptEngine(testengine.EngineName)
+
+	if engine == nil {
+		t.Fatal("the test engine isn't registered")
+	}
+
+	if engine.GetEngineName() != testengine.EngineName {
+		t.Fatalf("unexpected engine name %q", engine.GetEngineName())
+	}
+}
+
+//endregion
+
+//region Call
+
+func TestCallConvertsNumbers(t *testing.T) {
+	_, ctx := newTestContext("")
+
+	// Javascript only has doubles.
+	results := mustCall(t, ctx, "testCalls", "add", 2.0, 3)
+
+	if (len(results) != 1) || (results[0] != 5) {
+		t.Fatalf("unexpected results %v", results)
+	}
+}
+
+func TestCallReturnsTheErrorApart(t *testing.T) {
+	_, ctx := newTestContext("")
+
+	results := mustCall(t, ctx, "testCalls", "parseValue", "abc")
+
+	if (len(results) != 1) || (results[0] != "ABC") {
+		t.Fatalf("unexpected results %v", results)
+	}
+
+	expectCallError(t, ctx, "the value is empty", "testCalls", "parseValue", "")
+}
+
+func TestCallErrors(t *testing.T) {
+	_, ctx := newTestContext("")
+
+	expectCallError(t, ctx, "isn't registered", "testCalls", "unknown")
+	expectCallError(t, ctx, "panic: something went wrong", "testCalls", "panic")
+	expectCallError(t, ctx, "expects at least 2 arguments", "testCalls", "add", 1)
+	expectCallError(t, ctx, "expects at most 2 arguments", "testCalls", "add", 1, 2, 3)
+	expectCallError(t, ctx, "argument 2 of type string can't be given as int", "testCalls", "add", 1, "2")
+	expectCallError(t, ctx, "his last argument must be a *Function", "testCalls", "wait", nil)
+}
+
+func TestCallChecksAllowedFunctions(t *testing.T) {
+	engine, ctx := newTestContext("restricted")
+
+	engine.SetAllowedFunctionsChecker(func(securityGroup string, functionGroup string, functionName string) bool {
+		return (securityGroup != "restricted") || (functionName != "add")
+	})
+
+	expectCallError(t, ctx, "isn't allowed for the security group restricted", "testCalls", "add", 1, 2)
+	mustCall(t, ctx, "testCalls", "parseValue", "abc")
+}
+
+//endregion
+
+//region Ref count and termination
+
+func TestAsyncCallKeepsTheScriptAlive(t *testing.T) {
+	engine, ctx := newTestContext("")
+
+	var terminatedPath string
+	var terminatedCount int
+
+	engine.SetScriptTerminatedHandler(func(jsCtx progpAPI.JsContext, scriptPath string, scriptError *progpAPI.JsErrorMessage) *progpAPI.JsErrorMessage {
+		terminatedPath = scriptPath
+		terminatedCount++
+		return scriptError
+	})
+
+	callback := ctx.NewFunction()
+
+	engine.SetScriptRunner(func(ctx *testengine.Context, scriptContent string, scriptPath string) error {
+		_, err := ctx.Call("testCalls", "wait", callback)
+		return err
+	})
+
+	if jsError := ctx.ExecuteScript("", "main.js", "main.js", ""); jsError != nil {
+		t.Fatalf("unexpected script error: %s", jsError.Error)
+	}
+
+	if ctx.GetRefCount() != 1 {
+		t.Fatalf("the ref count is %d instead of 1", ctx.GetRefCount())
+	}
+
+	if ctx.IsTerminated() {
+		t.Fatal("the script is terminated before the callback is called")
+	}
+
+	gAsyncRelease <- "done"
+
+	if !callback.WaitInvocations(1, 5*time.Second) {
+		t.Fatal("the callback isn't called")
+	}
+
+	if _, isTerminated := ctx.WaitTerminated(5 * time.Second); !isTerminated {
+		t.Fatal("the script isn't terminated once the callback is called")
+	}
+
+	if (terminatedCount != 1) || (terminatedPath != "main.js") {
+		t.Fatalf("the terminated handler is called %d times with %q", terminatedCount, terminatedPath)
+	}
+
+	if ctx.GetRefCount() != 0 {
+		t.Fatalf("the ref count is %d instead of 0", ctx.GetRefCount())
+	}
+}
+
+func TestFailedAsyncCallReleasesTheRefCount(t *testing.T) {
+	_, ctx := newTestContext("")
+
+	callback := ctx.NewFunction()
+	expectCallError(t, ctx, "can't start", "testCalls", "fail", callback)
+
+	if ctx.GetRefCount() != 0 {
+		t.Fatalf("the ref count is %d instead of 0", ctx.GetRefCount())
+	}
+
+	if len(callback.GetInvocations()) != 0 {
+		t.Fatal("the callback of a failed call is called")
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	engine, ctx := newTestContext("")
+
+	var handled []string
+
+	engine.SetRuntimeErrorHandler(func(jsCtx progpAPI.JsContext, error *progpAPI.JsErrorMessage) bool {
+		handled = append(handled, error.Error)
+		return error.Error == "handled"
+	})
+
+	ctx.IncreaseRefCount()
+	ctx.ThrowRuntimeError("handled")
+
+	if ctx.IsTerminated() {
+		t.Fatal("a handled error terminates the script")
+	}
+
+	ctx.ThrowRuntimeError("not handled")
+
+	scriptError, isTerminated := ctx.WaitTerminated(time.Second)
+
+	if !isTerminated || (scriptError == nil) || (scriptError.Error != "not handled") {
+		t.Fatal("an unhandled error doesn't terminate the script with this error")
+	}
+
+	if (len(handled) != 2) || (len(ctx.GetRuntimeErrors()) != 2) {
+		t.Fatalf("the errors aren't all recorded: %v", handled)
+	}
+}
+
+func TestScriptRunnerError(t *testing.T) {
+	engine, ctx := newTestContext("")
+
+	engine.SetScriptRunner(func(ctx *testengine.Context, scriptContent string, scriptPath string) error {
+		return errors.New("uncaught")
+	})
+
+	jsError := ctx.ExecuteScript("", "main.js", "main.js", "")
+
+	if (jsError == nil) || (jsError.Error != "uncaught") {
+		t.Fatal("the error of the runner isn't returned")
+	}
+
+	if !ctx.IsTerminated() {
+		t.Fatal("the script isn't terminated")
+	}
+
+	if ctx.ExecuteScript("", "main.js", "main.js", "") == nil {
+		t.Fatal("a script context can execute a second script")
+	}
+}
+
+//endregion
+
+//region Callbacks
+
+func TestCallbackIsDisposedAfterTheFirstCall(t *testing.T) {
+	_, ctx := newTestContext("")
+
+	callback := ctx.NewFunction()
+	callback.CallWithError(errors.New("failure"))
+	callback.CallWithUndefined()
+
+	invocations := callback.GetInvocations()
+
+	if len(invocations) != 2 {
+		t.Fatalf("%d invocations are recorded instead of 2", len(invocations))
+	}
+
+	if (invocations[0].Method != "CallWithError") || (invocations[0].GetError() == nil) || (invocations[0].GetError().Error() != "failure") {
+		t.Fatalf("unexpected first invocation %+v", invocations[0])
+	}
+
+	if (invocations[1].Method != "CallWithUndefined") || (invocations[1].GetError() != nil) {
+		t.Fatalf("unexpected second invocation %+v", invocations[1])
+	}
+
+	if !callback.IsDisposed() || callback.IsKeptAlive() {
+		t.Fatal("the callback isn't disposed after his first call")
+	}
+}
+
+func TestCallbackKeptAlive(t *testing.T) {
+	_, ctx := newTestContext("")
+
+	callback := ctx.NewFunction()
+	mustCall(t, ctx, "testCalls", "keepCallback", 3, callback)
+
+	invocations := callback.GetInvocations()
+
+	if len(invocations) != 3 {
+		t.Fatalf("%d invocations are recorded instead of 3", len(invocations))
+	}
+
+	for i, invocation := range invocations {
+		if (invocation.Method != "CallWithDouble2") || (invocation.Args[0] != float64(i)) {
+			t.Fatalf("unexpected invocation %+v", invocation)
+		}
+	}
+
+	if callback.IsDisposed() || !callback.IsKeptAlive() {
+		t.Fatal("the callback kept alive is disposed")
+	}
+}
+
+func TestWaitInvocationsTimeout(t *testing.T) {
+	_, ctx := newTestContext("")
+
+	if ctx.NewFunction().WaitInvocations(1, 10*time.Millisecond) {
+		t.Fatal("WaitInvocations returns true without invocation")
+	}
+}
+
+//endregion
+
+//region Optional and variadic parameters
+
+func TestOptionalParameters(t *testing.T) {
+	_, ctx := newTestContext("")
+
+	tests := []struct {
+		args     []any
+		expected string
+	}{
+		{[]any{"bob"}, "bob"},
+		{[]any{"bob", nil}, "bob"},
+		{[]any{"bob", nil, nil}, "bob"},
+		{[]any{"bob", 2}, "bob **"},
+		{[]any{"bob", nil, "dr"}, "dr bob"},
+		{[]any{"bob", 1, ""}, " bob *"},
+	}
+
+	for _, test := range tests {
+		results := mustCall(t, ctx, "testArgs", "optionalArgs", test.args...)
+
+		if results[0] != test.expected {
+			t.Fatalf("%v gives %q instead of %q", test.args, results[0], test.expected)
+		}
+	}
+
+	expectCallError(t, ctx, "expects at least 1 arguments", "testArgs", "optionalArgs")
+	expectCallError(t, ctx, "argument 1 can't be nil", "testArgs", "optionalArgs", nil)
+}
+
+func TestVariadicParameters(t *testing.T) {
+	_, ctx := newTestContext("")
+
+	if results := mustCall(t, ctx, "testArgs", "join", ","); results[0] != "" {
+		t.Fatalf("unexpected result %q without rest argument", results[0])
+	}
+
+	if results := mustCall(t, ctx, "testArgs", "join", ",", "a", "b", "c"); results[0] != "a,b,c" {
+		t.Fatalf("unexpected result %q", results[0])
+	}
+
+	if results := mustCall(t, ctx, "testArgs", "sum", 1, 2.0, int64(1)<<40); results[0] != int64(1)<<40+3 {
+		t.Fatalf("unexpected result %v", results[0])
+	}
+
+	expectCallError(t, ctx, "argument 3 of type int can't be given as string", "testArgs", "join", ",", "a", 5)
+}
+
+//endregion
+
+//region context.Context
+
+func TestContextIsInjected(t *testing.T) {
+	_, ctx := newTestContext("")
+
+	if results := mustCall(t, ctx, "testContexts", "hasDeadline"); results[0] != false {
+		t.Fatal("the context has a deadline without WithTimeout")
+	}
+
+	if results := mustCall(t, ctx, "testContexts", "hasDeadlineWithTimeout"); results[0] != true {
+		t.Fatal("the context has no deadline with WithTimeout")
+	}
+}
+
+func TestContextIsCancelledOnDispose(t *testing.T) {
+	_, ctx := newTestContext("")
+
+	callback := ctx.NewFunction()
+	mustCall(t, ctx, "testContexts", "waitContext", callback)
+
+	if len(callback.GetInvocations()) != 0 {
+		t.Fatal("the context is cancelled before the dispose")
+	}
+
+	ctx.TryDispose()
+
+	if !callback.WaitInvocations(1, 5*time.Second) {
+		t.Fatal("the context isn't cancelled when the script context is disposed")
+	}
+
+	if !errors.Is(callback.GetInvocations()[0].GetError(), context.Canceled) {
+		t.Fatalf("unexpected error %v", callback.GetInvocations()[0].GetError())
+	}
+}
+
+func TestContextIsCancelledOnShutdown(t *testing.T) {
+	engine, ctx := newTestContext("")
+
+	callback := ctx.NewFunction()
+	mustCall(t, ctx, "testContexts", "waitContext", callback)
+
+	engine.Shutdown()
+
+	if !callback.WaitInvocations(1, 5*time.Second) {
+		t.Fatal("the context isn't cancelled when the engine shuts down")
+	}
+
+	if !engine.IsShutdown() || !ctx.IsDisposed() {
+		t.Fatal("the engine shutdown doesn't dispose the script contexts")
+	}
+}
+
+//endregion
+
+//region Interceptors
+
+var gInterceptorOnce sync.Once
+var gInterceptedArgs = make(map[string][][]any)
+
+// useTestInterceptor installs an interceptor which only concerns the group testInterceptors,
+// since the interceptors can't be removed and apply to all the tests.
+func useTestInterceptor() {
+	gInterceptorOnce.Do(func() {
+		progpAPI.GetFunctionRegistry().Use(func(call *progpAPI.FunctionCall) error {
+			if call.Function.Group != "testInterceptors" {
+				return nil
+			}
+
+			gInterceptedArgs[call.SecurityGroup] = append(gInterceptedArgs[call.SecurityGroup], call.Args)
+
+			if call.Args[0] == "forbidden" {
+				return errors.New("the value is forbidden")
+			}
+
+			return nil
+		})
+	})
+}
+
+func TestInterceptors(t *testing.T) {
+	useTestInterceptor()
+	securityGroup := uniqueSecurityGroup("intercepting")
+	_, ctx := newTestContext(securityGroup)
+	callCount := gInterceptedCalls
+
+	if results := mustCall(t, ctx, "testInterceptors", "intercepted", "allowed"); results[0] != "allowed" {
+		t.Fatalf("unexpected result %v", results[0])
+	}
+
+	expectCallError(t, ctx, "the value is forbidden", "testInterceptors", "intercepted", "forbidden")
+
+	if gInterceptedCalls != callCount+1 {
+		t.Fatal("the function is called when the interceptor returns an error")
+	}
+
+	intercepted := gInterceptedArgs[securityGroup]
+
+	if (len(intercepted) != 2) || (len(intercepted[1]) != 1) || (intercepted[1][0] != "forbidden") {
+		t.Fatalf("the interceptor receives the arguments %v", intercepted)
+	}
+}
+
+//endregion
+
+//region Audit
+
+var gAuditOnce sync.Once
+var gAuditSink = progpAPI.NewRingBufferAuditSink(100)
+
+func useTestAuditLog() {
+	gAuditOnce.Do(func() {
+		auditLog := progpAPI.NewAuditLog(gAuditSink)
+		auditLog.SetFilter(func(fct *progpAPI.RegisteredFunction) bool { return fct.Group == "testAudit" })
+		auditLog.SetRedaction("testAudit.audited", progpAPI.RedactArgs(0))
+
+		progpAPI.GetFunctionRegistry().Use(auditLog.Interceptor())
+	})
+}
+
+func TestAuditLog(t *testing.T) {
+	useTestAuditLog()
+	securityGroup := uniqueSecurityGroup("audited")
+	_, ctx := newTestContext(securityGroup)
+
+	getEntries := func() []*progpAPI.AuditEntry {
+		var res []*progpAPI.AuditEntry
+
+		for _, entry := range gAuditSink.GetEntries() {
+			if entry.SecurityGroup == securityGroup {
+				res = append(res, entry)
+			}
+		}
+
+		return res
+	}
+
+	mustCall(t, ctx, "testAudit", "audited", "password", 1)
+	expectCallError(t, ctx, "the value is negative", "testAudit", "audited", "password", -1)
+
+	callback := ctx.NewFunction()
+	mustCall(t, ctx, "testAudit", "auditedAsync", callback)
+	callback.WaitInvocations(1, 5*time.Second)
+
+	// Is recorded once the callback is settled, which can be a little after his call.
+	deadline := time.Now().Add(5 * time.Second)
+
+	for (len(getEntries()) < 3) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	entries := getEntries()
+
+	if len(entries) != 3 {
+		t.Fatalf("%d entries are recorded instead of 3", len(entries))
+	}
+
+	first := entries[0]
+
+	if (first.FunctionGroup != "testAudit") || (first.Function != "audited") || (first.Outcome != progpAPI.AuditOutcomeSuccess) {
+		t.Fatalf("unexpected entry %+v", first)
+	}
+
+	if (len(first.Args) != 2) || (first.Args[0] != "[REDACTED]") || (first.Args[1] != 1) {
+		t.Fatalf("the arguments aren't redacted: %v", first.Args)
+	}
+
+	if (entries[1].Outcome != progpAPI.AuditOutcomeError) || (entries[1].Error != "the value is negative") {
+		t.Fatalf("unexpected entry %+v", entries[1])
+	}
+
+	if (entries[2].Function != "auditedAsync") || (entries[2].Outcome != progpAPI.AuditOutcomeError) || (entries[2].Error != "failed later") {
+		t.Fatalf("the async call isn't recorded with the error of his callback: %+v", entries[2])
+	}
+}
+
+//endregion
+
+//region Call limits
+
+func getCallLimitCounter(securityGroup string, jsFunctionName string) *progpAPI.CallLimitCounter {
+	for _, counter := range progpAPI.GetFunctionRegistry().GetCallLimitCounters() {
+		if (counter.SecurityGroup == securityGroup) && (counter.FunctionGroup == "testLimits") && (counter.Function == jsFunctionName) {
+			return &counter
+		}
+	}
+
+	return nil
+}
+
+func TestCallQuota(t *testing.T) {
+	securityGroup := uniqueSecurityGroup("quota")
+	progpAPI.GetFunctionRegistry().SetCallQuota(securityGroup, "testLimits.ping", 2)
+	_, ctx := newTestContext(securityGroup)
+
+	mustCall(t, ctx, "testLimits", "ping")
+	mustCall(t, ctx, "testLimits", "ping")
+	err := expectCallError(t, ctx, progpAPI.ErrorCodeQuotaExceeded, "testLimits", "ping")
+
+	var limitError *progpAPI.CallLimitError
+
+	if !errors.As(err, &limitError) || (limitError.SecurityGroup != securityGroup) {
+		t.Fatalf("the error isn't a CallLimitError: %v", err)
+	}
+
+	counter := getCallLimitCounter(securityGroup, "ping")
+
+	if (counter == nil) || (counter.Calls != 2) || (counter.Rejected != 1) || (counter.Quota != 2) {
+		t.Fatalf("unexpected counter %+v", counter)
+	}
+
+	progpAPI.GetFunctionRegistry().ResetCallQuotas(securityGroup)
+	mustCall(t, ctx, "testLimits", "ping")
+
+	// The other security groups aren't limited.
+	_, otherCtx := newTestContext(uniqueSecurityGroup("other"))
+
+	for i := 0; i < 3; i++ {
+		mustCall(t, otherCtx, "testLimits", "ping")
+	}
+}
+
+func TestRejectedAsyncCallReleasesTheRefCount(t *testing.T) {
+	securityGroup := uniqueSecurityGroup("asyncQuota")
+	progpAPI.GetFunctionRegistry().SetCallQuota(securityGroup, "testCalls.wait", 0)
+	progpAPI.GetFunctionRegistry().SetCallQuota(securityGroup, "testLimits.promisePing", 0)
+	_, ctx := newTestContext(securityGroup)
+
+	callback := ctx.NewFunction()
+	expectCallError(t, ctx, progpAPI.ErrorCodeQuotaExceeded, "testCalls", "wait", callback)
+	expectCallError(t, ctx, progpAPI.ErrorCodeQuotaExceeded, "testLimits", "promisePing")
+
+	// Like with the generated code, the reference taken for the callback
+	// is released when the call is rejected.
+	if ctx.GetRefCount() != 0 {
+		t.Fatalf("the ref count is %d instead of 0", ctx.GetRefCount())
+	}
+
+	if len(callback.GetInvocations()) != 0 {
+		t.Fatal("the callback of a rejected call is called")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	securityGroup := uniqueSecurityGroup("rate")
+	progpAPI.GetFunctionRegistry().SetRateLimit(securityGroup, "testLimits.ping", progpAPI.RateLimit{CallsPerSecond: 0.001})
+	_, ctx := newTestContext(securityGroup)
+
+	mustCall(t, ctx, "testLimits", "ping")
+	expectCallError(t, ctx, progpAPI.ErrorCodeRateLimited, "testLimits", "ping")
+}
+
+func TestRateLimitDelaysTheCalls(t *testing.T) {
+	securityGroup := uniqueSecurityGroup("delay")
+	progpAPI.GetFunctionRegistry().SetRateLimit(securityGroup, "testLimits.*", progpAPI.RateLimit{CallsPerSecond: 20, MaxDelay: 5 * time.Second})
+	_, ctx := newTestContext(securityGroup)
+
+	// Can't be delayed, since it would block javascript.
+	mustCall(t, ctx, "testLimits", "ping")
+	expectCallError(t, ctx, progpAPI.ErrorCodeRateLimited, "testLimits", "ping")
+
+	start := time.Now()
+	mustCall(t, ctx, "testLimits", "promisePing")
+	mustCall(t, ctx, "testLimits", "promisePing")
+
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("the calls returning a promise aren't delayed")
+	}
+
+	if counter := getCallLimitCounter(securityGroup, "promisePing"); (counter == nil) || (counter.Delayed == 0) || (counter.Rejected != 0) {
+		t.Fatalf("unexpected counter %+v", counter)
+	}
+}
+
+//endregion
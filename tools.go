@@ -43,6 +43,14 @@ func CatchFatalErrors() {
 	}
 }
 
+// RejectOnPanic is like CatchFatalErrors, but the error is sent to the callback,
+// which allows rejecting the promise waiting for the result of a function.
+func RejectOnPanic(callback JsFunction) {
+	if err := recover(); err != nil {
+		callback.CallWithError(fmt.Errorf("%v", err))
+	}
+}
+
 //endregion
 
 //region TaskQueue